@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"ultahost-ai-gateway/internal/config"
+	"ultahost-ai-gateway/internal/scheduler"
 	"ultahost-ai-gateway/internal/server"
 	"ultahost-ai-gateway/internal/websocket"
 
@@ -19,19 +25,71 @@ func main() {
 	// Load environment variables
 	config.LoadConfig()
 
+	// agent ownership shared with other gateway nodes
+	websocket.StartBroker()
+
 	// Initialize server
 	s := server.NewServer()
 
 	// Register all routes
 	server.RegisterRoutes(s.Engine)
 
+	// recurring tasks and alerts
+	scheduler.Start()
+
+	// tasks waiting for offline agents
+	websocket.StartTaskQueue()
+
 	//server starting
-	go wsTlsInit()
+	agentServer := wsTlsInit()
+	go func() {
+		log.Println("Starting TLS WebSocket server on https://localhost:8443 ...")
+		err := agentServer.ListenAndServeTLS("./certs/server.crt", "./certs/server.key")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	go func() {
+		log.Printf(" Server starting on port %s...\n", config.AppConfig.Port)
+		if err := s.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf(" Server failed to start: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop() // a second signal kills the process
+	shutdown(s, agentServer)
+}
 
-	log.Printf(" Server starting on port %s...\n", config.AppConfig.Port)
-	if err := s.Start(); err != nil {
-		log.Fatalf(" Server failed to start: %v", err)
+// shutdown stops taking new agents and requests, gives running tasks until
+// the grace period to finish, then closes the agent connections asking the
+// agents to reconnect shortly.
+func shutdown(s *server.Server, agentServer *http.Server) {
+	grace := websocket.ShutdownGrace()
+	log.Printf("shutting down, waiting up to %s for running tasks", grace)
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// hijacked websocket connections are not closed by Shutdown; Drain
+	// closes them once their tasks are done
+	if err := agentServer.Shutdown(ctx); err != nil {
+		log.Printf("agent server shutdown: %v", err)
 	}
+	apiDone := make(chan struct{})
+	go func() {
+		defer close(apiDone)
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("server shutdown: %v", err)
+		}
+	}()
+	if cut := websocket.Drain(ctx, "server restarting"); cut > 0 {
+		log.Printf("%d tasks were still running at shutdown", cut)
+	}
+	<-apiDone
+	log.Println("shutdown complete")
 
 }
 
@@ -40,7 +98,8 @@ var upgrader = ws.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func wsTlsInit() {
+// wsTlsInit builds the mutual-TLS server agents connect to; main serves it.
+func wsTlsInit() *http.Server {
 	// connectedVPS := make(map[string]*websocket.Conn)
 	// Load CA cert to verify client certs (mutual TLS)
 	caCertPEM, err := ioutil.ReadFile("./certs/ca.crt")
@@ -71,9 +130,5 @@ func wsTlsInit() {
 		TLSConfig: tlsConfig,
 	}
 
-	log.Println("Starting TLS WebSocket server on https://localhost:8443 ...")
-	err = server.ListenAndServeTLS("./certs/server.crt", "./certs/server.key")
-	if err != nil {
-		log.Fatalf("Server failed: %v", err)
-	}
+	return server
 }
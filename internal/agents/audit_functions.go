@@ -0,0 +1,322 @@
+package agents
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/tasks"
+)
+
+// ports that should never be reachable from the internet
+var sensitivePorts = map[int]string{
+	3306:  "MySQL",
+	5432:  "PostgreSQL",
+	6379:  "Redis",
+	11211: "Memcached",
+	27017: "MongoDB",
+	9200:  "Elasticsearch",
+}
+
+// ports a public web server is expected to expose
+var expectedPorts = map[int]bool{22: true, 25: true, 80: true, 443: true, 465: true, 587: true, 993: true}
+
+// AuditFinding is a security finding with the points it costs
+type AuditFinding struct {
+	Finding
+	Check  string `json:"check"`
+	Points int    `json:"points"`
+}
+
+// AuditReport is returned as Reply.Data by the security_audit workflow and
+// by the audit API
+type AuditReport struct {
+	VPSID      string         `json:"vps_id"`
+	Score      int            `json:"score"` // 0-100
+	Grade      string         `json:"grade"` // A-F
+	Incomplete bool           `json:"incomplete,omitempty"`
+	AuditedAt  time.Time      `json:"audited_at"`
+	Checks     []CheckResult  `json:"checks"`
+	Findings   []AuditFinding `json:"findings"` // most points first
+}
+
+type auditCheck struct {
+	name string
+	run  func(vpsId string) (CheckResult, []AuditFinding)
+}
+
+var auditChecks = []auditCheck{
+	{"listening ports", auditPorts},
+	{"ssh configuration", auditSSH},
+	{"failed logins", auditFailedLogins},
+	{"security updates", auditUpdates},
+	{"web root permissions", auditWebRoots},
+	{"firewall", auditFirewall},
+}
+
+func init() {
+	registerWorkflow("security_audit", workflow{
+		Description: "audit the server's security (open ports, SSH settings, failed logins, pending security updates, file permissions, firewall) and grade it, e.g. \"is my server secure?\"",
+		Run: func(req *models.ChatRequest, vpsId string, _ []string) (Reply, error) {
+			report := RunSecurityAudit(vpsId)
+			return Reply{Text: formatAudit(report), Data: report}, nil
+		},
+	})
+}
+
+// AuditVPS checks that the user owns vpsId before auditing it.
+func AuditVPS(userToken, vpsId string) (AuditReport, error) {
+	if _, err := getVPS(userToken, vpsId); err != nil {
+		return AuditReport{}, err
+	}
+	return RunSecurityAudit(vpsId), nil
+}
+
+// RunSecurityAudit runs the read-only audit checks concurrently and scores
+// the findings. Checks that can't run are listed but not scored.
+func RunSecurityAudit(vpsId string) AuditReport {
+	report := AuditReport{VPSID: vpsId, AuditedAt: time.Now().UTC(), Checks: make([]CheckResult, len(auditChecks))}
+	findings := make([][]AuditFinding, len(auditChecks))
+
+	var wg sync.WaitGroup
+	for i, c := range auditChecks {
+		wg.Add(1)
+		go func(i int, c auditCheck) {
+			defer wg.Done()
+			report.Checks[i], findings[i] = c.run(vpsId)
+			report.Checks[i].Name = c.name
+		}(i, c)
+	}
+	wg.Wait()
+
+	report.Findings = []AuditFinding{}
+	lost := 0
+	for i, fs := range findings {
+		if strings.HasPrefix(report.Checks[i].Detail, "could not run") {
+			report.Incomplete = true
+		}
+		for _, f := range fs {
+			f.Check = auditChecks[i].name
+			lost += f.Points
+			report.Findings = append(report.Findings, f)
+		}
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool { return report.Findings[i].Points > report.Findings[j].Points })
+
+	report.Score = 100 - lost
+	if report.Score < 0 {
+		report.Score = 0
+	}
+	report.Grade = auditGrade(report.Score)
+	return report
+}
+
+func auditGrade(score int) string {
+	switch {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	}
+	return "F"
+}
+
+func auditFinding(severity string, points int, title, evidence, fix string) AuditFinding {
+	return AuditFinding{Finding: Finding{Severity: severity, Title: title, Evidence: evidence, Fix: fix}, Points: points}
+}
+
+func checkPassed(findings []AuditFinding, detail string) CheckResult {
+	return CheckResult{Passed: len(findings) == 0, Detail: detail}
+}
+
+func isPublicAddress(addr string) bool {
+	switch addr {
+	case "0.0.0.0", "*", "[::]", "::":
+		return true
+	}
+	return !strings.HasPrefix(addr, "127.") && addr != "[::1]" && addr != "::1" && !strings.HasSuffix(addr, "%lo")
+}
+
+func auditPorts(vpsId string) (CheckResult, []AuditFinding) {
+	ports, err := listeningPorts(vpsId)
+	if err != nil {
+		return failedCheck(err), nil
+	}
+	var findings []AuditFinding
+	var unexpected []string
+	seen := map[int]bool{}
+	for _, p := range ports.Ports {
+		if !isPublicAddress(p.Address) || seen[p.Port] {
+			continue
+		}
+		seen[p.Port] = true
+		if name, ok := sensitivePorts[p.Port]; ok {
+			findings = append(findings, auditFinding(SeverityCritical, 15,
+				fmt.Sprintf("%s is reachable from the internet", name),
+				fmt.Sprintf("%s listens on %s:%d", p.Process, p.Address, p.Port),
+				fmt.Sprintf("Bind %s to 127.0.0.1 or block port %d in the firewall.", name, p.Port)))
+		} else if !expectedPorts[p.Port] {
+			unexpected = append(unexpected, fmt.Sprintf("%d (%s)", p.Port, p.Process))
+		}
+	}
+	if len(unexpected) > 0 {
+		points := 2 * len(unexpected)
+		if points > 10 {
+			points = 10
+		}
+		findings = append(findings, auditFinding(SeverityWarning, points,
+			"Unexpected public ports",
+			strings.Join(unexpected, ", "),
+			"Close ports you don't need, or restrict them to known IPs in the firewall."))
+	}
+	return checkPassed(findings, fmt.Sprintf("%d public ports", len(seen))), findings
+}
+
+func auditSSH(vpsId string) (CheckResult, []AuditFinding) {
+	v, err := runParsed(vpsId, "check_ssh_config", nil)
+	if err != nil {
+		return failedCheck(err), nil
+	}
+	cfg := v.(tasks.SSHConfig)
+	var findings []AuditFinding
+	if cfg.PermitEmptyPasswords {
+		findings = append(findings, auditFinding(SeverityCritical, 25,
+			"SSH allows empty passwords", "PermitEmptyPasswords yes",
+			"Set PermitEmptyPasswords no in /etc/ssh/sshd_config and reload sshd."))
+	}
+	if cfg.PermitRootLogin == "yes" {
+		findings = append(findings, auditFinding(SeverityCritical, 15,
+			"Root can log in over SSH", "PermitRootLogin yes",
+			"Log in as a regular user with sudo and set PermitRootLogin no (or prohibit-password)."))
+	}
+	if cfg.PasswordAuthentication {
+		findings = append(findings, auditFinding(SeverityWarning, 10,
+			"SSH password authentication is enabled", "PasswordAuthentication yes",
+			"Use SSH keys and set PasswordAuthentication no."))
+	}
+	if cfg.MaxAuthTries > 6 {
+		findings = append(findings, auditFinding(SeverityInfo, 2,
+			"SSH allows many authentication attempts", fmt.Sprintf("MaxAuthTries %d", cfg.MaxAuthTries),
+			"Set MaxAuthTries 3 to slow down guessing."))
+	}
+	return checkPassed(findings, fmt.Sprintf("port %d, root login %s, password auth %t", cfg.Port, cfg.PermitRootLogin, cfg.PasswordAuthentication)), findings
+}
+
+func auditFailedLogins(vpsId string) (CheckResult, []AuditFinding) {
+	v, err := runParsedOrEmpty(vpsId, "check_failed_logins", []string{"1d"}, tasks.FailedLogins{})
+	if err != nil {
+		return failedCheck(err), nil
+	}
+	fl := v.(tasks.FailedLogins)
+	var findings []AuditFinding
+	var top []string
+	for i, s := range fl.Sources {
+		if i == 3 {
+			break
+		}
+		top = append(top, fmt.Sprintf("%s (%d)", s.IP, s.Count))
+	}
+	switch {
+	case fl.Total >= 1000:
+		findings = append(findings, auditFinding(SeverityWarning, 10,
+			"SSH is being brute-forced",
+			fmt.Sprintf("%d failed logins in the last day, top sources: %s", fl.Total, strings.Join(top, ", ")),
+			"Install fail2ban, disable password authentication, or move SSH behind the firewall."))
+	case fl.Total >= 100:
+		findings = append(findings, auditFinding(SeverityInfo, 3,
+			"Repeated failed SSH logins",
+			fmt.Sprintf("%d failed logins in the last day, top sources: %s", fl.Total, strings.Join(top, ", ")),
+			"Consider fail2ban to block repeat offenders."))
+	}
+	return checkPassed(findings, fmt.Sprintf("%d failed logins in the last day", fl.Total)), findings
+}
+
+func auditUpdates(vpsId string) (CheckResult, []AuditFinding) {
+	v, err := runParsedOrEmpty(vpsId, "check_security_updates", nil, tasks.SecurityUpdates{})
+	if err != nil {
+		return failedCheck(err), nil
+	}
+	up := v.(tasks.SecurityUpdates)
+	n := len(up.Packages)
+	var findings []AuditFinding
+	if n > 0 {
+		severity, points := SeverityWarning, 8
+		if n > 5 {
+			severity, points = SeverityCritical, 15
+		}
+		findings = append(findings, auditFinding(severity, points,
+			fmt.Sprintf("%d security updates pending", n),
+			strings.Join(truncateList(up.Packages, 10), ", "),
+			"Apply updates (apt-get upgrade / dnf upgrade --security) and enable unattended security upgrades."))
+	}
+	return checkPassed(findings, fmt.Sprintf("%d pending", n)), findings
+}
+
+func auditWebRoots(vpsId string) (CheckResult, []AuditFinding) {
+	v, err := runParsedOrEmpty(vpsId, "check_webroot_permissions", nil, tasks.WritablePaths{})
+	if err != nil {
+		return failedCheck(err), nil
+	}
+	wp := v.(tasks.WritablePaths)
+	var findings []AuditFinding
+	if len(wp.Paths) > 0 {
+		findings = append(findings, auditFinding(SeverityCritical, 15,
+			"World-writable files in the web root",
+			fmt.Sprintf("%d paths, e.g. %s", len(wp.Paths), strings.Join(truncateList(wp.Paths, 5), ", ")),
+			"Remove the world-writable bit (chmod o-w) and give write access only to the web server user where needed."))
+	}
+	return checkPassed(findings, fmt.Sprintf("%d world-writable paths", len(wp.Paths))), findings
+}
+
+func auditFirewall(vpsId string) (CheckResult, []AuditFinding) {
+	v, err := runParsed(vpsId, "check_firewall", nil)
+	if err != nil {
+		return failedCheck(err), nil
+	}
+	fw := v.(tasks.FirewallState)
+	var findings []AuditFinding
+	if fw.Backend == "none" || !fw.Active {
+		findings = append(findings, auditFinding(SeverityCritical, 15,
+			"No active firewall", "firewall backend: "+fw.Backend,
+			"Enable a firewall that allows only SSH, HTTP and HTTPS (e.g. ufw allow OpenSSH, ufw allow 'Nginx Full', ufw enable)."))
+	}
+	return checkPassed(findings, fmt.Sprintf("%s, active %t, %d rules", fw.Backend, fw.Active, len(fw.Rules))), findings
+}
+
+func truncateList(items []string, n int) []string {
+	if len(items) <= n {
+		return items
+	}
+	return append(append([]string(nil), items[:n]...), fmt.Sprintf("and %d more", len(items)-n))
+}
+
+func formatAudit(r AuditReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Security grade for VPS %s: %s (%d/100)", r.VPSID, r.Grade, r.Score)
+	if r.Incomplete {
+		b.WriteString(" - some checks could not run, see below")
+	}
+	b.WriteString("\n")
+	if len(r.Findings) == 0 {
+		b.WriteString("No issues found.\n")
+	}
+	for i, f := range r.Findings {
+		fmt.Fprintf(&b, "%d. [%s, -%d] %s\n   Evidence: %s\n   Fix: %s\n", i+1, f.Severity, f.Points, f.Title, f.Evidence, f.Fix)
+	}
+	b.WriteString("Checks:\n")
+	for _, c := range r.Checks {
+		mark := "ok"
+		if !c.Passed {
+			mark = "!!"
+		}
+		fmt.Fprintf(&b, "  [%s] %s - %s\n", mark, c.Name, c.Detail)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,192 @@
+package agents
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+var (
+	backupsMu  sync.Mutex
+	vpsBackups = make(map[string]map[string]tasks.BackupManifest) // vpsId -> backup id -> manifest
+)
+
+func init() {
+	websocket.OnTaskFinished(trackBackups)
+
+	taskPreflight["restore_backup"] = checkRestoreTarget
+	taskPreviews["restore_backup"] = previewRestore
+	taskFormatters["backup_site"] = formatBackupCreated
+	taskFormatters["list_backups"] = formatBackupList
+	taskFormatters["prune_backups"] = formatPrune
+	taskFormatters["restore_backup"] = formatRestore
+}
+
+// trackBackups keeps the per-VPS backup index in step with every backup
+// task that completes, whoever dispatched it.
+func trackBackups(rec websocket.TaskRecord) {
+	if rec.Status != websocket.TaskStatusCompleted {
+		return
+	}
+	backupsMu.Lock()
+	defer backupsMu.Unlock()
+
+	known := vpsBackups[rec.VPSID]
+	if known == nil {
+		known = make(map[string]tasks.BackupManifest)
+		vpsBackups[rec.VPSID] = known
+	}
+	switch d := rec.Data.(type) {
+	case tasks.BackupManifest:
+		known[d.ID] = d
+	case tasks.BackupList:
+		// the list is authoritative for the site it covers
+		site := ""
+		if len(rec.Args) > 0 {
+			site = rec.Args[0]
+		}
+		for id, m := range known {
+			if site == "" || m.SitePath == site {
+				delete(known, id)
+			}
+		}
+		for _, m := range d.Backups {
+			known[m.ID] = m
+		}
+	case tasks.PruneResult:
+		for _, id := range d.Removed {
+			delete(known, id)
+		}
+	}
+}
+
+// TrackedBackups returns the backups the gateway knows of on vpsId, newest first.
+func TrackedBackups(vpsId string) []tasks.BackupManifest {
+	backupsMu.Lock()
+	defer backupsMu.Unlock()
+
+	out := make([]tasks.BackupManifest, 0, len(vpsBackups[vpsId]))
+	for _, m := range vpsBackups[vpsId] {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out
+}
+
+func trackedBackup(vpsId, id string) (tasks.BackupManifest, bool) {
+	backupsMu.Lock()
+	defer backupsMu.Unlock()
+	m, ok := vpsBackups[vpsId][id]
+	return m, ok
+}
+
+// checkRestoreTarget requires the backup to exist for that site, asking the
+// agent for its backup list when the gateway hasn't seen it.
+func checkRestoreTarget(vpsId string, args []string) error {
+	site, id := args[0], args[1]
+	m, ok := trackedBackup(vpsId, id)
+	if !ok {
+		if _, err := runParsedOrEmpty(vpsId, "list_backups", []string{site}, tasks.BackupList{}); err != nil {
+			return fmt.Errorf("could not list backups: %v", err)
+		}
+		if m, ok = trackedBackup(vpsId, id); !ok {
+			return fmt.Errorf("there is no backup %s for %s", id, site)
+		}
+	}
+	if m.SitePath != site {
+		return fmt.Errorf("backup %s belongs to %s, not %s", id, m.SitePath, site)
+	}
+	return nil
+}
+
+func previewRestore(vpsId string, args []string) string {
+	m, ok := trackedBackup(vpsId, args[1])
+	if !ok {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Backup %s from %s (%s):\n", m.ID, m.CreatedAt.Format("2006-01-02 15:04 MST"), humanBytes(m.Total()))
+	for _, f := range m.Files {
+		fmt.Fprintf(&b, "  %s %s sha256:%s\n", f.Kind, f.Path, f.SHA256[:12])
+	}
+	fmt.Fprintf(&b, "The current files in %s", m.SitePath)
+	if m.Database != "" {
+		fmt.Fprintf(&b, " and the %s database", m.Database)
+	}
+	b.WriteString(" will be replaced.")
+	return b.String()
+}
+
+func formatBackupCreated(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("backup_site", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	m := v.(tasks.BackupManifest)
+	return fmt.Sprintf("Backup %s of %s created (%s, %d files with checksums).", m.ID, m.SitePath, humanBytes(m.Total()), len(m.Files))
+}
+
+func formatBackupList(_ string, res websocket.TaskResult) string {
+	if res.ExitCode == 0 && strings.TrimSpace(res.Output()) == "" {
+		return "There are no backups on this server yet."
+	}
+	v, err := tasks.ParseOutput("list_backups", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	list := v.(tasks.BackupList)
+	if len(list.Backups) == 0 {
+		return "There are no backups on this server yet."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d backups, newest first:\n", len(list.Backups))
+	for _, m := range list.Backups {
+		fmt.Fprintf(&b, "  %s  %s  %8s  %s\n", m.ID, m.CreatedAt.Format("2006-01-02 15:04"), humanBytes(m.Total()), m.SitePath)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatPrune(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("prune_backups", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	r := v.(tasks.PruneResult)
+	if len(r.Removed) == 0 {
+		return fmt.Sprintf("Nothing to prune; %d backups kept.", len(r.Kept))
+	}
+	return fmt.Sprintf("Removed %d backups (%s freed): %s. Kept %d.", len(r.Removed), humanBytes(r.FreedBytes), strings.Join(r.Removed, ", "), len(r.Kept))
+}
+
+func formatRestore(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("restore_backup", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	r := v.(tasks.RestoreResult)
+	if !r.ChecksumVerified {
+		return fmt.Sprintf("Backup %s was not restored: its checksums did not match the manifest.", r.BackupID)
+	}
+	msg := fmt.Sprintf("Restored %s from backup %s (files: %t, database: %t).", r.SitePath, r.BackupID, r.FilesRestored, r.DatabaseRestored)
+	if r.SafetyBackupID != "" {
+		msg += fmt.Sprintf(" The previous state was saved as backup %s.", r.SafetyBackupID)
+	}
+	return msg
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,60 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// "cancel task <id>", "stop the running task", "abort task"
+var cancelCommand = regexp.MustCompile(`(?i)^\s*(cancel|stop|abort)\s+(the\s+)?(running\s+|current\s+)?task(\s+([0-9a-f]{8}-[0-9a-f-]{27}))?\s*[.!]*\s*$`)
+
+// HandleTaskCancel answers a chat cancel command. Without a task id it
+// cancels the newest unfinished task on the request's VPS. handled is false
+// when the message isn't a cancel command.
+func HandleTaskCancel(req *models.ChatRequest) (resp Reply, handled bool) {
+	m := cancelCommand.FindStringSubmatch(req.Message)
+	if m == nil {
+		return Reply{}, false
+	}
+	if req.VPSID == "" {
+		return Reply{Text: "Which server? Include vps_id to cancel one of its tasks."}, true
+	}
+	if err := checkOwnsVPS(req.UserToken, req.VPSID); err != nil {
+		if errors.Is(err, errNotYourVPS) {
+			return Reply{Text: err.Error()}, true
+		}
+		return Reply{Text: fmt.Sprintf("I couldn't check that server: %v", err)}, true
+	}
+
+	taskID := m[5]
+	if taskID == "" {
+		for _, rec := range websocket.RecentTasks(req.VPSID, 50) {
+			switch rec.Status {
+			case websocket.TaskStatusRunning, websocket.TaskStatusWaiting, websocket.TaskStatusQueued:
+				taskID = rec.TaskID
+			}
+			if taskID != "" {
+				break
+			}
+		}
+		if taskID == "" {
+			return Reply{Text: "There is no unfinished task on this server."}, true
+		}
+	} else if rec, ok := websocket.GetTask(taskID); !ok || rec.VPSID != req.VPSID {
+		return Reply{Text: fmt.Sprintf("I can't find task %s on this server.", taskID)}, true
+	}
+
+	rec, _ := websocket.GetTask(taskID)
+	sent := rec.Status == websocket.TaskStatusRunning
+	if err := websocket.CancelTask(taskID); err != nil {
+		return Reply{Text: fmt.Sprintf("I couldn't cancel %s: %v", rec.Task, err), TaskID: taskID}, true
+	}
+	if !sent {
+		return Reply{Text: fmt.Sprintf("Cancelled %s (task %s) before it reached the server.", rec.Task, taskID), TaskID: taskID}, true
+	}
+	return Reply{Text: fmt.Sprintf("Asked the server to cancel %s (task %s). Its status will change to cancelled once the agent has stopped it.", rec.Task, taskID), TaskID: taskID}, true
+}
@@ -0,0 +1,150 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// certificates expiring sooner than this are flagged for renewal
+const certRenewalWindow = 21 * 24 * time.Hour
+
+func init() {
+	taskFormatters["issue_certificate"] = formatCertificateIssued
+	taskFormatters["list_certificates"] = formatCertificateList
+
+	// chat goes through enable_https so DNS is always checked first
+	for i, name := range VPSFunctionList {
+		if name == "issue_certificate" {
+			VPSFunctionList = append(VPSFunctionList[:i], VPSFunctionList[i+1:]...)
+			break
+		}
+	}
+
+	registerWorkflow("enable_https", workflow{
+		Description: "enable HTTPS for a website: check the domain points at the server, then obtain and install a Let's Encrypt certificate",
+		Params: []tasks.Param{
+			{Name: "domain", Description: "domain to enable HTTPS for, e.g. example.com"},
+			{Name: "email", Description: "email for certificate expiry notices, if mentioned"},
+		},
+		Run: enableHTTPS,
+	})
+}
+
+func enableHTTPS(req *models.ChatRequest, vpsId string, args []string) (Reply, error) {
+	spec, _ := tasks.Lookup("issue_certificate")
+	if len(args) > 0 {
+		args[0] = strings.ToLower(strings.TrimSpace(args[0]))
+	}
+	if err := spec.ValidateArgs(args); err != nil {
+		return Reply{Text: fmt.Sprintf("I can't enable HTTPS with those details: %v", err)}, nil
+	}
+	domain := args[0]
+
+	if err := checkDomainPointsHere(req.Context(), req.UserToken, vpsId, domain); err != nil {
+		return Reply{Text: fmt.Sprintf("I can't request a certificate for %s yet: %v", domain, err)}, nil
+	}
+
+	preview := describeTask(spec, args)
+	if v, err := runParsedOrEmpty(vpsId, "list_certificates", nil, tasks.CertificateList{}); err == nil {
+		if c, ok := v.(tasks.CertificateList).For(domain); ok {
+			preview += fmt.Sprintf("\nThe current certificate for %s expires %s (%d days); it will be replaced.", domain, c.NotAfter.Format("2006-01-02"), c.DaysLeft(time.Now()))
+		}
+	}
+	summary := fmt.Sprintf("%s points at this server. This will request a Let's Encrypt certificate and reload the web server on VPS %s.", domain, vpsId)
+	return Reply{Text: stageAction(req, summary, preview, func(ctx context.Context) (Reply, error) {
+		return runTask(ctx, vpsId, spec, args)
+	})}, nil
+}
+
+// how long the gateway waits for public DNS before giving up
+const dnsCheckTimeout = 10 * time.Second
+
+// checkDomainPointsHere requires every address the domain resolves to to be
+// one of the VPS's; the CA may validate over any of them. The lookup runs on
+// the gateway, not the VPS, so it sees public DNS as the CA does rather than
+// the VPS's /etc/hosts.
+func checkDomainPointsHere(ctx context.Context, userToken, vpsId, domain string) error {
+	vps, err := getVPS(userToken, vpsId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, domain)
+	var dnsErr *net.DNSError
+	if (err == nil && len(addrs) == 0) || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return fmt.Errorf("%s does not resolve; add an A record pointing at %s", domain, vps.IPAddress)
+	}
+	if err != nil {
+		return fmt.Errorf("could not look up %s: %w", domain, err)
+	}
+
+	own := tasks.DNSResolution{Addresses: []string{vps.IPAddress, vps.IPv6Address}}
+	var foreign []string
+	for _, a := range addrs {
+		if ip := a.IP.String(); !own.Contains(ip) {
+			foreign = append(foreign, ip)
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%s resolves to %s, which is not this server (%s); update its DNS records and try again once they have propagated",
+			domain, strings.Join(foreign, ", "), vps.IPAddress)
+	}
+	return nil
+}
+
+func formatCertificateIssued(_ string, res websocket.TaskResult) string {
+	if res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	v, err := tasks.ParseOutput("issue_certificate", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	c := v.(tasks.Certificate)
+	msg := fmt.Sprintf("HTTPS is enabled for %s. The certificate expires %s (%d days) and renews automatically.",
+		strings.Join(c.Domains, ", "), c.NotAfter.Format("2006-01-02"), c.DaysLeft(time.Now()))
+	if c.WebServer != "" {
+		msg += fmt.Sprintf(" Installed in %s.", c.WebServer)
+	}
+	return msg
+}
+
+func formatCertificateList(_ string, res websocket.TaskResult) string {
+	if res.ExitCode == 0 && strings.TrimSpace(res.Output()) == "" {
+		return "There are no TLS certificates on this server."
+	}
+	v, err := tasks.ParseOutput("list_certificates", nil, res.Output())
+	if err != nil || res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	list := v.(tasks.CertificateList)
+	if len(list.Certificates) == 0 {
+		return "There are no TLS certificates on this server."
+	}
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d certificates, soonest expiry first:\n", len(list.Certificates))
+	for _, c := range list.Certificates {
+		status := ""
+		switch days := c.DaysLeft(now); {
+		case days < 0:
+			status = "  EXPIRED"
+		case c.NotAfter.Before(now.Add(certRenewalWindow)):
+			status = "  renew soon"
+		}
+		fmt.Fprintf(&b, "  %s  expires %s (%d days)%s\n", strings.Join(c.Domains, ", "), c.NotAfter.Format("2006-01-02"), c.DaysLeft(now), status)
+	}
+	if n := len(list.Expiring(now, certRenewalWindow)); n > 0 {
+		fmt.Fprintf(&b, "%d expire within %d days; check that automatic renewal is working.", n, int(certRenewalWindow.Hours()/24))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
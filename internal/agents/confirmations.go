@@ -0,0 +1,147 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// staged actions expire if the user doesn't confirm in time
+const confirmationTTL = 10 * time.Minute
+
+// pendingAction is a change that has been previewed but not applied yet
+type pendingAction struct {
+	id      string
+	convKey string
+	summary string
+	vpsId   string // the request's VPS, checked again when confirmed
+	execute func(ctx context.Context) (Reply, error)
+	expiry  time.Time
+}
+
+var (
+	pendingActionsMu sync.Mutex
+	pendingActions   = make(map[string]*pendingAction) // id -> action
+	convPending      = make(map[string]string)         // conversation key -> latest action id
+)
+
+var (
+	affirmativeReply = regexp.MustCompile(`(?i)^\s*(yes|y|yep|confirm(ed)?|go ahead|do it|apply( it)?|proceed|ok(ay)?)\s*[.!]*\s*$`)
+	negativeReply    = regexp.MustCompile(`(?i)^\s*(no|n|nope|cancel|abort|stop|don'?t)\s*[.!]*\s*$`)
+)
+
+// stageAction stores execute until the user confirms it and returns the reply
+// showing the preview. Only the latest staged action per conversation is kept.
+func stageAction(req *models.ChatRequest, summary, preview string, execute func(ctx context.Context) (Reply, error)) string {
+	pendingActionsMu.Lock()
+	defer pendingActionsMu.Unlock()
+
+	now := time.Now()
+	for id, a := range pendingActions {
+		if now.After(a.expiry) {
+			delete(pendingActions, id)
+			if convPending[a.convKey] == id {
+				delete(convPending, a.convKey)
+			}
+		}
+	}
+	key := conversationKey(req)
+	if old, ok := convPending[key]; ok {
+		delete(pendingActions, old)
+	}
+
+	id := uuid.NewString()
+	pendingActions[id] = &pendingAction{
+		id:      id,
+		convKey: key,
+		summary: summary,
+		vpsId:   req.VPSID,
+		execute: execute,
+		expiry:  now.Add(confirmationTTL),
+	}
+	convPending[key] = id
+
+	return fmt.Sprintf("%s\n\n%s\n\nReply \"yes\" to apply this change or \"no\" to cancel (expires in %d minutes).",
+		summary, strings.TrimRight(preview, "\n"), int(confirmationTTL.Minutes()))
+}
+
+// PendingConfirmationID returns the id of the action awaiting confirmation in
+// this conversation, if any.
+func PendingConfirmationID(req *models.ChatRequest) string {
+	pendingActionsMu.Lock()
+	defer pendingActionsMu.Unlock()
+
+	id, ok := convPending[conversationKey(req)]
+	if !ok {
+		return ""
+	}
+	if a, ok := pendingActions[id]; !ok || time.Now().After(a.expiry) {
+		return ""
+	}
+	return id
+}
+
+// HandleConfirmation applies or discards a staged action when the request
+// is a plain yes/no answer to one, or carries its confirmation_id with
+// confirm set. handled is false when the request has nothing to do with a
+// pending confirmation.
+func HandleConfirmation(req *models.ChatRequest) (resp Reply, handled bool, err error) {
+	key := conversationKey(req)
+
+	pendingActionsMu.Lock()
+	id := req.ConfirmationID
+	if id == "" {
+		id = convPending[key]
+	}
+	action, ok := pendingActions[id]
+	if !ok || action.convKey != key {
+		pendingActionsMu.Unlock()
+		if req.ConfirmationID != "" {
+			return Reply{Text: "That confirmation is no longer valid. Please ask again."}, true, nil
+		}
+		return Reply{}, false, nil
+	}
+
+	declined := negativeReply.MatchString(req.Message)
+	confirm := !declined && (affirmativeReply.MatchString(req.Message) || (req.ConfirmationID != "" && req.Confirm))
+	if !confirm && !declined {
+		// unrelated message; leave the action pending
+		pendingActionsMu.Unlock()
+		return Reply{}, false, nil
+	}
+
+	delete(pendingActions, id)
+	if convPending[key] == id {
+		delete(convPending, key)
+	}
+	pendingActionsMu.Unlock()
+
+	if time.Now().After(action.expiry) {
+		return Reply{Text: "That confirmation has expired. Please ask again."}, true, nil
+	}
+	if !confirm {
+		return Reply{Text: "Okay, I've cancelled that change."}, true, nil
+	}
+
+	// the VPS may have moved to another account since the change was staged
+	if action.vpsId != "" {
+		if err := checkOwnsVPS(req.UserToken, action.vpsId); err != nil {
+			if errors.Is(err, errNotYourVPS) {
+				return Reply{Text: err.Error()}, true, nil
+			}
+			return Reply{}, true, err
+		}
+	}
+
+	// tasks run under the confirming request, not the one that staged them
+	resp, err = action.execute(req.Context())
+	return resp, true, err
+}
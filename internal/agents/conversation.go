@@ -0,0 +1,140 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/pkg/models"
+)
+
+// keep transcripts bounded; tickets only need the recent context
+const maxConversationTurns = 40
+
+// conversations idle this long are forgotten; swept at most once per
+// conversationSweepInterval
+const (
+	conversationTTL           = 24 * time.Hour
+	conversationSweepInterval = time.Minute
+)
+
+type ConversationTurn struct {
+	Role    string    `json:"role"` // "user" or "assistant"
+	Content string    `json:"content"`
+	At      time.Time `json:"at"`
+}
+
+type conversation struct {
+	turns               []ConversationTurn
+	consecutiveFailures int
+	escalatedTicket     models.FlexID
+	updated             time.Time
+}
+
+var (
+	conversationsMu sync.Mutex
+	conversations   = make(map[string]*conversation)
+	lastSweep       time.Time
+)
+
+// conversationKey scopes conversations to the user so ids can't collide across accounts
+func conversationKey(req *models.ChatRequest) string {
+	return req.UserToken + "|" + req.ConversationID
+}
+
+// RecordExchange appends the user message and assistant reply to the conversation
+// and returns the number of consecutive failed replies.
+func RecordExchange(req *models.ChatRequest, reply string, failed bool) int {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+
+	now := time.Now().UTC()
+	sweepConversations(now)
+	conv := conversationFor(req)
+	conv.turns = append(conv.turns,
+		ConversationTurn{Role: "user", Content: req.Message, At: now},
+		ConversationTurn{Role: "assistant", Content: reply, At: now},
+	)
+	if len(conv.turns) > maxConversationTurns {
+		conv.turns = conv.turns[len(conv.turns)-maxConversationTurns:]
+	}
+	conv.updated = now
+
+	if failed {
+		conv.consecutiveFailures++
+	} else {
+		conv.consecutiveFailures = 0
+	}
+	return conv.consecutiveFailures
+}
+
+// Transcript renders the conversation as plain text for support tickets.
+func Transcript(req *models.ChatRequest) string {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+
+	conv, ok := conversations[conversationKey(req)]
+	if !ok {
+		return ""
+	}
+	var b strings.Builder
+	for _, t := range conv.turns {
+		fmt.Fprintf(&b, "[%s] %s: %s\n", t.At.Format(time.RFC3339), t.Role, t.Content)
+	}
+	return b.String()
+}
+
+// markEscalated remembers the escalation ticket and resets the failure streak.
+// It returns false if the conversation was already escalated.
+func markEscalated(req *models.ChatRequest, ticketID models.FlexID) bool {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+
+	now := time.Now().UTC()
+	sweepConversations(now)
+	conv := conversationFor(req)
+	conv.updated = now
+	if conv.escalatedTicket != "" {
+		return false
+	}
+	conv.escalatedTicket = ticketID
+	conv.consecutiveFailures = 0
+	return true
+}
+
+func escalatedTicket(req *models.ChatRequest) models.FlexID {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+
+	if conv, ok := conversations[conversationKey(req)]; ok {
+		return conv.escalatedTicket
+	}
+	return ""
+}
+
+// conversationFor returns req's conversation, creating it if needed.
+// Callers hold conversationsMu.
+func conversationFor(req *models.ChatRequest) *conversation {
+	key := conversationKey(req)
+	conv, ok := conversations[key]
+	if !ok {
+		conv = &conversation{}
+		conversations[key] = conv
+	}
+	return conv
+}
+
+// sweepConversations drops conversations not updated within
+// conversationTTL. Callers hold conversationsMu.
+func sweepConversations(now time.Time) {
+	if now.Sub(lastSweep) < conversationSweepInterval {
+		return
+	}
+	lastSweep = now
+	for key, conv := range conversations {
+		if now.Sub(conv.updated) > conversationTTL {
+			delete(conversations, key)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// generated credentials wait this long to be collected, once
+const credentialTTL = 15 * time.Minute
+
+// Credential is a generated secret held for out-of-band retrieval
+type Credential struct {
+	ID        string            `json:"id"`
+	VPSID     string            `json:"vps_id"`
+	Task      string            `json:"task"`
+	Values    map[string]string `json:"values"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+var (
+	credentialsMu sync.Mutex
+	credentials   = make(map[string]Credential) // id -> credential
+)
+
+func init() {
+	taskRunners["db_create"] = runDBCreate
+	taskPreviews["db_create"] = func(_ string, args []string) string {
+		return fmt.Sprintf("A password for %s will be generated on the server. It is never shown in chat; you will get a one-time link to collect it.", args[2])
+	}
+	taskPreviews["db_grant"] = previewDBGrant
+	taskFormatters["db_list"] = formatDatabaseList
+	taskFormatters["db_grant"] = formatDBGrant
+	taskFormatters["db_dump"] = formatDBDump
+	taskFormatters["db_slow_queries"] = formatSlowQueries
+}
+
+// storeCredential keeps values for vpsId until collected or expired and
+// returns the retrieval id.
+func storeCredential(vpsId, task string, values map[string]string) Credential {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+
+	now := time.Now()
+	for id, c := range credentials {
+		if now.After(c.ExpiresAt) {
+			delete(credentials, id)
+		}
+	}
+	c := Credential{
+		ID:        uuid.NewString(),
+		VPSID:     vpsId,
+		Task:      task,
+		Values:    values,
+		ExpiresAt: now.Add(credentialTTL).UTC(),
+	}
+	credentials[c.ID] = c
+	return c
+}
+
+// TakeCredential returns the credential and forgets it; it can be collected
+// only once, and only for the VPS it was generated on.
+func TakeCredential(vpsId, id string) (Credential, bool) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+
+	c, ok := credentials[id]
+	if !ok || c.VPSID != vpsId {
+		return Credential{}, false
+	}
+	delete(credentials, id)
+	if time.Now().After(c.ExpiresAt) {
+		return Credential{}, false
+	}
+	return c, true
+}
+
+// runDBCreate moves the generated password out of the result into the
+// credential store; the reply only says where to collect it.
+func runDBCreate(ctx context.Context, vpsId string, spec tasks.Spec, args []string) (Reply, error) {
+	res, err := websocket.SendSignedTaskAndWaitContext(ctx, vpsId, spec.Name, args, spec.DefaultTimeout)
+	if err != nil {
+		return Reply{}, fmt.Errorf("dispatch/%s failed: %w", spec.Name, err)
+	}
+	if res.ExitCode != 0 {
+		return Reply{TaskID: res.TaskID, Text: defaultTaskReply(res)}, nil
+	}
+	database, user := args[1], args[2]
+	if res.Secrets["password"] == "" {
+		return Reply{TaskID: res.TaskID, Text: fmt.Sprintf("Created database %s and user %s, but the server did not return the generated password. Reset it before use.", database, user)}, nil
+	}
+
+	values := map[string]string{"engine": args[0], "database": database, "user": user}
+	for k, v := range res.Secrets {
+		values[k] = v
+	}
+	c := storeCredential(vpsId, spec.Name, values)
+	return Reply{
+		TaskID: res.TaskID,
+		Text: fmt.Sprintf("Created database %s owned by user %s. Collect the generated password from GET /vps/%s/credentials/%s within %d minutes; it can be retrieved once.",
+			database, user, vpsId, c.ID, int(credentialTTL.Minutes())),
+		Data: map[string]string{"database": database, "user": user, "credential_id": c.ID},
+	}, nil
+}
+
+var dbGrantDescriptions = map[string]string{
+	"readonly":  "SELECT",
+	"readwrite": "SELECT, INSERT, UPDATE, DELETE",
+	"all":       "ALL PRIVILEGES, including schema changes",
+}
+
+func previewDBGrant(_ string, args []string) string {
+	return fmt.Sprintf("%s will get %s on %s.", args[2], dbGrantDescriptions[args[3]], args[1])
+}
+
+func formatDatabaseList(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("db_list", nil, res.Output())
+	if err != nil || res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	list := v.(tasks.DatabaseList)
+	if len(list.Databases) == 0 {
+		return "There are no user databases on this server."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d databases:\n", len(list.Databases))
+	for _, d := range list.Databases {
+		fmt.Fprintf(&b, "  %-30s %10s\n", d.Name, humanBytes(d.SizeBytes))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatDBGrant(_ string, res websocket.TaskResult) string {
+	if res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	return "Privileges granted."
+}
+
+func formatDBDump(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("db_dump", nil, res.Output())
+	if err != nil || res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	d := v.(tasks.DBDump)
+	return fmt.Sprintf("Dumped %s to %s (%s, sha256 %s).", d.Database, d.Path, humanBytes(d.SizeBytes), d.SHA256[:12])
+}
+
+func formatSlowQueries(_ string, res websocket.TaskResult) string {
+	if res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	v, err := tasks.ParseOutput("db_slow_queries", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	s := v.(tasks.SlowQueries)
+	if len(s.Queries) == 0 {
+		return "No slow queries recorded. The slow query log or pg_stat_statements may be disabled."
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Slowest %d queries by total time:\n", len(s.Queries))
+	for i, q := range s.Queries {
+		fmt.Fprintf(&b, "%d. %d calls, %.0f ms total, %.1f ms avg\n   %s\n", i+1, q.Calls, q.TotalTimeMS, q.AvgTimeMS, truncate(q.Query, 200))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
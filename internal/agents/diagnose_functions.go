@@ -0,0 +1,456 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// services whose failure takes a site down
+var siteCriticalUnits = map[string]bool{
+	"nginx": true, "apache2": true, "httpd": true, "mysql": true, "mariadb": true,
+	"postgresql": true, "php-fpm": true, "php8.1-fpm": true, "php8.2-fpm": true, "php8.3-fpm": true,
+}
+
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+var severityRank = map[string]int{SeverityCritical: 0, SeverityWarning: 1, SeverityInfo: 2}
+
+// Finding is a probable cause derived from the diagnostic checks
+type Finding struct {
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Evidence string `json:"evidence"`
+	Fix      string `json:"fix"`
+}
+
+// DiagnosisReport is returned as Reply.Data by the diagnose_site workflow
+type DiagnosisReport struct {
+	VPSID    string        `json:"vps_id"`
+	Domain   string        `json:"domain,omitempty"`
+	Duration string        `json:"duration"`
+	Checks   []CheckResult `json:"checks"`
+	Findings []Finding     `json:"findings"` // most likely cause first
+}
+
+// diagnosis collects parsed check output for the rules; each check writes
+// only its own field so checks can run concurrently.
+type diagnosis struct {
+	vpsId    string
+	domain   string
+	uptime   *tasks.Uptime
+	disk     *tasks.DiskUsage
+	memory   *tasks.Memory
+	units    []tasks.ServiceUnit
+	ports    *tasks.ListeningPorts
+	oom      *tasks.OOMKills
+	errorLog *tasks.LogDigest
+	dns      *tasks.DNSResolution
+	vpsIP    string
+}
+
+type diagnosticCheck struct {
+	name string
+	run  func(d *diagnosis) CheckResult
+}
+
+func init() {
+	registerWorkflow("diagnose_site", workflow{
+		Description: "troubleshoot why a website or server is down or slow: checks uptime, disk, memory, services, ports, error logs and DNS and reports the likely cause",
+		Params: []tasks.Param{
+			{Name: "domain", Description: "domain of the affected website, if mentioned"},
+		},
+		Run: func(req *models.ChatRequest, vpsId string, args []string) (Reply, error) {
+			domain := ""
+			if len(args) > 0 {
+				domain = strings.ToLower(strings.TrimSpace(args[0]))
+			}
+			report := RunDiagnostics(req.UserToken, vpsId, domain, true)
+			return Reply{Text: formatDiagnosis(report), Data: report}, nil
+		},
+	})
+}
+
+// RunDiagnostics runs the site checks against vpsId, concurrently when
+// parallel is set, and ranks the findings. A check that fails to run is
+// reported as such and doesn't stop the others.
+func RunDiagnostics(userToken, vpsId, domain string, parallel bool) DiagnosisReport {
+	start := time.Now()
+	d := &diagnosis{vpsId: vpsId, domain: domain}
+
+	checks := []diagnosticCheck{
+		{"uptime", checkUptimeDiag},
+		{"disk space", checkDiskDiag},
+		{"memory", checkMemoryDiag},
+		{"services", checkServicesDiag},
+		{"listening ports", checkPortsDiag},
+		{"out-of-memory kills", checkOOMDiag},
+	}
+	if domain != "" {
+		if tasks.ValidateArgs("check_dns_resolution", []string{domain}) == nil {
+			checks = append(checks, diagnosticCheck{"DNS resolution", func(d *diagnosis) CheckResult {
+				return checkDNSDiag(d, userToken)
+			}})
+		}
+	}
+
+	results := make([]CheckResult, len(checks))
+	if parallel {
+		var wg sync.WaitGroup
+		for i, c := range checks {
+			wg.Add(1)
+			go func(i int, c diagnosticCheck) {
+				defer wg.Done()
+				results[i] = c.run(d)
+				results[i].Name = c.name
+			}(i, c)
+		}
+		wg.Wait()
+	} else {
+		for i, c := range checks {
+			results[i] = c.run(d)
+			results[i].Name = c.name
+		}
+	}
+
+	// the error log to read depends on which web server is listening
+	logCheck := checkErrorLogDiag(d)
+	logCheck.Name = "recent errors"
+	results = append(results, logCheck)
+
+	return DiagnosisReport{
+		VPSID:    vpsId,
+		Domain:   domain,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+		Checks:   results,
+		Findings: rankFindings(diagnose(d)),
+	}
+}
+
+// runParsed runs a read-only task and returns its parsed output.
+func runParsed(vpsId, task string, args []string) (interface{}, error) {
+	res, err := websocket.SendSignedTaskAndWait(vpsId, task, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("%s failed (exit=%d): %s", task, res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+	return tasks.ParseOutput(task, args, res.Output())
+}
+
+// runParsedOrEmpty is runParsed for tasks that print nothing when there is
+// nothing to report; empty output yields the empty value.
+func runParsedOrEmpty(vpsId, task string, args []string, empty interface{}) (interface{}, error) {
+	res, err := websocket.SendSignedTaskAndWait(vpsId, task, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("%s failed (exit=%d): %s", task, res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+	if strings.TrimSpace(res.Output()) == "" {
+		return empty, nil
+	}
+	return tasks.ParseOutput(task, args, res.Output())
+}
+
+func failedCheck(err error) CheckResult {
+	return CheckResult{Detail: "could not run: " + err.Error()}
+}
+
+func checkUptimeDiag(d *diagnosis) CheckResult {
+	v, err := runParsed(d.vpsId, "check_uptime", nil)
+	if err != nil {
+		return failedCheck(err)
+	}
+	u := v.(tasks.Uptime)
+	d.uptime = &u
+	return CheckResult{Passed: true, Detail: fmt.Sprintf("up %s, load %.2f %.2f %.2f", u.Up, u.Load1, u.Load5, u.Load15)}
+}
+
+func checkDiskDiag(d *diagnosis) CheckResult {
+	v, err := runParsed(d.vpsId, "check_diskspace", nil)
+	if err != nil {
+		return failedCheck(err)
+	}
+	du := v.(tasks.DiskUsage)
+	d.disk = &du
+	fs, _ := du.Fullest()
+	return CheckResult{Passed: fs.UsePercent < 90, Detail: fmt.Sprintf("fullest: %s at %d%%", fs.MountedOn, fs.UsePercent)}
+}
+
+func checkMemoryDiag(d *diagnosis) CheckResult {
+	v, err := runParsed(d.vpsId, "check_memory", nil)
+	if err != nil {
+		return failedCheck(err)
+	}
+	m := v.(tasks.Memory)
+	d.memory = &m
+	return CheckResult{Passed: m.UsedPercent() < 90, Detail: fmt.Sprintf("%d%% of %d MB in use", m.UsedPercent(), m.TotalMB)}
+}
+
+func checkServicesDiag(d *diagnosis) CheckResult {
+	v, err := runParsed(d.vpsId, "list_services", nil)
+	if err != nil {
+		return failedCheck(err)
+	}
+	d.units = v.([]tasks.ServiceUnit)
+	rememberUnits(d.vpsId, d.units)
+	var failed []string
+	for _, u := range d.units {
+		if u.Active == "failed" {
+			failed = append(failed, u.Unit)
+		}
+	}
+	if len(failed) > 0 {
+		return CheckResult{Detail: "failed: " + strings.Join(failed, ", ")}
+	}
+	return CheckResult{Passed: true, Detail: fmt.Sprintf("%d units, none failed", len(d.units))}
+}
+
+func checkPortsDiag(d *diagnosis) CheckResult {
+	p, err := listeningPorts(d.vpsId)
+	if err != nil {
+		return failedCheck(err)
+	}
+	d.ports = &p
+	web := p.Has(80) || p.Has(443)
+	detail := "nothing listening on 80/443"
+	if web {
+		detail = "web ports open"
+	}
+	return CheckResult{Passed: web, Detail: fmt.Sprintf("%s (%d listening sockets)", detail, len(p.Ports))}
+}
+
+func checkOOMDiag(d *diagnosis) CheckResult {
+	v, err := runParsedOrEmpty(d.vpsId, "check_oom_kills", []string{"1d"}, tasks.OOMKills{})
+	if err != nil {
+		return failedCheck(err)
+	}
+	kills := v.(tasks.OOMKills)
+	d.oom = &kills
+	if len(kills.Kills) > 0 {
+		return CheckResult{Detail: fmt.Sprintf("%d processes killed in the last day", len(kills.Kills))}
+	}
+	return CheckResult{Passed: true, Detail: "none in the last day"}
+}
+
+func checkDNSDiag(d *diagnosis, userToken string) CheckResult {
+	if vps, err := getVPS(userToken, d.vpsId); err == nil {
+		d.vpsIP = vps.IPAddress
+	}
+	// no output, or no addresses in it, means the domain has no records; any
+	// other error means the check never ran, and d.dns stays nil so the rules
+	// don't blame DNS
+	none := tasks.DNSResolution{Domain: d.domain}
+	v, err := runParsedOrEmpty(d.vpsId, "check_dns_resolution", []string{d.domain}, none)
+	if errors.Is(err, tasks.ErrNoAddresses) {
+		v, err = none, nil
+	}
+	if err != nil {
+		return CheckResult{Detail: "inconclusive, could not run: " + err.Error()}
+	}
+	r := v.(tasks.DNSResolution)
+	d.dns = &r
+	if len(r.Addresses) == 0 {
+		return CheckResult{Detail: d.domain + " does not resolve"}
+	}
+	if d.vpsIP != "" && !r.Contains(d.vpsIP) {
+		return CheckResult{Detail: fmt.Sprintf("%s resolves to %s, not %s", d.domain, strings.Join(r.Addresses, ", "), d.vpsIP)}
+	}
+	return CheckResult{Passed: true, Detail: fmt.Sprintf("%s resolves to %s", d.domain, strings.Join(r.Addresses, ", "))}
+}
+
+func checkErrorLogDiag(d *diagnosis) CheckResult {
+	logName := "nginx_error"
+	if d.ports != nil {
+		for _, p := range d.ports.Ports {
+			if p.Process == "apache2" || p.Process == "httpd" {
+				logName = "apache_error"
+			}
+		}
+	}
+	v, err := runParsed(d.vpsId, "read_log_since", []string{logName, "1h"})
+	if err != nil {
+		return failedCheck(err)
+	}
+	digest := v.(tasks.LogDigest)
+	d.errorLog = &digest
+	return CheckResult{Passed: digest.ErrorLines == 0, Detail: fmt.Sprintf("%s: %d errors in the last hour", logName, digest.ErrorLines)}
+}
+
+// diagnose applies the rules; findings are appended in rough order of
+// likelihood so that ties in severity keep that order.
+func diagnose(d *diagnosis) []Finding {
+	var out []Finding
+
+	if d.disk != nil {
+		for _, fs := range d.disk.Filesystems {
+			switch {
+			case fs.UsePercent >= 98:
+				out = append(out, Finding{
+					Severity: SeverityCritical,
+					Title:    "Disk full on " + fs.MountedOn,
+					Evidence: fmt.Sprintf("%s is %d%% full (%s available)", fs.MountedOn, fs.UsePercent, fs.Available),
+					Fix:      "Free space: clear old logs (journalctl --vacuum-size=200M), remove old backups and package caches (apt-get clean), or upgrade the disk.",
+				})
+			case fs.UsePercent >= 90:
+				out = append(out, Finding{
+					Severity: SeverityWarning,
+					Title:    "Disk almost full on " + fs.MountedOn,
+					Evidence: fmt.Sprintf("%s is %d%% full", fs.MountedOn, fs.UsePercent),
+					Fix:      "Clean up logs, caches and old backups before the disk fills up.",
+				})
+			}
+		}
+	}
+
+	for _, u := range d.units {
+		if u.Active != "failed" {
+			continue
+		}
+		f := Finding{
+			Severity: SeverityWarning,
+			Title:    "Service " + u.Unit + " has failed",
+			Evidence: fmt.Sprintf("%s is %s (%s)", u.Unit, u.Active, u.Sub),
+			Fix:      fmt.Sprintf("Check its journal for the cause, then restart it (ask me to \"restart %s\").", u.Unit),
+		}
+		if siteCriticalUnits[u.Unit] {
+			f.Severity = SeverityCritical
+		}
+		out = append(out, f)
+	}
+
+	if d.oom != nil && len(d.oom.Kills) > 0 {
+		var procs []string
+		seen := map[string]bool{}
+		for _, k := range d.oom.Kills {
+			if !seen[k.Process] {
+				seen[k.Process] = true
+				procs = append(procs, k.Process)
+			}
+		}
+		out = append(out, Finding{
+			Severity: SeverityCritical,
+			Title:    "Processes killed for lack of memory",
+			Evidence: fmt.Sprintf("%d OOM kills in the last day: %s", len(d.oom.Kills), strings.Join(procs, ", ")),
+			Fix:      "Reduce memory use (fewer PHP-FPM/Apache workers, smaller MySQL buffers), add swap, or upgrade to a plan with more RAM.",
+		})
+	}
+
+	if d.memory != nil {
+		if pct := d.memory.UsedPercent(); pct >= 95 {
+			out = append(out, Finding{
+				Severity: SeverityWarning,
+				Title:    "Memory exhausted",
+				Evidence: fmt.Sprintf("%d%% of %d MB in use, %d MB swap used", pct, d.memory.TotalMB, d.memory.SwapUsedMB),
+				Fix:      "Find the largest processes and restart or tune them; consider adding swap or RAM.",
+			})
+		}
+	}
+
+	if d.ports != nil && !d.ports.Has(80) && !d.ports.Has(443) {
+		out = append(out, Finding{
+			Severity: SeverityCritical,
+			Title:    "No web server is listening",
+			Evidence: "nothing is listening on port 80 or 443",
+			Fix:      "Start the web server (nginx or apache) and check its configuration if it fails to start.",
+		})
+	}
+
+	if d.dns != nil {
+		switch {
+		case len(d.dns.Addresses) == 0:
+			out = append(out, Finding{
+				Severity: SeverityCritical,
+				Title:    "Domain does not resolve",
+				Evidence: d.domain + " has no A/AAAA records",
+				Fix:      "Add an A record pointing " + d.domain + " at the server's IP, or check the domain hasn't expired.",
+			})
+		case d.vpsIP != "" && !d.dns.Contains(d.vpsIP):
+			out = append(out, Finding{
+				Severity: SeverityCritical,
+				Title:    "Domain points to a different server",
+				Evidence: fmt.Sprintf("%s resolves to %s, this VPS is %s", d.domain, strings.Join(d.dns.Addresses, ", "), d.vpsIP),
+				Fix:      "Update the domain's A record to " + d.vpsIP + " (DNS changes can take up to the record's TTL to apply).",
+			})
+		}
+	}
+
+	if d.errorLog != nil && len(d.errorLog.Groups) > 0 {
+		top := d.errorLog.Groups[0]
+		out = append(out, Finding{
+			Severity: SeverityWarning,
+			Title:    "Errors in the web server log",
+			Evidence: fmt.Sprintf("%d× %s", top.Count, truncate(top.Sample, 200)),
+			Fix:      "Look at the most frequent error above; upstream/connection errors usually point at PHP-FPM or the database.",
+		})
+	}
+
+	if d.uptime != nil {
+		if d.uptime.UpSeconds > 0 && d.uptime.UpSeconds < 15*60 {
+			out = append(out, Finding{
+				Severity: SeverityInfo,
+				Title:    "Server rebooted recently",
+				Evidence: "up " + d.uptime.Up,
+				Fix:      "If the reboot was unexpected, check that all services came back and are enabled at boot.",
+			})
+		}
+		if d.uptime.Load5 >= 8 {
+			out = append(out, Finding{
+				Severity: SeverityWarning,
+				Title:    "Very high load",
+				Evidence: fmt.Sprintf("5-minute load average %.2f", d.uptime.Load5),
+				Fix:      "Find the busiest processes; heavy traffic or runaway cron jobs are common causes.",
+			})
+		}
+	}
+	return out
+}
+
+func rankFindings(findings []Finding) []Finding {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank[findings[i].Severity] < severityRank[findings[j].Severity]
+	})
+	if findings == nil {
+		findings = []Finding{}
+	}
+	return findings
+}
+
+func formatDiagnosis(r DiagnosisReport) string {
+	var b strings.Builder
+	target := "VPS " + r.VPSID
+	if r.Domain != "" {
+		target = r.Domain + " on " + target
+	}
+	if len(r.Findings) == 0 {
+		fmt.Fprintf(&b, "I ran %d checks on %s and found no obvious problem.\n", len(r.Checks), target)
+	} else {
+		fmt.Fprintf(&b, "Diagnosis for %s, most likely cause first:\n", target)
+		for i, f := range r.Findings {
+			fmt.Fprintf(&b, "%d. [%s] %s\n   Evidence: %s\n   Fix: %s\n", i+1, f.Severity, f.Title, f.Evidence, f.Fix)
+		}
+	}
+	b.WriteString("Checks:\n")
+	for _, c := range r.Checks {
+		mark := "ok"
+		if !c.Passed {
+			mark = "!!"
+		}
+		fmt.Fprintf(&b, "  [%s] %s - %s\n", mark, c.Name, c.Detail)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -1,29 +1,29 @@
 package agents
 
 import (
+	"strings"
+
+	"ultahost-ai-gateway/internal/ai"
 	"ultahost-ai-gateway/internal/pkg/models"
 )
 
-func HandleDomain(req *models.ChatRequest) (string, error) {
-	// url := "https://api.ultahost.dev/domains"
-
-	// payload := []byte(`{"query":"` + req.Message + `"}`)
-	// httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	// if err != nil {
-	// 	return "", err
-	// }
-
-	// httpReq.Header.Set("Authorization", "Bearer "+req.UserToken)
-	// httpReq.Header.Set("Content-Type", "application/json")
-
-	// client := &http.Client{Timeout: 10 * time.Second}
-	// resp, err := client.Do(httpReq)
-	// if err != nil {
-	// 	return "", err
-	// }
-	// defer resp.Body.Close()
+func HandleDomain(req *models.ChatRequest, functionList []string) (string, error) {
+	functionName, err := ai.ClassifyFunctionWithinAgent(req.Message, functionList)
+	if err != nil {
+		return "", err
+	}
+	functionName = strings.ToLower(functionName)
 
-	// body, _ := io.ReadAll(resp.Body)
-	// return string(body), nil
-	return "✅ VPS agent called successfully with message: " + req.Message, nil
+	switch functionName {
+	case "getdnsrecords":
+		return getDNSRecords(req)
+	case "creatednsrecord":
+		return changeDNSRecord(req, dnsCreate)
+	case "updatednsrecord":
+		return changeDNSRecord(req, dnsUpdate)
+	case "deletednsrecord":
+		return changeDNSRecord(req, dnsDelete)
+	default:
+		return noMatchPrefix + " to a known domain function.", nil
+	}
 }
@@ -0,0 +1,452 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ultahost-ai-gateway/internal/ai"
+	"ultahost-ai-gateway/internal/client"
+	"ultahost-ai-gateway/internal/pkg/models"
+)
+
+var DomainFunctionList = []string{
+	"getDNSRecords",
+	"createDNSRecord",
+	"updateDNSRecord",
+	"deleteDNSRecord",
+}
+
+const (
+	dnsCreate = "create"
+	dnsUpdate = "update"
+	dnsDelete = "delete"
+)
+
+const defaultDNSTTL = 3600
+
+// fields the model extracts for DNS changes
+var dnsFields = map[string]string{
+	"domain":      "the domain name whose zone is changed, e.g. example.com",
+	"type":        "DNS record type: A, AAAA, CNAME, MX or TXT",
+	"name":        "record host relative to the domain, e.g. www, mail, or @ for the root",
+	"content":     "new record value (IP address, target host, mail server or text). Use my_vps when the user refers to their VPS or server IP",
+	"ttl":         "TTL in seconds, if given",
+	"priority":    "MX priority, if given",
+	"old_content": "current value of the record being changed or removed, if mentioned",
+}
+
+var (
+	domainPattern   = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	hostnamePattern = regexp.MustCompile(`^([a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.?$`)
+	recordName      = regexp.MustCompile(`^(@|(\*\.)?([a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9])?)(\.[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9])?)*|\*)$`)
+	myVPSPattern    = regexp.MustCompile(`(?i)^(my[_ ]?(vps|server)([_ ]ip)?|vps|server)$`)
+)
+
+var allowedDNSTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true, "MX": true, "TXT": true}
+
+func getDNSRecords(req *models.ChatRequest) (string, error) {
+	params, err := dnsParams(req)
+	if err != nil {
+		return "", err
+	}
+	domain := normalizeDomain(params["domain"])
+	if !domainPattern.MatchString(domain) {
+		return "Which domain should I look up? Please include it in your request.", nil
+	}
+
+	zone, err := fetchZone(req, domain)
+	if err != nil {
+		return "", err
+	}
+	if len(zone) == 0 {
+		return fmt.Sprintf("%s has no DNS records yet.", domain), nil
+	}
+
+	rawOutput := renderZone(domain, zone)
+	summary, err := ai.SummarizeResponse(rawOutput)
+	if err != nil {
+		return rawOutput, nil
+	}
+	return summary, nil
+}
+
+// changeDNSRecord validates the requested change, renders a before/after
+// preview of the zone and stages it until the user confirms.
+func changeDNSRecord(req *models.ChatRequest, action string) (string, error) {
+	params, err := dnsParams(req)
+	if err != nil {
+		return "", err
+	}
+
+	domain := normalizeDomain(params["domain"])
+	if !domainPattern.MatchString(domain) {
+		return "Which domain should I change? Please include it in your request.", nil
+	}
+
+	rec := models.DNSRecord{
+		Type:    strings.ToUpper(params["type"]),
+		Name:    normalizeRecordName(params["name"], domain),
+		Content: params["content"],
+		TTL:     defaultDNSTTL,
+	}
+	if params["ttl"] != "" {
+		if rec.TTL, err = strconv.Atoi(params["ttl"]); err != nil {
+			return fmt.Sprintf("TTL %q is not a number of seconds.", params["ttl"]), nil
+		}
+	}
+	if params["priority"] != "" {
+		if rec.Priority, err = strconv.Atoi(params["priority"]); err != nil {
+			return fmt.Sprintf("Priority %q is not a number.", params["priority"]), nil
+		}
+	} else if rec.Type == "MX" {
+		rec.Priority = 10
+	}
+
+	zone, err := fetchZone(req, domain)
+	if err != nil {
+		return "", err
+	}
+
+	var target *models.DNSRecord
+	if action != dnsCreate {
+		t, msg := findDNSTarget(zone, rec.Type, rec.Name, params["old_content"])
+		if msg != "" {
+			return msg, nil
+		}
+		target = &t
+		if rec.Type == "" {
+			rec.Type = t.Type
+		}
+	}
+
+	if action != dnsDelete {
+		if rec.Content == "" && target == nil && (rec.Type == "A" || rec.Type == "AAAA") && req.VPSID != "" {
+			rec.Content = "my_vps"
+		}
+		if myVPSPattern.MatchString(rec.Content) {
+			ip, err := resolveVPSAddress(req, rec.Type)
+			if err != nil {
+				return "", err
+			}
+			if ip == "" {
+				return "I couldn't determine your VPS IP address. Please include vps_id in your request or give the IP directly.", nil
+			}
+			rec.Content = ip
+		}
+		if target != nil {
+			rec.ID = target.ID
+			if rec.Content == "" {
+				rec.Content = target.Content
+			}
+			if params["ttl"] == "" {
+				rec.TTL = target.TTL
+			}
+			if params["priority"] == "" && rec.Type == "MX" {
+				rec.Priority = target.Priority
+			}
+		}
+		if err := validateDNSRecord(rec, domain); err != nil {
+			return fmt.Sprintf("I can't make that change: %v.", err), nil
+		}
+	}
+
+	after, err := applyDNSChange(zone, action, rec, target)
+	if err != nil {
+		return fmt.Sprintf("I can't make that change: %v.", err), nil
+	}
+
+	summary := describeDNSChange(domain, action, rec, target)
+	preview := diffZone(domain, zone, after)
+	userToken := req.UserToken
+	return stageAction(req, summary, preview, func(context.Context) (Reply, error) {
+		text, err := applyDNSRecord(userToken, domain, action, rec, target)
+		return Reply{Text: text}, err
+	}), nil
+}
+
+// dnsParams extracts DNS fields from the message; key=value args take precedence
+func dnsParams(req *models.ChatRequest) (map[string]string, error) {
+	params, err := ai.ExtractArguments(req.Message, dnsFields)
+	if err != nil {
+		return nil, fmt.Errorf("could not understand DNS request: %w", err)
+	}
+	mergeArgs(params, req.Args)
+	return params, nil
+}
+
+// mergeArgs overrides params with key=value pairs passed explicitly in args
+func mergeArgs(params map[string]string, args []string) {
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		if _, known := params[k]; known {
+			params[k] = strings.TrimSpace(v)
+		}
+	}
+}
+
+func normalizeDomain(d string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+// normalizeRecordName makes names relative to the zone ("www.example.com." -> "www")
+func normalizeRecordName(name, domain string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	switch {
+	case name == "" || name == domain:
+		return "@"
+	case strings.HasSuffix(name, "."+domain):
+		return strings.TrimSuffix(name, "."+domain)
+	}
+	return name
+}
+
+func validateDNSRecord(rec models.DNSRecord, domain string) error {
+	if !allowedDNSTypes[rec.Type] {
+		return fmt.Errorf("record type %q is not supported (use A, AAAA, CNAME, MX or TXT)", rec.Type)
+	}
+	if !recordName.MatchString(rec.Name) {
+		return fmt.Errorf("%q is not a valid record name", rec.Name)
+	}
+	if rec.TTL < 60 || rec.TTL > 86400 {
+		return fmt.Errorf("TTL must be between 60 and 86400 seconds")
+	}
+
+	switch rec.Type {
+	case "A":
+		ip := net.ParseIP(rec.Content)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("%q is not a valid IPv4 address", rec.Content)
+		}
+	case "AAAA":
+		ip := net.ParseIP(rec.Content)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("%q is not a valid IPv6 address", rec.Content)
+		}
+	case "CNAME":
+		if rec.Name == "@" {
+			return fmt.Errorf("a CNAME can't be set on the root of %s", domain)
+		}
+		if !hostnamePattern.MatchString(strings.ToLower(rec.Content)) {
+			return fmt.Errorf("%q is not a valid target host name", rec.Content)
+		}
+	case "MX":
+		if !hostnamePattern.MatchString(strings.ToLower(rec.Content)) {
+			return fmt.Errorf("%q is not a valid mail server host name", rec.Content)
+		}
+		if rec.Priority < 0 || rec.Priority > 65535 {
+			return fmt.Errorf("MX priority must be between 0 and 65535")
+		}
+	case "TXT":
+		if rec.Content == "" || len(rec.Content) > 2048 {
+			return fmt.Errorf("TXT value must be between 1 and 2048 characters")
+		}
+	}
+	return nil
+}
+
+// findDNSTarget locates the single record an update/delete refers to
+func findDNSTarget(zone []models.DNSRecord, typ, name, oldContent string) (models.DNSRecord, string) {
+	var matches []models.DNSRecord
+	for _, r := range zone {
+		if typ != "" && !strings.EqualFold(r.Type, typ) {
+			continue
+		}
+		if r.Name != name {
+			continue
+		}
+		if oldContent != "" && !strings.EqualFold(r.Content, oldContent) {
+			continue
+		}
+		matches = append(matches, r)
+	}
+
+	switch len(matches) {
+	case 0:
+		return models.DNSRecord{}, fmt.Sprintf("I couldn't find a matching %s record for %q.", strings.TrimSpace(typ+" "), name)
+	case 1:
+		return matches[0], ""
+	}
+	var opts []string
+	for _, m := range matches {
+		opts = append(opts, fmt.Sprintf("%s %s", m.Type, m.Content))
+	}
+	return models.DNSRecord{}, fmt.Sprintf("There are several records for %q (%s). Which value do you mean?", name, strings.Join(opts, ", "))
+}
+
+// applyDNSChange returns the zone as it would look after the change
+func applyDNSChange(zone []models.DNSRecord, action string, rec models.DNSRecord, target *models.DNSRecord) ([]models.DNSRecord, error) {
+	after := make([]models.DNSRecord, 0, len(zone)+1)
+	for _, r := range zone {
+		if target != nil && sameRecord(r, *target) {
+			continue
+		}
+		if action != dnsDelete {
+			if sameRecord(r, rec) {
+				return nil, fmt.Errorf("an identical %s record for %q already exists", rec.Type, rec.Name)
+			}
+			if r.Name == rec.Name && (rec.Type == "CNAME" || strings.EqualFold(r.Type, "CNAME")) {
+				return nil, fmt.Errorf("%q already has a %s record, which can't coexist with a CNAME", rec.Name, r.Type)
+			}
+		}
+		after = append(after, r)
+	}
+	if action != dnsDelete {
+		after = append(after, rec)
+	}
+	return after, nil
+}
+
+func sameRecord(a, b models.DNSRecord) bool {
+	if a.ID != "" && b.ID != "" {
+		return a.ID == b.ID
+	}
+	return strings.EqualFold(a.Type, b.Type) && a.Name == b.Name && strings.EqualFold(a.Content, b.Content)
+}
+
+func describeDNSChange(domain, action string, rec models.DNSRecord, target *models.DNSRecord) string {
+	host := rec.Name + "." + domain
+	if rec.Name == "@" {
+		host = domain
+	}
+	switch action {
+	case dnsCreate:
+		return fmt.Sprintf("I'll add a %s record so %s points to %s.", rec.Type, host, rec.Content)
+	case dnsUpdate:
+		return fmt.Sprintf("I'll change the %s record for %s from %s to %s.", rec.Type, host, target.Content, rec.Content)
+	default:
+		return fmt.Sprintf("I'll delete the %s record %s -> %s.", target.Type, host, target.Content)
+	}
+}
+
+func formatDNSRecord(r models.DNSRecord) string {
+	content := r.Content
+	if strings.EqualFold(r.Type, "MX") {
+		content = fmt.Sprintf("%d %s", r.Priority, r.Content)
+	}
+	return fmt.Sprintf("%-6s %-24s %-6d %s", strings.ToUpper(r.Type), r.Name, r.TTL, content)
+}
+
+func sortZone(zone []models.DNSRecord) []models.DNSRecord {
+	out := append([]models.DNSRecord(nil), zone...)
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		return out[i].Content < out[j].Content
+	})
+	return out
+}
+
+func renderZone(domain string, zone []models.DNSRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "DNS zone for %s:\n", domain)
+	for _, r := range sortZone(zone) {
+		b.WriteString("  " + formatDNSRecord(r) + "\n")
+	}
+	return b.String()
+}
+
+// diffZone renders the zone with removed lines prefixed "-" and added lines "+"
+func diffZone(domain string, before, after []models.DNSRecord) string {
+	beforeSet := make(map[string]bool, len(before))
+	for _, r := range before {
+		beforeSet[formatDNSRecord(r)] = true
+	}
+	afterSet := make(map[string]bool, len(after))
+	for _, r := range after {
+		afterSet[formatDNSRecord(r)] = true
+	}
+
+	all := sortZone(append(append([]models.DNSRecord(nil), before...), after...))
+	seen := make(map[string]bool, len(all))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "DNS zone for %s (before -> after):\n", domain)
+	for _, r := range all {
+		line := formatDNSRecord(r)
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		switch {
+		case beforeSet[line] && afterSet[line]:
+			b.WriteString("  " + line + "\n")
+		case beforeSet[line]:
+			b.WriteString("- " + line + "\n")
+		default:
+			b.WriteString("+ " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+func fetchZone(req *models.ChatRequest, domain string) ([]models.DNSRecord, error) {
+	var zone []models.DNSRecord
+	if err := client.NewNestClient(req.UserToken).Get("/domains/"+url.PathEscape(domain)+"/dns", &zone); err != nil {
+		return nil, fmt.Errorf("fetch DNS zone for %s failed: %w", domain, err)
+	}
+	for i := range zone {
+		zone[i].Type = strings.ToUpper(zone[i].Type)
+		zone[i].Name = normalizeRecordName(zone[i].Name, domain)
+	}
+	return zone, nil
+}
+
+// resolveVPSAddress returns the IPv4 (or IPv6 for AAAA) address of the request's VPS
+func resolveVPSAddress(req *models.ChatRequest, recordType string) (string, error) {
+	if req.VPSID == "" {
+		return "", nil
+	}
+	vps, err := getVPS(req.UserToken, req.VPSID)
+	if err != nil {
+		return "", err
+	}
+	if recordType == "AAAA" {
+		return vps.IPv6Address, nil
+	}
+	return vps.IPAddress, nil
+}
+
+func getVPS(userToken, vpsId string) (models.VPS, error) {
+	var vps models.VPS
+	if err := client.NewNestClient(userToken).Get("/vps/"+url.PathEscape(vpsId), &vps); err != nil {
+		return models.VPS{}, fmt.Errorf("lookup VPS %s failed: %w", vpsId, err)
+	}
+	return vps, nil
+}
+
+func applyDNSRecord(userToken, domain, action string, rec models.DNSRecord, target *models.DNSRecord) (string, error) {
+	nest := client.NewNestClient(userToken)
+	base := "/domains/" + url.PathEscape(domain) + "/dns"
+
+	var err error
+	switch action {
+	case dnsCreate:
+		err = nest.Post(base, rec, nil)
+	case dnsUpdate:
+		err = nest.Put(base+"/"+url.PathEscape(string(target.ID)), rec, nil)
+	case dnsDelete:
+		err = nest.Delete(base+"/"+url.PathEscape(string(target.ID)), nil)
+	}
+	if err != nil {
+		return "", fmt.Errorf("DNS %s failed: %w", action, err)
+	}
+
+	if action == dnsDelete {
+		return fmt.Sprintf("Done. The record \"%s\" was removed from %s.", strings.TrimSpace(formatDNSRecord(*target)), domain), nil
+	}
+	return fmt.Sprintf("Done. %s now has \"%s\"; it can take up to %d seconds to propagate.", domain, strings.TrimSpace(formatDNSRecord(rec)), rec.TTL), nil
+}
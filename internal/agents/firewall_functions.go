@@ -0,0 +1,234 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// rulesets are reused between a change's guard and its preview
+const firewallRulesTTL = time.Minute
+
+type cachedRuleset struct {
+	rules   tasks.FirewallRuleset
+	fetched time.Time
+}
+
+var (
+	firewallMu    sync.Mutex
+	firewallCache = make(map[string]cachedRuleset) // vpsId -> ruleset
+)
+
+func init() {
+	taskFormatters["firewall_rules"] = formatFirewallRules
+	for name := range tasks.FirewallTasks {
+		name := name
+		taskPreflight[name] = checkFirewallBackend
+		taskGuards[name] = func(req *models.ChatRequest, vpsId string, args []string) error {
+			return guardFirewallChange(req, vpsId, name, args)
+		}
+		taskPreviews[name] = func(vpsId string, args []string) string {
+			return previewFirewallChange(vpsId, name, args)
+		}
+		taskRunners[name] = runFirewallChange
+	}
+
+	// firewall_commit is only sent by runFirewallChange
+	for i, name := range VPSFunctionList {
+		if name == "firewall_commit" {
+			VPSFunctionList = append(VPSFunctionList[:i], VPSFunctionList[i+1:]...)
+			break
+		}
+	}
+
+	websocket.OnTaskFinished(func(rec websocket.TaskRecord) {
+		if !tasks.FirewallTasks[rec.Task] {
+			return
+		}
+		firewallMu.Lock()
+		delete(firewallCache, rec.VPSID)
+		firewallMu.Unlock()
+	})
+}
+
+func firewallRuleset(vpsId string) (tasks.FirewallRuleset, error) {
+	firewallMu.Lock()
+	cached, ok := firewallCache[vpsId]
+	firewallMu.Unlock()
+	if ok && time.Since(cached.fetched) < firewallRulesTTL {
+		return cached.rules, nil
+	}
+
+	v, err := runParsed(vpsId, "firewall_rules", nil)
+	if err != nil {
+		return tasks.FirewallRuleset{}, err
+	}
+	rules := v.(tasks.FirewallRuleset)
+
+	firewallMu.Lock()
+	firewallCache[vpsId] = cachedRuleset{rules: rules, fetched: time.Now()}
+	firewallMu.Unlock()
+	return rules, nil
+}
+
+// checkFirewallBackend requires a firewall the agent knows how to manage.
+func checkFirewallBackend(vpsId string, _ []string) error {
+	facts, err := agentFacts(vpsId)
+	if err != nil {
+		return fmt.Errorf("could not detect the firewall: %v", err)
+	}
+	switch facts.Firewall {
+	case "ufw", "firewalld", "nftables":
+		return nil
+	case "", "none":
+		return fmt.Errorf("no supported firewall (ufw, firewalld or nftables) is installed")
+	}
+	return fmt.Errorf("the %s firewall is not supported", facts.Firewall)
+}
+
+// guardFirewallChange simulates the change and refuses it if it would lock
+// out the requester's SSH session or cut the agent off from the gateway.
+func guardFirewallChange(req *models.ChatRequest, vpsId, task string, args []string) error {
+	before, err := firewallRuleset(vpsId)
+	if err != nil {
+		return fmt.Errorf("could not read the current firewall rules: %v", err)
+	}
+	after, err := before.Apply(task, args)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(req.ClientIP); ip != nil && !ip.IsLoopback() {
+		if before.Allows(req.ClientIP, before.SSHPort, "tcp") && !after.Allows(req.ClientIP, after.SSHPort, "tcp") {
+			return fmt.Errorf("it would block SSH (port %d) from your address %s", before.SSHPort, req.ClientIP)
+		}
+	}
+
+	var peers []string
+	if config.AppConfig != nil {
+		peers = append(peers, config.AppConfig.GatewayIPs...)
+	}
+	if before.AgentPeer != "" {
+		peers = append(peers, before.AgentPeer)
+	}
+	for _, p := range peers {
+		if !before.Denies(p) && after.Denies(p) {
+			return fmt.Errorf("it would block %s, which the server agent connects to", p)
+		}
+	}
+	return nil
+}
+
+// previewFirewallChange shows the rules the change adds and removes and the
+// resulting ruleset.
+func previewFirewallChange(vpsId, task string, args []string) string {
+	before, err := firewallRuleset(vpsId)
+	if err != nil {
+		return ""
+	}
+	after, err := before.Apply(task, args)
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, r := range before.Rules {
+		if !hasRule(after.Rules, r) {
+			fmt.Fprintf(&b, "- %s\n", r)
+		}
+	}
+	for _, r := range after.Rules {
+		if !hasRule(before.Rules, r) {
+			fmt.Fprintf(&b, "+ %s\n", r)
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("The rules already match; nothing will change.\n")
+	}
+	fmt.Fprintf(&b, "Resulting %s rules (first match wins, default %s):\n%s\n", after.Backend, after.DefaultIncoming, listRules(after.Rules))
+	if !after.Active {
+		b.WriteString("The firewall is inactive, so these rules will not be enforced until it is enabled.\n")
+	}
+	b.WriteString("The change is rolled back automatically if the agent loses its connection after applying it.")
+	return b.String()
+}
+
+func hasRule(rules []tasks.FirewallRule, r tasks.FirewallRule) bool {
+	for _, x := range rules {
+		if x == r {
+			return true
+		}
+	}
+	return false
+}
+
+// runFirewallChange applies the change and then commits it. Getting the
+// commit through proves the agent is still reachable; if it isn't, the agent
+// rolls the change back on its own.
+func runFirewallChange(ctx context.Context, vpsId string, spec tasks.Spec, args []string) (Reply, error) {
+	res, err := websocket.SendSignedTaskAndWaitContext(ctx, vpsId, spec.Name, args, spec.DefaultTimeout)
+	if err != nil {
+		return Reply{}, fmt.Errorf("dispatch/%s failed: %w", spec.Name, err)
+	}
+	if res.ExitCode != 0 {
+		return Reply{TaskID: res.TaskID, Text: defaultTaskReply(res)}, nil
+	}
+	v, err := tasks.ParseOutput(spec.Name, args, res.Output())
+	if err != nil {
+		return Reply{TaskID: res.TaskID, Text: defaultTaskReply(res)}, nil
+	}
+	change := v.(tasks.FirewallChange)
+	reply := Reply{TaskID: res.TaskID, Data: change}
+	if change.AlreadyApplied {
+		reply.Text = "The firewall already had that rule; nothing changed."
+		return reply, nil
+	}
+
+	commit, err := websocket.SendSignedTaskAndWait(vpsId, "firewall_commit", []string{change.ChangeID}, 0)
+	if err != nil || commit.ExitCode != 0 {
+		reply.Text = fmt.Sprintf("The change was applied but the agent could not confirm it was still reachable, so it will be rolled back automatically within %d seconds.", change.RollbackAfter)
+		return reply, nil
+	}
+	reply.Text = fmt.Sprintf("Done. The firewall now has %d rules:\n%s", len(change.Ruleset.Rules), listRules(change.Ruleset.Rules))
+	return reply, nil
+}
+
+func listRules(rules []tasks.FirewallRule) string {
+	var b strings.Builder
+	for i, r := range rules {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, r)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatFirewallRules(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("firewall_rules", nil, res.Output())
+	if err != nil || res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	fw := v.(tasks.FirewallRuleset)
+	state := "active"
+	if !fw.Active {
+		state = "inactive"
+	}
+	if len(fw.Rules) == 0 {
+		return fmt.Sprintf("The %s firewall is %s with no rules; incoming traffic is %s by default.", fw.Backend, state, defaultVerb(fw.DefaultIncoming))
+	}
+	return fmt.Sprintf("The %s firewall is %s; incoming traffic is %s by default. Rules, first match wins:\n%s",
+		fw.Backend, state, defaultVerb(fw.DefaultIncoming), listRules(fw.Rules))
+}
+
+func defaultVerb(policy string) string {
+	if policy == "deny" {
+		return "denied"
+	}
+	return "allowed"
+}
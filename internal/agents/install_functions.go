@@ -0,0 +1,281 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// how long system facts reported by an agent are trusted
+const factsTTL = 10 * time.Minute
+
+// keep this much of the installer output in the report
+const installOutputTail = 20
+
+type cachedFacts struct {
+	facts   tasks.Facts
+	fetched time.Time
+}
+
+var (
+	factsCacheMu sync.Mutex
+	factsCache   = make(map[string]cachedFacts) // vpsId -> facts reported by the agent
+)
+
+// CheckResult is one prerequisite or post-install check
+type CheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// InstallReport is returned as Reply.Data for app installs
+type InstallReport struct {
+	App        string        `json:"app"`
+	VPSID      string        `json:"vps_id"`
+	TaskID     string        `json:"task_id,omitempty"`
+	Args       []string      `json:"args,omitempty"`
+	Status     string        `json:"status"` // installed, installed_with_warnings, failed
+	Duration   string        `json:"duration"`
+	Estimated  string        `json:"estimated"`
+	ExitCode   int           `json:"exit_code"`
+	Prereqs    []CheckResult `json:"prerequisites"`
+	PostChecks []CheckResult `json:"post_checks,omitempty"`
+	OutputTail string        `json:"output_tail,omitempty"`
+}
+
+func init() {
+	for _, app := range tasks.Apps() {
+		app := app
+		taskPreflight[app.Task] = func(vpsId string, _ []string) error {
+			return checkInstallPrereqs(vpsId, app)
+		}
+		taskRunners[app.Task] = runInstall
+	}
+}
+
+// agentFacts returns the cached facts for vpsId, asking the agent when stale.
+func agentFacts(vpsId string) (tasks.Facts, error) {
+	factsCacheMu.Lock()
+	cached, ok := factsCache[vpsId]
+	factsCacheMu.Unlock()
+	if ok && time.Since(cached.fetched) < factsTTL {
+		return cached.facts, nil
+	}
+
+	res, err := websocket.SendSignedTaskAndWait(vpsId, "system_facts", nil, 0)
+	if err != nil {
+		return tasks.Facts{}, err
+	}
+	if res.ExitCode != 0 {
+		return tasks.Facts{}, fmt.Errorf("system_facts exited with %d", res.ExitCode)
+	}
+	v, err := tasks.ParseOutput("system_facts", nil, res.Output())
+	if err != nil {
+		return tasks.Facts{}, err
+	}
+	facts := v.(tasks.Facts)
+
+	factsCacheMu.Lock()
+	factsCache[vpsId] = cachedFacts{facts: facts, fetched: time.Now()}
+	factsCacheMu.Unlock()
+	return facts, nil
+}
+
+func prereqResults(app tasks.App, f tasks.Facts) []CheckResult {
+	osOK := false
+	for _, fam := range app.OSFamilies {
+		if fam == f.OSFamily {
+			osOK = true
+		}
+	}
+	return []CheckResult{
+		{
+			Name:   "operating system",
+			Passed: osOK,
+			Detail: fmt.Sprintf("%s %s (%s); supported: %s", f.Distro, f.Version, f.OSFamily, strings.Join(app.OSFamilies, ", ")),
+		},
+		{
+			Name:   "memory",
+			Passed: f.RAMMB >= app.MinRAMMB,
+			Detail: fmt.Sprintf("%d MB, needs %d MB", f.RAMMB, app.MinRAMMB),
+		},
+		{
+			Name:   "free disk",
+			Passed: f.DiskFreeMB >= app.MinDiskMB,
+			Detail: fmt.Sprintf("%d MB, needs %d MB", f.DiskFreeMB, app.MinDiskMB),
+		},
+	}
+}
+
+// checkInstallPrereqs refuses the install before confirmation when the VPS
+// can't run the app.
+func checkInstallPrereqs(vpsId string, app tasks.App) error {
+	facts, err := agentFacts(vpsId)
+	if err != nil {
+		return fmt.Errorf("could not read the server's system facts: %v", err)
+	}
+	var failed []string
+	for _, r := range prereqResults(app, facts) {
+		if !r.Passed {
+			failed = append(failed, r.Name+": "+r.Detail)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s prerequisites not met (%s)", app.Name, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// runInstall dispatches the install task, then verifies it with the app's
+// post-install checks.
+func runInstall(ctx context.Context, vpsId string, spec tasks.Spec, args []string) (Reply, error) {
+	app, ok := tasks.LookupApp(spec.Name)
+	if !ok {
+		return Reply{}, fmt.Errorf("%s is not an app installer", spec.Name)
+	}
+	args = app.Args(args)
+	report := InstallReport{
+		App:       app.ID,
+		VPSID:     vpsId,
+		Args:      args,
+		Estimated: app.EstimatedDuration.String(),
+	}
+
+	// facts may have changed while the action waited for confirmation
+	facts, err := agentFacts(vpsId)
+	if err != nil {
+		return Reply{}, fmt.Errorf("could not read the server's system facts: %w", err)
+	}
+	report.Prereqs = prereqResults(app, facts)
+	for _, r := range report.Prereqs {
+		if !r.Passed {
+			report.Status = "failed"
+			return Reply{Text: formatInstallReport(app, report), Data: report}, nil
+		}
+	}
+
+	start := time.Now()
+	res, err := websocket.SendSignedTaskAndWaitContext(ctx, vpsId, spec.Name, args, spec.DefaultTimeout)
+	if err != nil {
+		return Reply{}, fmt.Errorf("dispatch/%s failed: %w", spec.Name, err)
+	}
+	report.TaskID = res.TaskID
+	report.ExitCode = res.ExitCode
+	report.Duration = time.Since(start).Round(time.Second).String()
+	report.OutputTail = strings.Join(lastLines(strings.Split(strings.TrimSpace(res.Stdout+"\n"+res.Stderr), "\n"), installOutputTail), "\n")
+
+	if res.ExitCode != 0 {
+		report.Status = "failed"
+		return Reply{Text: formatInstallReport(app, report), TaskID: res.TaskID, Data: report}, nil
+	}
+
+	// the install changed disk usage; drop cached facts
+	factsCacheMu.Lock()
+	delete(factsCache, vpsId)
+	factsCacheMu.Unlock()
+
+	report.PostChecks = runPostChecks(vpsId, app.PostChecks)
+	report.Status = "installed"
+	for _, c := range report.PostChecks {
+		if !c.Passed {
+			report.Status = "installed_with_warnings"
+		}
+	}
+	return Reply{Text: formatInstallReport(app, report), TaskID: res.TaskID, Data: report}, nil
+}
+
+func runPostChecks(vpsId string, checks []tasks.PostCheck) []CheckResult {
+	var ports *tasks.ListeningPorts
+	out := make([]CheckResult, 0, len(checks))
+	for _, c := range checks {
+		r := CheckResult{Name: c.Name}
+		switch {
+		case c.Port != 0:
+			if ports == nil {
+				p, err := listeningPorts(vpsId)
+				if err != nil {
+					r.Detail = err.Error()
+					break
+				}
+				ports = &p
+			}
+			r.Passed = ports.Has(c.Port)
+			if !r.Passed {
+				r.Detail = fmt.Sprintf("nothing is listening on port %d", c.Port)
+			}
+		case c.Service != "":
+			r.Passed, r.Detail = checkTaskOutput(vpsId, "service_status", c.Service)
+		case c.VersionOf != "":
+			r.Passed, r.Detail = checkTaskOutput(vpsId, "check_app_version", c.VersionOf)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func listeningPorts(vpsId string) (tasks.ListeningPorts, error) {
+	res, err := websocket.SendSignedTaskAndWait(vpsId, "check_listening_ports", nil, 0)
+	if err != nil {
+		return tasks.ListeningPorts{}, err
+	}
+	v, err := tasks.ParseOutput("check_listening_ports", nil, res.Output())
+	if err != nil {
+		return tasks.ListeningPorts{}, err
+	}
+	return v.(tasks.ListeningPorts), nil
+}
+
+// checkTaskOutput runs a read-only task; exit 0 passes and the first line of
+// output becomes the detail.
+func checkTaskOutput(vpsId, task, arg string) (bool, string) {
+	res, err := websocket.SendSignedTaskAndWait(vpsId, task, []string{arg}, 0)
+	if err != nil {
+		return false, err.Error()
+	}
+	if task == "service_status" {
+		if st, ok := tasks.ParseServiceStatus(res.Stdout); ok {
+			return st.Running(), st.ActiveState + " (" + st.SubState + ")"
+		}
+	}
+	out := strings.TrimSpace(res.Stdout)
+	if res.ExitCode != 0 {
+		out = strings.TrimSpace(res.Stderr)
+	}
+	if i := strings.IndexByte(out, '\n'); i >= 0 {
+		out = out[:i]
+	}
+	return res.ExitCode == 0, out
+}
+
+func formatInstallReport(app tasks.App, r InstallReport) string {
+	var b strings.Builder
+	switch r.Status {
+	case "installed":
+		fmt.Fprintf(&b, "%s was installed on VPS %s in %s (estimated %s).\n", app.Name, r.VPSID, r.Duration, r.Estimated)
+	case "installed_with_warnings":
+		fmt.Fprintf(&b, "%s was installed on VPS %s in %s, but some checks failed.\n", app.Name, r.VPSID, r.Duration)
+	default:
+		fmt.Fprintf(&b, "%s could not be installed on VPS %s.\n", app.Name, r.VPSID)
+	}
+	for _, c := range append(append([]CheckResult(nil), r.Prereqs...), r.PostChecks...) {
+		mark := "ok"
+		if !c.Passed {
+			mark = "FAILED"
+		}
+		fmt.Fprintf(&b, "  [%s] %s", mark, c.Name)
+		if c.Detail != "" {
+			fmt.Fprintf(&b, " - %s", c.Detail)
+		}
+		b.WriteString("\n")
+	}
+	if r.Status == "failed" && r.OutputTail != "" {
+		fmt.Fprintf(&b, "Installer output (exit=%d):\n%s", r.ExitCode, r.OutputTail)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
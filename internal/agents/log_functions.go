@@ -0,0 +1,37 @@
+package agents
+
+import (
+	"ultahost-ai-gateway/internal/ai"
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+func init() {
+	taskPreflight["read_journal"] = checkServiceArg
+	taskFormatters["read_log"] = formatLogDigest
+	taskFormatters["read_log_since"] = formatLogDigest
+	taskFormatters["read_journal"] = formatLogDigest
+}
+
+// formatLogDigest summarizes a compact digest of the log instead of the raw text
+func formatLogDigest(vpsId string, res websocket.TaskResult) string {
+	if res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	logName := res.Task
+	if rec, ok := websocket.GetTask(res.TaskID); ok && len(rec.Args) > 0 {
+		logName = rec.Args[0]
+	}
+
+	digest := tasks.DigestLog(logName, res.Stdout)
+	if digest.TotalLines == 0 {
+		return "That log is empty for the requested range."
+	}
+
+	rawOutput := digest.String()
+	summary, err := ai.SummarizeResponse(rawOutput)
+	if err != nil {
+		return rawOutput
+	}
+	return summary
+}
@@ -0,0 +1,156 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// how long the unit list reported by an agent is trusted
+const unitListTTL = 10 * time.Minute
+
+type unitList struct {
+	units   map[string]bool
+	fetched time.Time
+}
+
+var (
+	unitCacheMu sync.Mutex
+	unitCache   = make(map[string]unitList) // vpsId -> units reported by the agent
+)
+
+func init() {
+	for name := range tasks.ServiceTasks {
+		taskPreflight[name] = checkServiceArg
+	}
+	taskFormatters["service_status"] = formatServiceStatus
+	taskFormatters["list_services"] = formatServiceList
+}
+
+// checkServiceArg allows well-known services, or any unit the agent itself reports
+func checkServiceArg(vpsId string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("which service? e.g. nginx or mysql")
+	}
+	name := tasks.NormalizeServiceName(args[0])
+	if tasks.IsAllowlistedService(name) {
+		return nil
+	}
+
+	units, err := agentUnits(vpsId)
+	if err != nil {
+		return fmt.Errorf("%q is not a known service and the unit list could not be fetched: %v", name, err)
+	}
+	if !units[name] {
+		return fmt.Errorf("there is no service called %q on this server", name)
+	}
+	return nil
+}
+
+func agentUnits(vpsId string) (map[string]bool, error) {
+	unitCacheMu.Lock()
+	cached, ok := unitCache[vpsId]
+	unitCacheMu.Unlock()
+	if ok && time.Since(cached.fetched) < unitListTTL {
+		return cached.units, nil
+	}
+
+	res, err := websocket.SendSignedTaskAndWait(vpsId, "list_services", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("list_services exited with %d", res.ExitCode)
+	}
+	rememberUnits(vpsId, tasks.ParseServiceList(res.Stdout))
+
+	unitCacheMu.Lock()
+	defer unitCacheMu.Unlock()
+	return unitCache[vpsId].units, nil
+}
+
+func rememberUnits(vpsId string, list []tasks.ServiceUnit) {
+	units := make(map[string]bool, len(list))
+	for _, u := range list {
+		units[u.Unit] = true
+	}
+	unitCacheMu.Lock()
+	unitCache[vpsId] = unitList{units: units, fetched: time.Now()}
+	unitCacheMu.Unlock()
+}
+
+// formatServiceStatus reads the status even on non-zero exits: systemctl exits 3
+// for inactive units and 4 for unknown ones.
+func formatServiceStatus(vpsId string, res websocket.TaskResult) string {
+	st, ok := tasks.ParseServiceStatus(res.Stdout + "\n" + res.Stderr)
+	if !ok {
+		return defaultTaskReply(res)
+	}
+	if st.LoadState == "not-found" {
+		return fmt.Sprintf("There is no service called %s on this server.", st.Unit)
+	}
+
+	state := st.ActiveState
+	if st.SubState != "" {
+		state += " (" + st.SubState + ")"
+	}
+	reply := fmt.Sprintf("%s is %s", st.Unit, state)
+	if st.Since != "" {
+		reply += " since " + st.Since
+	}
+	reply += "."
+	switch st.UnitFile {
+	case "enabled":
+		reply += " It starts automatically at boot."
+	case "disabled":
+		reply += " It does not start automatically at boot."
+	}
+	if st.MainPID > 0 {
+		reply += fmt.Sprintf(" Main PID %d", st.MainPID)
+		if st.Memory != "" {
+			reply += ", memory " + st.Memory
+		}
+		reply += "."
+	}
+	if !st.Running() && len(st.RecentLogs) > 0 {
+		reply += "\nRecent log lines:\n" + strings.Join(lastLines(st.RecentLogs, 5), "\n")
+	}
+	return reply
+}
+
+func formatServiceList(vpsId string, res websocket.TaskResult) string {
+	if res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	list := tasks.ParseServiceList(res.Stdout)
+	if len(list) == 0 {
+		return defaultTaskReply(res)
+	}
+	rememberUnits(vpsId, list)
+
+	var running, failed []string
+	for _, u := range list {
+		switch {
+		case u.Active == "failed":
+			failed = append(failed, u.Unit)
+		case u.Sub == "running":
+			running = append(running, u.Unit)
+		}
+	}
+	reply := fmt.Sprintf("%d services are running: %s.", len(running), strings.Join(running, ", "))
+	if len(failed) > 0 {
+		reply += fmt.Sprintf(" %d have failed: %s.", len(failed), strings.Join(failed, ", "))
+	}
+	return reply
+}
+
+func lastLines(lines []string, n int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	return lines[len(lines)-n:]
+}
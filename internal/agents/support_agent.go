@@ -0,0 +1,60 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+
+	"ultahost-ai-gateway/internal/ai"
+	"ultahost-ai-gateway/internal/pkg/models"
+)
+
+// escalate to a human after this many failed replies in a row
+const escalationThreshold = 2
+
+// noMatchPrefix starts every "couldn't match" reply so callers can treat it as a failure
+const noMatchPrefix = "I couldn't match your request"
+
+// IsNoMatchReply reports whether resp is an agent's fallback reply.
+func IsNoMatchReply(resp string) bool {
+	return strings.HasPrefix(resp, noMatchPrefix)
+}
+
+func HandleSupport(req *models.ChatRequest, functionList []string) (string, error) {
+	functionName, err := ai.ClassifyFunctionWithinAgent(req.Message, functionList)
+	if err != nil {
+		return "", err
+	}
+	functionName = strings.ToLower(functionName)
+
+	switch functionName {
+	case "createticket":
+		return createTicket(req)
+	case "listopentickets":
+		return listOpenTickets(req)
+	case "getticketstatus":
+		return getTicketStatus(req)
+	default:
+		return noMatchPrefix + " to a known support function.", nil
+	}
+}
+
+// EscalateIfNeeded opens a high-priority ticket once a conversation has failed
+// escalationThreshold times in a row. It returns a note to append to the reply,
+// or "" when nothing was escalated.
+func EscalateIfNeeded(req *models.ChatRequest, failures int) string {
+	if failures < escalationThreshold {
+		return ""
+	}
+	if id := escalatedTicket(req); id != "" {
+		return fmt.Sprintf("Your conversation is already with our support team (ticket #%s).", id)
+	}
+
+	ticket, err := openTicket(req, true)
+	if err != nil {
+		return ""
+	}
+	if !markEscalated(req, ticket.ID) {
+		return ""
+	}
+	return fmt.Sprintf("I'm having trouble with this one, so I've escalated it to our support team (ticket #%s). They'll follow up shortly.", ticket.ID)
+}
@@ -0,0 +1,175 @@
+package agents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"ultahost-ai-gateway/internal/ai"
+	"ultahost-ai-gateway/internal/client"
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+var SupportFunctionList = []string{
+	"createTicket",
+	"listOpenTickets",
+	"getTicketStatus",
+}
+
+// how many recent agent task results to attach to a new ticket
+const ticketTaskResults = 5
+
+var (
+	ticketIDPattern = regexp.MustCompile(`#?\b(\d{3,})\b`)
+	ticketIDOnly    = regexp.MustCompile(`^\d+$`)
+)
+
+// createTicket opens a ticket from the current conversation
+func createTicket(req *models.ChatRequest) (string, error) {
+	ticket, err := openTicket(req, false)
+	if err != nil {
+		return "", fmt.Errorf("create ticket failed: %w", err)
+	}
+	return fmt.Sprintf("I've opened support ticket #%s (%s). Our team will get back to you soon.", ticket.ID, ticket.Subject), nil
+}
+
+// openTicket builds the ticket body from the message, transcript, VPS id and
+// recent task results, then creates it through the Nest API.
+func openTicket(req *models.ChatRequest, escalated bool) (models.Ticket, error) {
+	priority := "normal"
+	subject := ticketSubject(req.Message)
+	if escalated {
+		priority = "high"
+		subject = "[Escalated by UltaAI] " + subject
+	}
+
+	body := models.CreateTicketRequest{
+		Subject:    subject,
+		Message:    ticketMessage(req),
+		Priority:   priority,
+		VPSID:      req.VPSID,
+		Transcript: Transcript(req),
+		Escalated:  escalated,
+		Source:     "ultaai",
+	}
+
+	var ticket models.Ticket
+	if err := client.NewNestClient(req.UserToken).Post("/support/tickets", body, &ticket); err != nil {
+		return models.Ticket{}, err
+	}
+	if ticket.Subject == "" {
+		ticket.Subject = subject
+	}
+	return ticket, nil
+}
+
+func ticketSubject(message string) string {
+	subject := strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+	if utf8.RuneCountInString(subject) > 80 {
+		subject = truncate(subject, 77)
+	}
+	if subject == "" {
+		subject = "Support request from UltaAI"
+	}
+	return subject
+}
+
+func ticketMessage(req *models.ChatRequest) string {
+	var b strings.Builder
+	b.WriteString(req.Message)
+	b.WriteString("\n")
+
+	if req.VPSID == "" {
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\nVPS ID: %s\n", req.VPSID)
+
+	// task output only goes into the ticket of the VPS's owner
+	if _, err := getVPS(req.UserToken, req.VPSID); err != nil {
+		return b.String()
+	}
+	recent := websocket.RecentTasks(req.VPSID, ticketTaskResults)
+	if len(recent) == 0 {
+		return b.String()
+	}
+	b.WriteString("\nRecent agent tasks:\n")
+	for _, t := range recent {
+		fmt.Fprintf(&b, "- %s %s (%s) at %s", t.Task, strings.Join(t.Args, " "), t.Status, t.SentAt.Format("2006-01-02 15:04:05Z"))
+		if t.Result != nil {
+			fmt.Fprintf(&b, " exit=%d", t.Result.ExitCode)
+			if t.Result.ExitCode != 0 && t.Result.Stderr != "" {
+				fmt.Fprintf(&b, " stderr=%q", truncate(t.Result.Stderr, 300))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func listOpenTickets(req *models.ChatRequest) (string, error) {
+	var tickets []models.Ticket
+	if err := client.NewNestClient(req.UserToken).Get("/support/tickets?status=open", &tickets); err != nil {
+		return "", fmt.Errorf("list tickets failed: %w", err)
+	}
+
+	open := tickets[:0]
+	for _, t := range tickets {
+		if t.IsOpen() {
+			open = append(open, t)
+		}
+	}
+	if len(open) == 0 {
+		return "You don't have any open support tickets.", nil
+	}
+
+	raw, err := json.Marshal(open)
+	if err != nil {
+		return "", err
+	}
+	rawOutput := string(raw)
+	summary, err := ai.SummarizeResponse(rawOutput)
+	if err != nil {
+		return rawOutput, nil
+	}
+	return summary, nil
+}
+
+func getTicketStatus(req *models.ChatRequest) (string, error) {
+	id := ""
+	if len(req.Args) > 0 {
+		id = strings.TrimPrefix(req.Args[0], "#")
+	} else if m := ticketIDPattern.FindStringSubmatch(req.Message); m != nil {
+		id = m[1]
+	}
+	if id == "" || !ticketIDOnly.MatchString(id) {
+		return "Which ticket should I look up? Please include the ticket number.", nil
+	}
+
+	var ticket models.Ticket
+	if err := client.NewNestClient(req.UserToken).Get("/support/tickets/"+id, &ticket); err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return fmt.Sprintf("I couldn't find ticket #%s on your account.", id), nil
+		}
+		return "", fmt.Errorf("get ticket failed: %w", err)
+	}
+
+	reply := fmt.Sprintf("Ticket #%s \"%s\" is %s (priority %s, last updated %s).", ticket.ID, ticket.Subject, ticket.Status, ticket.Priority, ticket.UpdatedAt)
+	if ticket.LastReply != "" {
+		reply += " Latest reply: " + ticket.LastReply
+	}
+	return reply, nil
+}
+
+// truncate shortens s to n runes, marking the cut with "...".
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
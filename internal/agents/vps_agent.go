@@ -2,63 +2,266 @@
 package agents
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"ultahost-ai-gateway/internal/ai"
+	"ultahost-ai-gateway/internal/client"
 	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/tasks"
 	"ultahost-ai-gateway/internal/websocket"
 )
 
-func HandleVPS(req *models.ChatRequest, functionList []string) (string, error) {
-	functionName, err := ai.ClassifyFunctionWithinAgent(req.Message, functionList)
-	if err != nil {
-		return "", err
+// taskPreflight holds extra per-task checks that need the target VPS,
+// run before a task is confirmed or dispatched.
+var taskPreflight = map[string]func(vpsId string, args []string) error{}
+
+// Preflight runs task's extra checks against the VPS, as chat does before
+// dispatching it; tasks without checks pass.
+func Preflight(vpsId, task string, args []string) error {
+	if check, ok := taskPreflight[task]; ok {
+		return check(vpsId, args)
+	}
+	return nil
+}
+
+// taskGuards are preflight checks that need the request itself, e.g. the
+// requester's IP.
+var taskGuards = map[string]func(req *models.ChatRequest, vpsId string, args []string) error{}
+
+// taskFormatters turn a task result into the chat reply; tasks without one
+// reply with stdout (or the failure).
+var taskFormatters = map[string]func(vpsId string, res websocket.TaskResult) string{}
+
+// taskPreviews add task-specific detail to the confirmation preview, e.g.
+// what a restore will overwrite.
+var taskPreviews = map[string]func(vpsId string, args []string) string{}
+
+// taskRunners replace runTask for tasks that need more than one round trip
+// to the agent, e.g. installs with prerequisite and post-install checks.
+var taskRunners = map[string]func(ctx context.Context, vpsId string, spec tasks.Spec, args []string) (Reply, error){}
+
+// workflow chains several agent tasks behind one chat request
+type workflow struct {
+	Description string
+	Params      []tasks.Param
+	Run         func(req *models.ChatRequest, vpsId string, args []string) (Reply, error)
+}
+
+// workflows by name; they are offered alongside catalog tasks
+var workflows = map[string]workflow{}
+
+func registerWorkflow(name string, wf workflow) {
+	workflows[name] = wf
+	VPSFunctionList = append(VPSFunctionList, name)
+}
+
+// Reply is an agent answer plus, for agent tasks, the structured task output
+type Reply struct {
+	Text   string
+	TaskID string
+	Data   interface{}
+}
+
+var errNotYourVPS = errors.New("I couldn't find that VPS on your account")
+
+// checkOwnsVPS fails with errNotYourVPS unless the user's Nest account has
+// vpsId, the same check the REST routes make.
+func checkOwnsVPS(userToken, vpsId string) error {
+	_, err := getVPS(userToken, vpsId)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusForbidden) {
+		return errNotYourVPS
 	}
+	return err
+}
 
-	// require target VPSID for agent tasks
+func HandleVPS(req *models.ChatRequest, functionList []string) (Reply, error) {
+	// classify against the catalog descriptions of the allowed tasks
+	options := make(map[string]string, len(functionList))
+	for _, name := range functionList {
+		if spec, ok := tasks.Resolve(name); ok {
+			options[spec.Name] = spec.Description
+		} else if wf, ok := workflows[name]; ok {
+			options[name] = wf.Description
+		}
+	}
+
+	// require target VPSID for agent tasks, on a VPS the caller owns
 	vpsId := req.VPSID
 	if vpsId == "" {
-		return "", fmt.Errorf("vps_id is required to perform agent tasks; include it in your request")
+		return Reply{}, fmt.Errorf("vps_id is required to perform agent tasks; include it in your request")
+	}
+	if err := checkOwnsVPS(req.UserToken, vpsId); err != nil {
+		if errors.Is(err, errNotYourVPS) {
+			return Reply{Text: err.Error()}, nil
+		}
+		return Reply{}, err
 	}
 
-	// choose a reasonable backend wait timeout for result (adjustable)
-	waitTimeout := 2 * time.Minute
+	functionName, err := ai.ClassifyFunctionWithDescriptions(req.Message, options)
+	if err != nil {
+		return Reply{}, err
+	}
+	functionName = strings.TrimSpace(functionName)
 
-	switch functionName {
-	case "checkuptime":
-		res, err := websocket.SendSignedTaskAndWait(vpsId, "check_uptime", req.Args, waitTimeout)
+	if wf, ok := workflows[functionName]; ok && options[functionName] != "" {
+		args, err := extractParams(req, functionName, wf.Params)
 		if err != nil {
-			return "", fmt.Errorf("dispatch/check_uptime failed: %w", err)
+			return Reply{}, err
 		}
-		if res.ExitCode == 0 {
-			return res.Stdout, nil
-		}
-		return fmt.Sprintf("Command failed (exit=%d): %s", res.ExitCode, res.Stderr), nil
+		return wf.Run(req, vpsId, args)
+	}
 
-	case "checkdiskspace":
-		res, err := websocket.SendSignedTaskAndWait(vpsId, "check_diskspace", req.Args, waitTimeout)
-		if err != nil {
-			return "", fmt.Errorf("dispatch/check_diskspace failed: %w", err)
+	spec, ok := tasks.Resolve(functionName)
+	if _, allowed := options[spec.Name]; !ok || !allowed {
+		return Reply{Text: "I couldn't match your request to a known VPS function."}, nil
+	}
+
+	args, err := taskArgs(req, spec)
+	if err != nil {
+		return Reply{}, err
+	}
+	if err := spec.ValidateArgs(args); err != nil {
+		return Reply{Text: fmt.Sprintf("I can't run %s with those details: %v", spec.Name, err)}, nil
+	}
+	if check, ok := taskPreflight[spec.Name]; ok {
+		if err := check(vpsId, args); err != nil {
+			return Reply{Text: fmt.Sprintf("I can't run %s: %v", spec.Name, err)}, nil
+		}
+	}
+	if guard, ok := taskGuards[spec.Name]; ok {
+		if err := guard(req, vpsId, args); err != nil {
+			return Reply{Text: fmt.Sprintf("I won't run %s: %v", spec.Name, err)}, nil
 		}
-		if res.ExitCode == 0 {
-			return res.Stdout, nil
+	}
+
+	if spec.Confirm {
+		summary := fmt.Sprintf("This will run %s on VPS %s.", spec.Name, vpsId)
+		preview := describeTask(spec, args)
+		if extra, ok := taskPreviews[spec.Name]; ok {
+			preview += "\n" + extra(vpsId, args)
 		}
-		return fmt.Sprintf("Command failed (exit=%d): %s", res.ExitCode, res.Stderr), nil
+		return Reply{Text: stageAction(req, summary, preview, func(ctx context.Context) (Reply, error) {
+			// the VPS may have changed while the user read the preview, e.g.
+			// the firewall rules a lockout guard checked
+			if guard, ok := taskGuards[spec.Name]; ok {
+				if err := guard(req, vpsId, args); err != nil {
+					return Reply{Text: fmt.Sprintf("I won't run %s any more: %v", spec.Name, err)}, nil
+				}
+			}
+			return runTask(ctx, vpsId, spec, args)
+		})}, nil
+	}
+	return runTask(req.Context(), vpsId, spec, args)
+}
+
+// runTask dispatches the task and waits up to the catalog's default timeout,
+// or until ctx is done, which cancels the task. Parsed output is returned as
+// Data and summarized; when parsing fails the reply falls back to the raw
+// output. A task that had to wait for a busy agent says so in the reply.
+func runTask(ctx context.Context, vpsId string, spec tasks.Spec, args []string) (Reply, error) {
+	var behind atomic.Int64 // the task's first queue position
+	ctx = websocket.WithQueueObserver(ctx, func(_ string, position int) {
+		behind.CompareAndSwap(0, int64(position))
+	})
+	reply, err := sendTask(ctx, vpsId, spec, args)
+	if err == nil && behind.Load() > 0 {
+		reply.Text = fmt.Sprintf("Your server was busy, so this task waited in line (position %d) before it started.\n\n%s", behind.Load(), reply.Text)
+	}
+	return reply, err
+}
+
+func sendTask(ctx context.Context, vpsId string, spec tasks.Spec, args []string) (Reply, error) {
+	if run, ok := taskRunners[spec.Name]; ok {
+		return run(ctx, vpsId, spec, args)
+	}
 
-	case "installwordpress", "install_wordpress":
-		// install can take longer; choose a longer wait (adjust as needed)
-		installWait := 10 * time.Minute
-		res, err := websocket.SendSignedTaskAndWait(vpsId, "install_wordpress", req.Args, installWait)
+	res, err := websocket.SendSignedTaskAndWaitContext(ctx, vpsId, spec.Name, args, spec.DefaultTimeout)
+	if err != nil {
+		return Reply{}, fmt.Errorf("dispatch/%s failed: %w", spec.Name, err)
+	}
+
+	reply := Reply{TaskID: res.TaskID}
+	if data, err := tasks.ParseOutput(spec.Name, args, res.Output()); err == nil {
+		reply.Data = data
+	}
+
+	switch format, ok := taskFormatters[spec.Name]; {
+	case ok:
+		reply.Text = format(vpsId, res)
+	case reply.Data != nil && res.ExitCode == 0:
+		summary, err := ai.SummarizeStructured(spec.Name, reply.Data)
 		if err != nil {
-			return "", fmt.Errorf("dispatch/install_wordpress failed: %w", err)
+			summary = defaultTaskReply(res)
 		}
-		if res.ExitCode == 0 {
-			return res.Stdout, nil
+		reply.Text = summary
+	default:
+		reply.Text = defaultTaskReply(res)
+	}
+	return reply, nil
+}
+
+func defaultTaskReply(res websocket.TaskResult) string {
+	if res.Cancelled {
+		return "The task was cancelled before it finished."
+	}
+	if res.ExitCode == 0 {
+		return res.Stdout
+	}
+	return fmt.Sprintf("Command failed (exit=%d): %s", res.ExitCode, res.Stderr)
+}
+
+// taskArgs uses explicit request args if present, otherwise extracts the
+// task's positional params from the message.
+func taskArgs(req *models.ChatRequest, spec tasks.Spec) ([]string, error) {
+	return extractParams(req, spec.Name, spec.Params())
+}
+
+func extractParams(req *models.ChatRequest, name string, params []tasks.Param) ([]string, error) {
+	if len(req.Args) > 0 {
+		return req.Args, nil
+	}
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	fields := make(map[string]string, len(params))
+	for _, p := range params {
+		fields[p.Name] = p.Description
+	}
+	values, err := ai.ExtractArguments(req.Message, fields)
+	if err != nil {
+		return nil, fmt.Errorf("could not understand arguments for %s: %w", name, err)
+	}
+
+	// args are positional, so stop at the first one the user didn't give
+	var args []string
+	for _, p := range params {
+		v := values[p.Name]
+		if v == "" {
+			break
 		}
-		return fmt.Sprintf("Install failed (exit=%d): %s", res.ExitCode, res.Stderr), nil
+		args = append(args, v)
+	}
+	return args, nil
+}
 
-	default:
-		return "I couldn't match your request to a known VPS function.", nil
+func describeTask(spec tasks.Spec, args []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Task: %s (%s)\n", spec.Name, spec.Description)
+	params := spec.Params()
+	for i, a := range args {
+		name := fmt.Sprintf("arg%d", i+1)
+		if i < len(params) {
+			name = params[i].Name
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", name, a)
 	}
+	fmt.Fprintf(&b, "Risk: %s", spec.Risk)
+	return b.String()
 }
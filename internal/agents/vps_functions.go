@@ -4,14 +4,11 @@ import (
 	"fmt"
 	"ultahost-ai-gateway/internal/ai"
 	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/tasks"
 )
 
-// List of all available VPS functions
-var VPSFunctionList = []string{
-	"checkUptime",
-	"checkDiskSpace",
-	"installWordPress",
-}
+// List of all available VPS functions, driven by the task catalog
+var VPSFunctionList = tasks.Names()
 
 // Function to check system uptime
 func checkUptime(req *models.ChatRequest) (string, error) {
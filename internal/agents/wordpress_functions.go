@@ -0,0 +1,185 @@
+package agents
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// inventories are reused between a change's preflight and its preview
+const wpInventoryTTL = 2 * time.Minute
+
+type cachedInventory struct {
+	inv     tasks.WPInventory
+	fetched time.Time
+}
+
+var (
+	wpInventoryMu    sync.Mutex
+	wpInventoryCache = make(map[string]cachedInventory) // vpsId|site -> inventory
+)
+
+func init() {
+	taskFormatters["wp_inventory"] = formatWPInventory
+	taskFormatters["wp_core_update"] = formatWPUpdates
+	taskFormatters["wp_plugin_update"] = formatWPUpdates
+
+	taskPreflight["wp_plugin_update"] = checkWPPlugin
+	taskPreflight["wp_plugin_deactivate"] = checkWPPlugin
+	taskPreviews["wp_core_update"] = previewWPCoreUpdate
+	taskPreviews["wp_plugin_update"] = previewWPPluginUpdate
+
+	// any change makes the cached inventory stale
+	websocket.OnTaskFinished(func(rec websocket.TaskRecord) {
+		if !tasks.WordPressTasks[rec.Task] || rec.Task == "wp_inventory" || len(rec.Args) == 0 {
+			return
+		}
+		wpInventoryMu.Lock()
+		delete(wpInventoryCache, rec.VPSID+"|"+rec.Args[0])
+		wpInventoryMu.Unlock()
+	})
+}
+
+func wpInventory(vpsId, site string) (tasks.WPInventory, error) {
+	key := vpsId + "|" + site
+	wpInventoryMu.Lock()
+	cached, ok := wpInventoryCache[key]
+	wpInventoryMu.Unlock()
+	if ok && time.Since(cached.fetched) < wpInventoryTTL {
+		return cached.inv, nil
+	}
+
+	v, err := runParsed(vpsId, "wp_inventory", []string{site})
+	if err != nil {
+		return tasks.WPInventory{}, err
+	}
+	inv := v.(tasks.WPInventory)
+
+	wpInventoryMu.Lock()
+	wpInventoryCache[key] = cachedInventory{inv: inv, fetched: time.Now()}
+	wpInventoryMu.Unlock()
+	return inv, nil
+}
+
+func findPlugin(inv tasks.WPInventory, slug string) (tasks.WPComponent, bool) {
+	for _, p := range inv.Plugins {
+		if p.Name == slug {
+			return p, true
+		}
+	}
+	return tasks.WPComponent{}, false
+}
+
+// checkWPPlugin requires the plugin to be installed on the site.
+func checkWPPlugin(vpsId string, args []string) error {
+	if args[1] == "all" {
+		return nil
+	}
+	inv, err := wpInventory(vpsId, args[0])
+	if err != nil {
+		return fmt.Errorf("could not read the site's plugins: %v", err)
+	}
+	if _, ok := findPlugin(inv, args[1]); !ok {
+		return fmt.Errorf("plugin %q is not installed on %s", args[1], args[0])
+	}
+	return nil
+}
+
+func previewWPCoreUpdate(vpsId string, args []string) string {
+	inv, err := wpInventory(vpsId, args[0])
+	if err != nil {
+		return ""
+	}
+	if inv.CoreUpdate == "" {
+		return fmt.Sprintf("WordPress %s is already the latest version.", inv.CoreVersion)
+	}
+	return fmt.Sprintf("WordPress %s -> %s. Consider taking a backup first (backup_site).", inv.CoreVersion, inv.CoreUpdate)
+}
+
+func previewWPPluginUpdate(vpsId string, args []string) string {
+	inv, err := wpInventory(vpsId, args[0])
+	if err != nil {
+		return ""
+	}
+	var lines []string
+	for _, p := range inv.Plugins {
+		if p.Outdated && (args[1] == "all" || p.Name == args[1]) {
+			lines = append(lines, fmt.Sprintf("  %s %s -> %s", p.Name, p.Version, p.UpdateVersion))
+		}
+	}
+	if len(lines) == 0 {
+		return "No updates are available for that selection."
+	}
+	return "Updates:\n" + strings.Join(lines, "\n")
+}
+
+func formatWPInventory(_ string, res websocket.TaskResult) string {
+	v, err := tasks.ParseOutput("wp_inventory", nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	inv := v.(tasks.WPInventory)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "WordPress %s", inv.CoreVersion)
+	if inv.CoreUpdate != "" {
+		fmt.Fprintf(&b, " (update to %s available)", inv.CoreUpdate)
+	}
+	if inv.CoreVulnerable {
+		b.WriteString(" - this core version has known vulnerabilities")
+	}
+	if inv.MaintenanceActive {
+		b.WriteString(", maintenance mode is ON")
+	}
+	b.WriteString("\n")
+	writeComponents(&b, "Plugins", inv.Plugins)
+	writeComponents(&b, "Themes", inv.Themes)
+	if vuln := inv.Vulnerable(); len(vuln) > 0 {
+		fmt.Fprintf(&b, "%d components have known vulnerabilities; update or deactivate them.", len(vuln))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func writeComponents(b *strings.Builder, title string, cs []tasks.WPComponent) {
+	fmt.Fprintf(b, "%s (%d):\n", title, len(cs))
+	for _, c := range cs {
+		fmt.Fprintf(b, "  %s %s [%s]", c.Name, c.Version, c.Status)
+		if c.Outdated {
+			fmt.Fprintf(b, " update: %s", c.UpdateVersion)
+		}
+		if c.Vulnerable {
+			fmt.Fprintf(b, " VULNERABLE: %s", strings.Join(c.Vulnerabilities, "; "))
+		}
+		b.WriteString("\n")
+	}
+}
+
+func formatWPUpdates(_ string, res websocket.TaskResult) string {
+	if res.ExitCode != 0 {
+		return defaultTaskReply(res)
+	}
+	v, err := tasks.ParseWPUpdates(nil, res.Output())
+	if err != nil {
+		return defaultTaskReply(res)
+	}
+	u := v.(tasks.WPUpdates)
+	if len(u.Updates) == 0 {
+		return "Everything is already up to date."
+	}
+	var b strings.Builder
+	for _, r := range u.Updates {
+		fmt.Fprintf(&b, "%s: %s", r.Name, r.Status)
+		if r.NewVersion != "" && r.NewVersion != r.OldVersion {
+			fmt.Fprintf(&b, " (%s -> %s)", r.OldVersion, r.NewVersion)
+		}
+		b.WriteString("\n")
+	}
+	if failed := u.Failed(); len(failed) > 0 {
+		fmt.Fprintf(&b, "%d updates failed; check the site still loads.", len(failed))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -3,6 +3,7 @@ package ai
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"ultahost-ai-gateway/internal/config"
 
@@ -12,14 +13,18 @@ import (
 // ClassifyFunctionWithinAgent suggests the most appropriate function name
 // from the given list based on the user query.
 func ClassifyFunctionWithinAgent(query string, functionList []string) (string, error) {
-	client := openai.NewClient(config.AppConfig.OpenAIKey)
-
 	functions := strings.Join(functionList, ", ")
 
 	systemMsg := fmt.Sprintf(`You are an intelligent assistant. Given a user query and a list of function names [%s], 
 your job is to return ONLY the single most relevant function name from the list — no explanations, no formatting, just the raw function name.
 If there's no suitable match, return "unknown" only.`, functions)
 
+	return classifyFunction(query, systemMsg)
+}
+
+func classifyFunction(query, systemMsg string) (string, error) {
+	client := openai.NewClient(config.AppConfig.OpenAIKey)
+
 	userMsg := fmt.Sprintf("User query: %q", query)
 
 	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
@@ -41,3 +46,23 @@ If there's no suitable match, return "unknown" only.`, functions)
 
 	return fn, nil
 }
+
+// ClassifyFunctionWithDescriptions is ClassifyFunctionWithinAgent for callers
+// that can describe each function (name -> description), which helps the model
+// pick between similarly named functions.
+func ClassifyFunctionWithDescriptions(query string, functions map[string]string) (string, error) {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&list, "- %s: %s\n", name, functions[name])
+	}
+	return classifyFunction(query, fmt.Sprintf(`You are an intelligent assistant. Given a user query and the following functions:
+%s
+your job is to return ONLY the single most relevant function name from the list — no explanations, no formatting, just the raw function name.
+If there's no suitable match, return "unknown" only.`, list.String()))
+}
@@ -0,0 +1,61 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"ultahost-ai-gateway/internal/config"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ExtractArguments pulls the named fields out of a user query. Every field is
+// present in the result; missing values are returned as "".
+func ExtractArguments(query string, fields map[string]string) (map[string]string, error) {
+	client := openai.NewClient(config.AppConfig.OpenAIKey)
+
+	var fieldList strings.Builder
+	for name, desc := range fields {
+		fmt.Fprintf(&fieldList, "- %s: %s\n", name, desc)
+	}
+
+	systemMsg := fmt.Sprintf(`You extract parameters from a user request. The fields are:
+%s
+Return ONLY a JSON object with exactly these keys and string values — no explanations, no formatting.
+Use "" for any field the user did not specify. Never invent values.`, fieldList.String())
+
+	userMsg := fmt.Sprintf("User query: %q", query)
+
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model: openai.GPT3Dot5Turbo,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: systemMsg},
+			{Role: "user", Content: userMsg},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// models sometimes wrap the object in a code fence
+	content := strings.TrimSpace(resp.Choices[0].Message.Content)
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &raw); err != nil {
+		return nil, fmt.Errorf("invalid extraction output: %w", err)
+	}
+
+	out := make(map[string]string, len(fields))
+	for name := range fields {
+		if v, ok := raw[name]; ok && v != nil {
+			out[name] = strings.TrimSpace(fmt.Sprint(v))
+		} else {
+			out[name] = ""
+		}
+	}
+	return out, nil
+}
@@ -2,6 +2,7 @@ package ai
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"ultahost-ai-gateway/internal/config"
@@ -31,3 +32,13 @@ Convert it into a short, clear, and friendly sentence that a non-technical user
 	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
 	return summary, nil
 }
+
+// SummarizeStructured summarizes parsed task output; the JSON keeps numbers
+// like "use_percent" exact instead of asking the model to read raw tables.
+func SummarizeStructured(task string, data interface{}) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return SummarizeResponse(fmt.Sprintf("Result of %s as JSON: %s", task, raw))
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"ultahost-ai-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleListAgents lists the agents connected to every gateway node. When
+// the broker registry is unreachable only this node's agents are listed,
+// with the error.
+func HandleListAgents(c *gin.Context) {
+	agents, err := websocket.FleetStatus()
+	resp := gin.H{"node": websocket.NodeName(), "count": len(agents), "agents": agents}
+	if err != nil {
+		resp["error"] = "listing agents on other nodes: " + err.Error()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleAgentDetail shows one agent's presence, wherever it is connected.
+func HandleAgentDetail(c *gin.Context) {
+	c.JSON(http.StatusOK, websocket.AgentStatusFor(c.Param("vps")))
+}
+
+// agentPresence is what a customer sees about the agent on their VPS
+type agentPresence struct {
+	Online       bool       `json:"online"`
+	ConnectedAt  *time.Time `json:"connected_at,omitempty"`
+	LastSeen     *time.Time `json:"last_seen,omitempty"`
+	AgentVersion string     `json:"agent_version,omitempty"`
+	PingRTTMs    float64    `json:"ping_rtt_ms,omitempty"`
+	RunningTasks []string   `json:"running_tasks"`
+}
+
+// HandleVPSAgentStatus tells the owner whether UltaAI is online on their VPS.
+func HandleVPSAgentStatus(c *gin.Context) {
+	vpsId := c.Param("id")
+	if !checkVPSOwner(c, vpsId) {
+		return
+	}
+	st := websocket.AgentStatusFor(vpsId)
+	p := agentPresence{
+		Online:       st.Online,
+		ConnectedAt:  st.ConnectedAt,
+		LastSeen:     st.LastSeen,
+		AgentVersion: st.AgentVersion,
+		PingRTTMs:    st.PingRTTMs,
+		RunningTasks: []string{},
+	}
+	for _, t := range st.RunningTasks {
+		p.RunningTasks = append(p.RunningTasks, t.Task)
+	}
+	c.JSON(http.StatusOK, p)
+}
@@ -0,0 +1,14 @@
+package api
+
+import (
+	"net/http"
+
+	"ultahost-ai-gateway/internal/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleListApps returns the installable app catalog.
+func HandleListApps(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"apps": tasks.Apps()})
+}
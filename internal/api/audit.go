@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"ultahost-ai-gateway/internal/agents"
+	"ultahost-ai-gateway/internal/client"
+	"ultahost-ai-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleSecurityAudit runs the read-only security audit on a VPS the caller owns.
+func HandleSecurityAudit(c *gin.Context) {
+	report, err := agents.AuditVPS(c.GetString("user_token"), c.Param("id"))
+	if err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusForbidden) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "VPS not found"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// HandleAgentAudit lists the messages this node refused from a VPS's agent,
+// newest first.
+func HandleAgentAudit(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"events": websocket.AuditEvents(c.Param("vps"), queryLimit(c, 50))})
+}
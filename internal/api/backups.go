@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+
+	"ultahost-ai-gateway/internal/agents"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleListBackups returns the backups the gateway has tracked for a VPS the caller owns.
+func HandleListBackups(c *gin.Context) {
+	vpsId := c.Param("id")
+	if !checkVPSOwner(c, vpsId) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"backups": agents.TrackedBackups(vpsId)})
+}
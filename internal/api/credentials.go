@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"ultahost-ai-gateway/internal/agents"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleTakeCredential hands out a generated credential once to the owner of
+// the VPS it was generated on.
+func HandleTakeCredential(c *gin.Context) {
+	vpsId := c.Param("id")
+	if !checkVPSOwner(c, vpsId) {
+		return
+	}
+	cred, ok := agents.TakeCredential(vpsId, c.Param("credential_id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "credential not found, expired or already collected"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, cred)
+}
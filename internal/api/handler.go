@@ -21,6 +21,24 @@ func HandleChat(c *gin.Context) {
 	}
 
 	req.UserToken = c.GetString("user_token")
+	req.ClientIP = c.ClientIP()
+	if req.CancelOnDisconnect {
+		req = req.WithContext(c.Request.Context())
+	}
+
+	// answers to a previewed change ("yes"/"no" or confirmation_id) skip classification
+	if reply, handled, err := agents.HandleConfirmation(req); handled {
+		if err != nil {
+			writeChatReply(c, req, http.StatusBadGateway, gin.H{"error": err.Error()}, true)
+			return
+		}
+		writeChatReply(c, req, http.StatusOK, replyBody(reply), false)
+		return
+	}
+	if reply, handled := agents.HandleTaskCancel(req); handled {
+		writeChatReply(c, req, http.StatusOK, replyBody(reply), false)
+		return
+	}
 
 	category, err := ai.ClassifyPromptCategory(&models.CategoryRequest{
 		Query: req.Message,
@@ -43,43 +61,66 @@ func HandleChat(c *gin.Context) {
 	}
 	fmt.Println(" Received token in", category, "agent:", req.UserToken)
 
+	var resp string
+	var reply agents.Reply
 	switch category {
 	case "vps", "vm_command", "server_metrics", "wordpress":
-		
-		resp, err := agents.HandleVPS(req, agents.VPSFunctionList)
-		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"response": resp})
+		reply, err = agents.HandleVPS(req, agents.VPSFunctionList)
 
 	case "billing":
-		resp, err := agents.HandleBilling(req)
-		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"response": resp})
+		resp, err = agents.HandleBilling(req)
 
 	case "domain":
-		resp, err := agents.HandleDomain(req)
-		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"response": resp})
+		resp, err = agents.HandleDomain(req, agents.DomainFunctionList)
 
 	case "products", "product_info", "hosting_plans":
-		resp, err := agents.HandleProducts(req, agents.ProductsFunctionList)
-		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"response": resp})
+		resp, err = agents.HandleProducts(req, agents.ProductsFunctionList)
+
+	case "support":
+		resp, err = agents.HandleSupport(req, agents.SupportFunctionList)
 
 	default:
-		c.JSON(http.StatusNotImplemented, gin.H{"response": "I couldn’t process this request. Please rephrase or try again."})
+		writeChatReply(c, req, http.StatusNotImplemented, gin.H{"response": "I couldn’t process this request. Please rephrase or try again."}, true)
+		return
+	}
+
+	if err != nil {
+		writeChatReply(c, req, http.StatusBadGateway, gin.H{"error": err.Error()}, true)
+		return
+	}
+	if reply.Text == "" {
+		reply.Text = resp
+	}
+	writeChatReply(c, req, http.StatusOK, replyBody(reply), agents.IsNoMatchReply(reply.Text))
+}
+
+// replyBody exposes structured task output next to the text reply
+func replyBody(reply agents.Reply) gin.H {
+	body := gin.H{"response": reply.Text}
+	if reply.TaskID != "" {
+		body["task_id"] = reply.TaskID
+	}
+	if reply.Data != nil {
+		body["data"] = reply.Data
+	}
+	return body
+}
+
+// writeChatReply records the exchange on the conversation and escalates to
+// support when the assistant keeps failing.
+func writeChatReply(c *gin.Context, req *models.ChatRequest, status int, body gin.H, failed bool) {
+	reply, _ := body["response"].(string)
+	if reply == "" {
+		reply, _ = body["error"].(string)
+	}
+	failures := agents.RecordExchange(req, reply, failed)
+	if note := agents.EscalateIfNeeded(req, failures); note != "" {
+		body["escalation"] = note
+	}
+	if id := agents.PendingConfirmationID(req); id != "" {
+		body["confirmation_id"] = id
 	}
+	c.JSON(status, body)
 }
 
 func InitAgent(c *gin.Context) {
@@ -0,0 +1,29 @@
+// internal/api/middleware_admin.go
+package api
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"strings"
+
+	"ultahost-ai-gateway/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware admits operators holding the configured admin token.
+// Without a token configured the admin routes are closed.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		want := ""
+		if config.AppConfig != nil {
+			want = config.AppConfig.AdminToken
+		}
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if want == "" || !hmac.Equal([]byte(token), []byte(want)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"ultahost-ai-gateway/internal/client"
+	"ultahost-ai-gateway/internal/pkg/models"
+	"ultahost-ai-gateway/internal/scheduler"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ownerID identifies the caller for owner-scoped data: the user id from the
+// auth response when present, otherwise the token itself.
+func ownerID(c *gin.Context) string {
+	if info, ok := c.Get("user_info"); ok {
+		if m, ok := info.(map[string]interface{}); ok {
+			switch id := m["id"].(type) {
+			case string:
+				if id != "" {
+					return "user:" + id
+				}
+			case float64:
+				return "user:" + strconv.FormatFloat(id, 'f', -1, 64)
+			}
+		}
+	}
+	return "token:" + c.GetString("user_token")
+}
+
+// checkVPSOwner writes the error response and returns false unless the
+// caller owns vpsId.
+func checkVPSOwner(c *gin.Context, vpsId string) bool {
+	var vps models.VPS
+	err := client.NewNestClient(c.GetString("user_token")).Get("/vps/"+url.PathEscape(vpsId), &vps)
+	if err == nil {
+		return true
+	}
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusForbidden) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VPS not found"})
+	} else {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("lookup VPS failed: %v", err)})
+	}
+	return false
+}
+
+func HandleCreateSchedule(c *gin.Context) {
+	var req struct {
+		VPSID      string                `json:"vps_id"`
+		Task       string                `json:"task"`
+		Args       []string              `json:"args"`
+		Every      string                `json:"every"`
+		Thresholds []scheduler.Threshold `json:"thresholds"`
+		// queue_offline delivers a run when the agent reconnects instead of
+		// skipping it
+		QueueOffline bool `json:"queue_offline"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.VPSID == "" || req.Task == "" || req.Every == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "vps_id, task and every are required"})
+		return
+	}
+	if !checkVPSOwner(c, req.VPSID) {
+		return
+	}
+
+	s, err := scheduler.Create(ownerID(c), req.VPSID, req.Task, req.Args, req.Every, req.Thresholds, req.QueueOffline)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+func HandleListSchedules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"schedules": scheduler.List(ownerID(c))})
+}
+
+func HandleUpdateSchedule(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+	s, ok := scheduler.SetEnabled(ownerID(c), c.Param("id"), *req.Enabled)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+func HandleDeleteSchedule(c *gin.Context) {
+	if !scheduler.Delete(ownerID(c), c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func HandleScheduleRuns(c *gin.Context) {
+	runs, ok := scheduler.Runs(ownerID(c), c.Param("id"), queryLimit(c, 20))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func HandleNotifications(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"notifications": scheduler.Notifications(ownerID(c), queryLimit(c, 50))})
+}
+
+func queryLimit(c *gin.Context, def int) int {
+	n, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || n <= 0 || n > 200 {
+		return def
+	}
+	return n
+}
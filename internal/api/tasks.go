@@ -0,0 +1,91 @@
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"ultahost-ai-gateway/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleCancelTask cancels a task on a VPS the caller owns: a running task is
+// asked to stop, a waiting or queued one is dropped before it reaches the agent.
+func HandleCancelTask(c *gin.Context) {
+	vpsId, taskID := c.Param("id"), c.Param("task_id")
+	if !checkVPSOwner(c, vpsId) || !taskOnVPS(c, vpsId, taskID) {
+		return
+	}
+	if err := websocket.CancelTask(taskID); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	rec, _ := websocket.GetTask(taskID)
+	c.JSON(http.StatusAccepted, rec)
+}
+
+// taskOnVPS writes a 404 unless taskID is a task on vpsId.
+func taskOnVPS(c *gin.Context, vpsId, taskID string) bool {
+	rec, ok := websocket.GetTask(taskID)
+	if !ok || rec.VPSID != vpsId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return false
+	}
+	return true
+}
+
+// HandleTaskStream sends a task's output and progress as server-sent
+// events, ending with a result event.
+func HandleTaskStream(c *gin.Context) {
+	vpsId, taskID := c.Param("id"), c.Param("task_id")
+	if !checkVPSOwner(c, vpsId) || !taskOnVPS(c, vpsId, taskID) {
+		return
+	}
+	snapshot, events, cancel, err := websocket.SubscribeTask(taskID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	defer cancel()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, ev := range snapshot {
+		c.SSEvent(ev.Kind, ev)
+	}
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Kind, ev)
+			return ev.Kind != websocket.EventResult
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// HandleTaskEventsToken issues the token a browser opens HandleTaskEvents
+// with, since it can't send an Authorization header on a websocket.
+func HandleTaskEventsToken(c *gin.Context) {
+	vpsId, taskID := c.Param("id"), c.Param("task_id")
+	if !checkVPSOwner(c, vpsId) || !taskOnVPS(c, vpsId, taskID) {
+		return
+	}
+	token, expires, err := websocket.IssueEventsToken(taskID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue token"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expires.UTC()})
+}
+
+// HandleTaskEvents is HandleTaskStream over a websocket, for browsers. It
+// sits outside AuthMiddleware: the token from HandleTaskEventsToken, issued
+// only for a task on a VPS the user owns, authorizes it.
+func HandleTaskEvents(c *gin.Context) {
+	websocket.ServeTaskEvents(c.Writer, c.Request, c.Param("task_id"))
+}
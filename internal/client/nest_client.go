@@ -1 +1,98 @@
-package clinet
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+)
+
+// NestClient calls the UltaHost Nest API on behalf of a user.
+type NestClient struct {
+	BaseURL   string
+	UserToken string
+	HTTP      *http.Client
+}
+
+// APIError is returned when the Nest API answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("nest api returned %d: %s", e.StatusCode, e.Body)
+}
+
+func NewNestClient(userToken string) *NestClient {
+	return &NestClient{
+		BaseURL:   strings.TrimRight(config.AppConfig.NestAPIBase, "/"),
+		UserToken: userToken,
+		HTTP:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *NestClient) Get(path string, out interface{}) error {
+	return c.do(http.MethodGet, path, nil, out)
+}
+
+func (c *NestClient) Post(path string, body, out interface{}) error {
+	return c.do(http.MethodPost, path, body, out)
+}
+
+func (c *NestClient) Put(path string, body, out interface{}) error {
+	return c.do(http.MethodPut, path, body, out)
+}
+
+func (c *NestClient) Delete(path string, out interface{}) error {
+	return c.do(http.MethodDelete, path, nil, out)
+}
+
+// do sends a JSON request and decodes a JSON response into out (if non-nil).
+func (c *NestClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.UserToken)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = respBody
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +13,29 @@ type Config struct {
 	Port        string
 	NestAPIBase string
 	OpenAIKey   string
+
+	ScheduleStorePath string // JSON file holding schedules, runs and notifications
+	AlertWebhookURL   string // optional; notifications are POSTed here
+	TaskQueuePath     string // JSON file holding tasks queued for offline agents
+
+	GatewayIPs []string // public addresses agents connect to; firewall changes may not block them
+
+	MinAgentProtocol int // agents announcing an older protocol version are refused
+	MaxTasksPerAgent int // tasks one agent runs at once; the rest wait their turn
+
+	// multi-node: without a registry URL the gateway runs as a single node
+	NodeID              string // this node's name; defaults to the hostname
+	NodeURL             string // base URL other nodes reach this node on
+	BrokerRegistryURL   string // agent ownership registry shared by all nodes
+	BrokerSecret        string // authenticates node-to-node requests
+	ServeBrokerRegistry bool   // host the registry on this node
+
+	AdminToken string // bearer token for the operator /admin routes; unset closes them
+
+	BrowserOrigins []string // origins whose pages may open websockets; unset allows only the gateway's own
+
+	ShutdownGraceSec  int // how long shutdown or a drain waits for running tasks
+	ReconnectDelaySec int // told to agents when their connection is closed for a restart
 }
 
 var AppConfig *Config
@@ -25,7 +50,55 @@ func LoadConfig() {
 		Port:        getEnv("PORT", "8089"),
 		NestAPIBase: getEnv("NEST_API_URL", "https://api.ultahost.dev"),
 		OpenAIKey:   getEnv("OPENAI_KEY", ""),
+
+		ScheduleStorePath: getEnv("SCHEDULE_STORE_PATH", "./data/schedules.json"),
+		AlertWebhookURL:   getEnv("ALERT_WEBHOOK_URL", ""),
+		TaskQueuePath:     getEnv("TASK_QUEUE_PATH", "./data/task_queue.json"),
+
+		GatewayIPs: splitList(getEnv("GATEWAY_IPS", "")),
+
+		MinAgentProtocol: getEnvInt("MIN_AGENT_PROTOCOL", 1),
+		MaxTasksPerAgent: getEnvInt("MAX_TASKS_PER_AGENT", 4),
+
+		NodeID:              getEnv("NODE_ID", hostname()),
+		NodeURL:             getEnv("NODE_URL", ""),
+		BrokerRegistryURL:   getEnv("BROKER_REGISTRY_URL", ""),
+		BrokerSecret:        getEnv("BROKER_SECRET", ""),
+		ServeBrokerRegistry: getEnv("BROKER_SERVE_REGISTRY", "") == "true",
+
+		AdminToken: getEnv("ADMIN_API_TOKEN", ""),
+
+		BrowserOrigins: splitList(getEnv("BROWSER_ORIGINS", "")),
+
+		ShutdownGraceSec:  getEnvInt("SHUTDOWN_GRACE_SECONDS", 30),
+		ReconnectDelaySec: getEnvInt("AGENT_RECONNECT_DELAY_SECONDS", 5),
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return "local"
+	}
+	return h
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultVal
 	}
+	return n
 }
 
 func getEnv(key, defaultVal string) string {
@@ -1,8 +1,36 @@
 package models
 
+import "context"
+
 type ChatRequest struct {
-	Message   string `json:"message"`
-	UserToken string `json:"-"`
-	VPSID     string   `json:"vps_id,omitempty"`   
-    Args      []string `json:"args,omitempty"`
+	Message        string   `json:"message"`
+	UserToken      string   `json:"-"`
+	ClientIP       string   `json:"-"`
+	ConversationID string   `json:"conversation_id,omitempty"`
+	ConfirmationID string   `json:"confirmation_id,omitempty"`
+	Confirm        bool     `json:"confirm,omitempty"` // with ConfirmationID, applies it whatever the message says
+	VPSID          string   `json:"vps_id,omitempty"`
+	Args           []string `json:"args,omitempty"`
+
+	// CancelOnDisconnect cancels the tasks this request starts if the client
+	// goes away before they finish.
+	CancelOnDisconnect bool `json:"cancel_on_disconnect,omitempty"`
+
+	ctx context.Context
+}
+
+// Context is the context tasks started by this request run under;
+// background unless set with WithContext.
+func (r *ChatRequest) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *ChatRequest) WithContext(ctx context.Context) *ChatRequest {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
 }
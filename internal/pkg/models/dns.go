@@ -0,0 +1,18 @@
+package models
+
+type DNSRecord struct {
+	ID       FlexID `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Content  string `json:"content"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+type VPS struct {
+	ID          FlexID `json:"id"`
+	Hostname    string `json:"hostname"`
+	IPAddress   string `json:"ip_address"`
+	IPv6Address string `json:"ipv6_address,omitempty"`
+	Status      string `json:"status"`
+}
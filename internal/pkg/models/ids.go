@@ -0,0 +1,20 @@
+package models
+
+import "encoding/json"
+
+// FlexID accepts both numeric and string ids from the Nest API.
+type FlexID string
+
+func (id *FlexID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = FlexID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = FlexID(n.String())
+	return nil
+}
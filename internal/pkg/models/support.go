@@ -0,0 +1,34 @@
+package models
+
+import "strings"
+
+type Ticket struct {
+	ID        FlexID `json:"id"`
+	Subject   string `json:"subject"`
+	Status    string `json:"status"`
+	Priority  string `json:"priority"`
+	VPSID     string `json:"vps_id,omitempty"`
+	Escalated bool   `json:"escalated"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	LastReply string `json:"last_reply,omitempty"`
+}
+
+type CreateTicketRequest struct {
+	Subject    string `json:"subject"`
+	Message    string `json:"message"`
+	Priority   string `json:"priority"`
+	VPSID      string `json:"vps_id,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+	Escalated  bool   `json:"escalated"`
+	Source     string `json:"source"`
+}
+
+// IsOpen reports whether the ticket still needs attention.
+func (t Ticket) IsOpen() bool {
+	switch strings.ToLower(t.Status) {
+	case "closed", "resolved":
+		return false
+	}
+	return true
+}
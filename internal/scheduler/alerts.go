@@ -0,0 +1,202 @@
+// internal/scheduler/alerts.go
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ultahost-ai-gateway/internal/config"
+	"ultahost-ai-gateway/internal/tasks"
+)
+
+const maxNotificationsPerOwner = 200
+
+// metrics a threshold can watch, by the task that reports them
+var metricTasks = map[string]string{
+	"disk_used_percent":   "check_diskspace",
+	"memory_used_percent": "check_memory",
+	"swap_used_mb":        "check_memory",
+	"load_1":              "check_uptime",
+	"load_5":              "check_uptime",
+	"service_down":        "service_status",    // 1 when the unit isn't running
+	"cert_days_left":      "list_certificates", // of the certificate expiring soonest
+}
+
+// Threshold alerts when Metric compared with Value by Op holds
+type Threshold struct {
+	Metric string  `json:"metric"`
+	Op     string  `json:"op"` // >, >=, <, <=
+	Value  float64 `json:"value"`
+}
+
+func (t Threshold) key() string {
+	return fmt.Sprintf("%s%s%g", t.Metric, t.Op, t.Value)
+}
+
+func (t Threshold) validate(task string) error {
+	want, ok := metricTasks[t.Metric]
+	if !ok {
+		return fmt.Errorf("unknown metric %q", t.Metric)
+	}
+	if want != task {
+		return fmt.Errorf("metric %s is reported by %s, not %s", t.Metric, want, task)
+	}
+	switch t.Op {
+	case ">", ">=", "<", "<=":
+		return nil
+	}
+	return fmt.Errorf("op must be one of >, >=, <, <=")
+}
+
+func (t Threshold) breached(v float64) bool {
+	switch t.Op {
+	case ">":
+		return v > t.Value
+	case ">=":
+		return v >= t.Value
+	case "<":
+		return v < t.Value
+	case "<=":
+		return v <= t.Value
+	}
+	return false
+}
+
+// metricValues extracts the watchable metrics from a task's parsed output.
+func metricValues(data interface{}) map[string]float64 {
+	switch d := data.(type) {
+	case tasks.DiskUsage:
+		if fs, ok := d.Fullest(); ok {
+			return map[string]float64{"disk_used_percent": float64(fs.UsePercent)}
+		}
+	case tasks.Memory:
+		return map[string]float64{
+			"memory_used_percent": float64(d.UsedPercent()),
+			"swap_used_mb":        float64(d.SwapUsedMB),
+		}
+	case tasks.Uptime:
+		return map[string]float64{"load_1": d.Load1, "load_5": d.Load5}
+	case tasks.ServiceStatus:
+		down := 0.0
+		if !d.Running() {
+			down = 1
+		}
+		return map[string]float64{"service_down": down}
+	case tasks.CertificateList:
+		if len(d.Certificates) > 0 {
+			// the list is sorted by expiry
+			return map[string]float64{"cert_days_left": float64(d.Certificates[0].DaysLeft(time.Now()))}
+		}
+	}
+	return nil
+}
+
+// Notification is an alert raised or cleared by a scheduled run
+type Notification struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"-"`
+	ScheduleID string    `json:"schedule_id"`
+	VPSID      string    `json:"vps_id"`
+	Task       string    `json:"task"`
+	Metric     string    `json:"metric"`
+	Value      float64   `json:"value"`
+	Threshold  Threshold `json:"threshold"`
+	Resolved   bool      `json:"resolved"` // true when the metric went back under the threshold
+	Message    string    `json:"message"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+var notifications = map[string][]Notification{} // owner -> newest last
+
+// evaluate compares values with the schedule's thresholds. Only changes
+// notify: crossing a threshold raises an alert and crossing back resolves it,
+// so a disk that stays full alerts once rather than on every run.
+func evaluate(s *Schedule, values map[string]float64) []string {
+	if s.Breached == nil {
+		s.Breached = map[string]bool{}
+	}
+	var alerts []string
+	for _, th := range s.Thresholds {
+		v, ok := values[th.Metric]
+		if !ok {
+			continue
+		}
+		key := th.key()
+		now := th.breached(v)
+		if now {
+			alerts = append(alerts, fmt.Sprintf("%s is %g (threshold %s %g)", th.Metric, v, th.Op, th.Value))
+		}
+		if now == s.Breached[key] {
+			continue
+		}
+		s.Breached[key] = now
+
+		msg := fmt.Sprintf("VPS %s: %s is %g, threshold %s %g", s.VPSID, th.Metric, v, th.Op, th.Value)
+		if !now {
+			msg = fmt.Sprintf("VPS %s: %s is back to %g (threshold %s %g)", s.VPSID, th.Metric, v, th.Op, th.Value)
+		}
+		notify(Notification{
+			ID:         uuid.NewString(),
+			Owner:      s.Owner,
+			ScheduleID: s.ID,
+			VPSID:      s.VPSID,
+			Task:       s.Task,
+			Metric:     th.Metric,
+			Value:      v,
+			Threshold:  th,
+			Resolved:   !now,
+			Message:    msg,
+			CreatedAt:  time.Now().UTC(),
+		})
+	}
+	return alerts
+}
+
+func notify(n Notification) {
+	ns := append(notifications[n.Owner], n)
+	if len(ns) > maxNotificationsPerOwner {
+		ns = ns[len(ns)-maxNotificationsPerOwner:]
+	}
+	notifications[n.Owner] = ns
+	log.Printf("scheduler: %s", n.Message)
+
+	if config.AppConfig != nil && config.AppConfig.AlertWebhookURL != "" {
+		go postWebhook(config.AppConfig.AlertWebhookURL, n)
+	}
+}
+
+func postWebhook(url string, n Notification) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("scheduler: webhook for %s failed: %v", n.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("scheduler: webhook for %s returned %d", n.ID, resp.StatusCode)
+	}
+}
+
+// Notifications returns up to limit of the owner's notifications, newest first.
+func Notifications(owner string, limit int) []Notification {
+	mu.Lock()
+	defer mu.Unlock()
+
+	ns := notifications[owner]
+	out := make([]Notification, 0, limit)
+	for i := len(ns) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, ns[i])
+	}
+	return out
+}
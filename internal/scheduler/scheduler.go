@@ -0,0 +1,485 @@
+// internal/scheduler/scheduler.go
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ultahost-ai-gateway/internal/agents"
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+const (
+	tickInterval = 30 * time.Second
+	minInterval  = 5 * time.Minute
+
+	// a dispatched run with no result after the task's max timeout plus this
+	// grace is marked timed out
+	resultGrace = time.Minute
+
+	maxRunsPerSchedule   = 50
+	maxSchedulesPerOwner = 50
+	maxRunOutput         = 2048
+)
+
+const (
+	RunDispatched = "dispatched"
+	RunCompleted  = "completed"
+	RunFailed     = "failed"
+	RunTimedOut   = "timed_out"
+	RunMissed     = "missed"
+	RunCancelled  = "cancelled"
+)
+
+// Schedule runs a read-only task against a VPS at a fixed interval
+type Schedule struct {
+	ID         string      `json:"id"`
+	Owner      string      `json:"owner"`
+	VPSID      string      `json:"vps_id"`
+	Task       string      `json:"task"`
+	Args       []string    `json:"args,omitempty"`
+	Every      string      `json:"every"` // hourly, daily or a duration such as 15m
+	Thresholds []Threshold `json:"thresholds,omitempty"`
+	Enabled    bool        `json:"enabled"`
+	// QueueOffline queues a slot that finds the agent offline until the next
+	// slot, instead of recording it missed
+	QueueOffline bool            `json:"queue_offline,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	NextRun      time.Time       `json:"next_run"`
+	LastRun      time.Time       `json:"last_run,omitempty"`
+	Breached     map[string]bool `json:"breached,omitempty"` // threshold key -> currently breached
+}
+
+// Run is one firing of a schedule
+type Run struct {
+	ScheduleID string             `json:"schedule_id"`
+	TaskID     string             `json:"task_id,omitempty"`
+	Slot       time.Time          `json:"slot"` // the NextRun this run was fired for
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at,omitempty"`
+	Status     string             `json:"status"`
+	Reason     string             `json:"reason,omitempty"`
+	ExitCode   int                `json:"exit_code"`
+	Output     string             `json:"output,omitempty"`
+	Values     map[string]float64 `json:"values,omitempty"` // metric -> value
+	Alerts     []string           `json:"alerts,omitempty"`
+}
+
+var (
+	mu        sync.Mutex
+	schedules = map[string]*Schedule{}
+	runs      = map[string][]Run{}  // scheduleID -> runs, oldest first
+	taskIndex = map[string]string{} // taskID -> scheduleID, for dispatched runs
+
+	// results that arrive while tick sends, before their run is recorded
+	dispatching   int
+	finishedEarly = map[string]websocket.TaskRecord{}
+
+	startOnce sync.Once
+)
+
+// Start loads persisted schedules and starts the scheduler loop.
+func Start() {
+	startOnce.Do(func() {
+		mu.Lock()
+		if err := load(); err != nil {
+			log.Printf("scheduler: could not load schedules: %v", err)
+		}
+		mu.Unlock()
+
+		websocket.OnTaskFinished(handleTaskFinished)
+		go func() {
+			t := time.NewTicker(tickInterval)
+			defer t.Stop()
+			for now := range t.C {
+				tick(now.UTC())
+			}
+		}()
+	})
+}
+
+// parseEvery turns hourly, daily or a Go duration into an interval.
+func parseEvery(every string) (time.Duration, error) {
+	switch strings.ToLower(strings.TrimSpace(every)) {
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(every)
+	if err != nil {
+		return 0, fmt.Errorf("every must be hourly, daily, weekly or a duration such as 30m")
+	}
+	if d < minInterval {
+		return 0, fmt.Errorf("schedules can run at most every %s", minInterval)
+	}
+	return d, nil
+}
+
+// Create validates and stores a new schedule; the first run fires on the
+// next tick.
+func Create(owner, vpsId, task string, args []string, every string, thresholds []Threshold, queueOffline bool) (Schedule, error) {
+	spec, ok := tasks.Lookup(task)
+	if !ok {
+		return Schedule{}, fmt.Errorf("unknown task %q", task)
+	}
+	if spec.Risk != tasks.RiskReadOnly {
+		return Schedule{}, fmt.Errorf("only read-only tasks can be scheduled; %s is %s", task, spec.Risk)
+	}
+	if err := spec.ValidateArgs(args); err != nil {
+		return Schedule{}, err
+	}
+	if err := agents.Preflight(vpsId, task, args); err != nil {
+		return Schedule{}, err
+	}
+	if _, err := parseEvery(every); err != nil {
+		return Schedule{}, err
+	}
+	for _, th := range thresholds {
+		if err := th.validate(task); err != nil {
+			return Schedule{}, err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	n := 0
+	for _, s := range schedules {
+		if s.Owner == owner {
+			n++
+		}
+	}
+	if n >= maxSchedulesPerOwner {
+		return Schedule{}, fmt.Errorf("schedule limit of %d reached", maxSchedulesPerOwner)
+	}
+
+	now := time.Now().UTC()
+	s := &Schedule{
+		ID:           uuid.NewString(),
+		Owner:        owner,
+		VPSID:        vpsId,
+		Task:         task,
+		Args:         args,
+		Every:        every,
+		Thresholds:   thresholds,
+		Enabled:      true,
+		QueueOffline: queueOffline,
+		CreatedAt:    now,
+		NextRun:      now,
+	}
+	schedules[s.ID] = s
+	persist()
+	return *s, nil
+}
+
+// List returns the owner's schedules, oldest first.
+func List(owner string) []Schedule {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out := []Schedule{}
+	for _, s := range schedules {
+		if s.Owner == owner {
+			out = append(out, *s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Get returns the schedule if owner owns it.
+func Get(owner, id string) (Schedule, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := schedules[id]
+	if !ok || s.Owner != owner {
+		return Schedule{}, false
+	}
+	return *s, true
+}
+
+// SetEnabled pauses or resumes a schedule.
+func SetEnabled(owner, id string, enabled bool) (Schedule, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := schedules[id]
+	if !ok || s.Owner != owner {
+		return Schedule{}, false
+	}
+	s.Enabled = enabled
+	if enabled && s.NextRun.Before(time.Now()) {
+		s.NextRun = time.Now().UTC()
+	}
+	persist()
+	return *s, true
+}
+
+// Delete removes a schedule and its run history.
+func Delete(owner, id string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := schedules[id]
+	if !ok || s.Owner != owner {
+		return false
+	}
+	delete(schedules, id)
+	delete(runs, id)
+	for taskID, sid := range taskIndex {
+		if sid == id {
+			delete(taskIndex, taskID)
+		}
+	}
+	persist()
+	return true
+}
+
+// Runs returns up to limit runs of the schedule, newest first.
+func Runs(owner, id string, limit int) ([]Run, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, ok := schedules[id]
+	if !ok || s.Owner != owner {
+		return nil, false
+	}
+	rs := runs[id]
+	out := make([]Run, 0, limit)
+	for i := len(rs) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, rs[i])
+	}
+	return out, true
+}
+
+// dispatch functions; tests replace them
+var (
+	agentConnected = websocket.IsAgentConnected
+	sendTask       = websocket.SendSignedTask
+	queueTask      = websocket.QueueSignedTask
+)
+
+// dispatch is a due slot to send once mu is released
+type dispatch struct {
+	run      Run
+	vpsId    string
+	task     string
+	args     []string
+	queueTTL time.Duration // zero sends only to a connected agent
+}
+
+// tick fires the due schedules. Tasks are sent without holding mu: a send
+// that fails can finish the task synchronously, and handleTaskFinished
+// takes mu.
+func tick(now time.Time) {
+	mu.Lock()
+	changed := expireRuns(now)
+	var due []dispatch
+	for _, s := range schedules {
+		if !s.Enabled || s.NextRun.After(now) {
+			continue
+		}
+		if d, ok := fire(s, now); ok {
+			due = append(due, d)
+		}
+		changed = true
+	}
+	if changed {
+		persist()
+	}
+	dispatching += len(due)
+	mu.Unlock()
+
+	for i := range due {
+		d := &due[i]
+		var err error
+		if d.queueTTL > 0 {
+			d.run.TaskID, err = queueTask(d.vpsId, d.task, d.args, d.queueTTL)
+		} else {
+			d.run.TaskID, err = sendTask(d.vpsId, d.task, d.args)
+		}
+		if err != nil {
+			d.run.Status, d.run.Reason, d.run.FinishedAt = RunMissed, "dispatch failed: "+err.Error(), now
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, d := range due {
+		if s, ok := schedules[d.run.ScheduleID]; ok && d.run.Status == RunDispatched {
+			s.LastRun = now
+			taskIndex[d.run.TaskID] = s.ID
+		}
+		appendRun(d.run)
+		// the result may have come back before the run was recorded
+		if rec, ok := finishedEarly[d.run.TaskID]; ok && d.run.TaskID != "" {
+			finishRun(rec)
+		}
+	}
+	dispatching -= len(due)
+	if dispatching == 0 {
+		clear(finishedEarly)
+	}
+	persist()
+}
+
+// fire advances s past its due slot and returns the dispatch for it; a
+// slot that can't run is recorded missed. Slots missed while the gateway
+// was down are collapsed into this one rather than fired back to back.
+// Callers hold mu.
+func fire(s *Schedule, now time.Time) (dispatch, bool) {
+	interval, err := parseEvery(s.Every)
+	if err != nil {
+		s.Enabled = false
+		log.Printf("scheduler: disabling %s: %v", s.ID, err)
+		return dispatch{}, false
+	}
+	slot := s.NextRun
+	for !s.NextRun.After(now) {
+		s.NextRun = s.NextRun.Add(interval)
+	}
+
+	run := Run{ScheduleID: s.ID, Slot: slot, StartedAt: now}
+	switch {
+	case inFlight(s.ID):
+		run.Status, run.Reason = RunMissed, "previous run still in progress"
+	case !agentConnected(s.VPSID) && !s.QueueOffline:
+		run.Status, run.Reason = RunMissed, "agent offline"
+	default:
+		run.Status = RunDispatched
+		d := dispatch{run: run, vpsId: s.VPSID, task: s.Task, args: s.Args}
+		if s.QueueOffline {
+			d.queueTTL = min(interval, websocket.MaxQueueTTL)
+		}
+		return d, true
+	}
+	run.FinishedAt = now
+	appendRun(run)
+	return dispatch{}, false
+}
+
+func inFlight(scheduleID string) bool {
+	for _, sid := range taskIndex {
+		if sid == scheduleID {
+			return true
+		}
+	}
+	return false
+}
+
+func appendRun(r Run) {
+	rs := append(runs[r.ScheduleID], r)
+	if len(rs) > maxRunsPerSchedule {
+		rs = rs[len(rs)-maxRunsPerSchedule:]
+	}
+	runs[r.ScheduleID] = rs
+}
+
+func findRun(scheduleID, taskID string) *Run {
+	rs := runs[scheduleID]
+	for i := len(rs) - 1; i >= 0; i-- {
+		if rs[i].TaskID == taskID {
+			return &rs[i]
+		}
+	}
+	return nil
+}
+
+// expireRuns times out dispatched runs whose result never arrived.
+func expireRuns(now time.Time) bool {
+	changed := false
+	for taskID, sid := range taskIndex {
+		s, ok := schedules[sid]
+		r := findRun(sid, taskID)
+		if !ok || r == nil {
+			delete(taskIndex, taskID)
+			continue
+		}
+		// a queued or waiting run's timeout starts when it is sent
+		sent := r.StartedAt
+		if rec, ok := websocket.GetTask(taskID); ok {
+			if rec.Status == websocket.TaskStatusQueued || rec.Status == websocket.TaskStatusWaiting {
+				continue
+			}
+			sent = rec.SentAt
+		}
+		if now.Sub(sent) > tasks.Timeout(s.Task, 0)+resultGrace {
+			r.Status, r.Reason, r.FinishedAt = RunTimedOut, "no result from agent", now
+			delete(taskIndex, taskID)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// handleTaskFinished completes the run for a scheduled task and evaluates
+// its thresholds. Results for other tasks are ignored.
+func handleTaskFinished(rec websocket.TaskRecord) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := taskIndex[rec.TaskID]; !ok && dispatching > 0 {
+		finishedEarly[rec.TaskID] = rec
+		return
+	}
+	finishRun(rec)
+}
+
+// finishRun records rec on its run. Callers hold mu.
+func finishRun(rec websocket.TaskRecord) {
+	sid, ok := taskIndex[rec.TaskID]
+	if !ok {
+		return
+	}
+	delete(taskIndex, rec.TaskID)
+	s, ok := schedules[sid]
+	r := findRun(sid, rec.TaskID)
+	if !ok || r == nil {
+		return
+	}
+
+	r.FinishedAt = rec.FinishedAt
+	switch rec.Status {
+	case websocket.TaskStatusCompleted:
+		r.Status = RunCompleted
+	case websocket.TaskStatusTimedOut:
+		r.Status = RunTimedOut
+	case websocket.TaskStatusCancelled:
+		r.Status = RunCancelled
+	case websocket.TaskStatusExpired:
+		r.Status, r.Reason = RunMissed, "agent offline; "+rec.Reason
+	default:
+		r.Status, r.Reason = RunFailed, rec.Reason
+	}
+	if rec.Result != nil {
+		r.ExitCode = rec.Result.ExitCode
+		out := rec.Result.Stdout
+		if r.Status != RunCompleted {
+			out = rec.Result.Stderr
+		}
+		if len(out) > maxRunOutput {
+			out = out[:maxRunOutput]
+		}
+		r.Output = out
+	}
+	// parsed output counts whatever the exit code: systemctl status exits 3
+	// for a stopped unit, which is exactly what service_down watches
+	if rec.Data != nil {
+		r.Values = metricValues(rec.Data)
+		r.Alerts = evaluate(s, r.Values)
+	}
+	persist()
+}
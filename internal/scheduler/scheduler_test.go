@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/websocket"
+)
+
+// reset clears the scheduler state and restores the dispatch functions
+// after the test.
+func reset(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	schedules = map[string]*Schedule{}
+	runs = map[string][]Run{}
+	taskIndex = map[string]string{}
+	notifications = map[string][]Notification{}
+	dispatching = 0
+	clear(finishedEarly)
+	mu.Unlock()
+
+	connected, send, queue := agentConnected, sendTask, queueTask
+	agentConnected = func(string) bool { return true }
+	t.Cleanup(func() { agentConnected, sendTask, queueTask = connected, send, queue })
+}
+
+func addSchedule(task string, thresholds ...Threshold) *Schedule {
+	s := &Schedule{ID: "s1", Owner: "o1", VPSID: "v1", Task: task, Every: "hourly", Enabled: true, Thresholds: thresholds, NextRun: time.Now().UTC().Add(-time.Minute)}
+	mu.Lock()
+	schedules[s.ID] = s
+	mu.Unlock()
+	return s
+}
+
+func lastRun(t *testing.T, sid string) Run {
+	t.Helper()
+	mu.Lock()
+	defer mu.Unlock()
+	rs := runs[sid]
+	if len(rs) == 0 {
+		t.Fatal("no runs recorded")
+	}
+	return rs[len(rs)-1]
+}
+
+// tickWithin fails the test if tick doesn't return, e.g. on a deadlock.
+func tickWithin(t *testing.T, now time.Time) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		tick(now)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("tick did not return")
+	}
+}
+
+func TestTickSendFailure(t *testing.T) {
+	reset(t)
+	addSchedule("check_uptime")
+	// a failed send finishes its task synchronously, as SendSignedTask does
+	sendTask = func(vpsId, task string, args []string) (string, error) {
+		handleTaskFinished(websocket.TaskRecord{TaskID: "t1", VPSID: vpsId, Task: task, Status: websocket.TaskStatusFailed})
+		return "", errors.New("agent connection closed")
+	}
+
+	tickWithin(t, time.Now().UTC())
+
+	r := lastRun(t, "s1")
+	if r.Status != RunMissed || r.Reason != "dispatch failed: agent connection closed" {
+		t.Errorf("run = %s (%s), want missed with the send error", r.Status, r.Reason)
+	}
+	if inFlight("s1") {
+		t.Error("failed run is still in flight")
+	}
+}
+
+func TestTickResultBeforeRunRecorded(t *testing.T) {
+	reset(t)
+	addSchedule("check_uptime")
+	sendTask = func(vpsId, task string, args []string) (string, error) {
+		handleTaskFinished(websocket.TaskRecord{
+			TaskID: "t1", VPSID: vpsId, Task: task, Status: websocket.TaskStatusCompleted,
+			Data: tasks.Uptime{Load1: 0.5, Load5: 0.25},
+		})
+		return "t1", nil
+	}
+
+	tickWithin(t, time.Now().UTC())
+
+	r := lastRun(t, "s1")
+	if r.Status != RunCompleted || r.Values["load_1"] != 0.5 {
+		t.Errorf("run = %+v, want completed with load_1 0.5", r)
+	}
+	if inFlight("s1") || len(finishedEarly) != 0 {
+		t.Error("early result left behind")
+	}
+}
+
+func TestServiceDownThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		exitCode  int
+		status    string
+		unit      tasks.ServiceStatus
+		wantRun   string
+		wantAlert bool
+	}{
+		{
+			name:      "inactive unit exits 3",
+			exitCode:  3,
+			status:    websocket.TaskStatusFailed,
+			unit:      tasks.ServiceStatus{Unit: "nginx", LoadState: "loaded", ActiveState: "inactive", SubState: "dead"},
+			wantRun:   RunFailed,
+			wantAlert: true,
+		},
+		{
+			name:      "running unit",
+			status:    websocket.TaskStatusCompleted,
+			unit:      tasks.ServiceStatus{Unit: "nginx", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+			wantRun:   RunCompleted,
+			wantAlert: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset(t)
+			s := addSchedule("service_status", Threshold{Metric: "service_down", Op: ">=", Value: 1})
+			mu.Lock()
+			taskIndex["t1"] = s.ID
+			appendRun(Run{ScheduleID: s.ID, TaskID: "t1", Status: RunDispatched})
+			mu.Unlock()
+
+			handleTaskFinished(websocket.TaskRecord{
+				TaskID: "t1", VPSID: "v1", Task: "service_status", Status: tt.status,
+				Result: &websocket.TaskResult{TaskID: "t1", ExitCode: tt.exitCode},
+				Data:   tt.unit,
+			})
+
+			r := lastRun(t, s.ID)
+			if r.Status != tt.wantRun {
+				t.Errorf("status = %s, want %s", r.Status, tt.wantRun)
+			}
+			if got := len(r.Alerts) > 0; got != tt.wantAlert {
+				t.Errorf("alerts = %v, want alert %v", r.Alerts, tt.wantAlert)
+			}
+			if got := len(Notifications("o1", 10)) > 0; got != tt.wantAlert {
+				t.Errorf("notified = %v, want %v", got, tt.wantAlert)
+			}
+		})
+	}
+}
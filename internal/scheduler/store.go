@@ -0,0 +1,92 @@
+// internal/scheduler/store.go
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"ultahost-ai-gateway/internal/config"
+)
+
+// snapshot is the on-disk form of the scheduler state
+type snapshot struct {
+	Schedules     map[string]*Schedule      `json:"schedules"`
+	Runs          map[string][]Run          `json:"runs"`
+	Notifications map[string][]Notification `json:"notifications"`
+}
+
+func storePath() string {
+	if config.AppConfig == nil {
+		return ""
+	}
+	return config.AppConfig.ScheduleStorePath
+}
+
+// load replaces the in-memory state with the stored one. Runs that were
+// dispatched when the gateway stopped can't receive their result any more
+// and are marked timed out. Callers hold mu.
+func load() error {
+	path := storePath()
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return err
+	}
+
+	if snap.Schedules != nil {
+		schedules = snap.Schedules
+	}
+	if snap.Runs != nil {
+		runs = snap.Runs
+	}
+	if snap.Notifications != nil {
+		notifications = snap.Notifications
+	}
+	for sid, rs := range runs {
+		for i := range rs {
+			if rs[i].Status == RunDispatched {
+				rs[i].Status, rs[i].Reason = RunTimedOut, "gateway restarted before the result arrived"
+			}
+		}
+		runs[sid] = rs
+	}
+	return nil
+}
+
+// persist writes the state atomically; failures are logged and the
+// in-memory state stays authoritative. Callers hold mu.
+func persist() {
+	path := storePath()
+	if path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(snapshot{Schedules: schedules, Runs: runs, Notifications: notifications}, "", "  ")
+	if err != nil {
+		log.Printf("scheduler: encode state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		log.Printf("scheduler: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Printf("scheduler: write state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("scheduler: write state: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"ultahost-ai-gateway/internal/api"
+	"ultahost-ai-gateway/internal/config"
 	"ultahost-ai-gateway/internal/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -13,9 +14,42 @@ func RegisterRoutes(r *gin.Engine) {
 	// r.POST("/agent/send-command", api.HandleSendCommand)
 	// r.POST("/agent/register", api.HandleAgentRegister)
 	r.POST("/agent/register", api.InstallTokenMiddleware(), api.HandleAgentRegister)
+
+	// node-to-node; authenticated with the broker secret
+	r.POST("/internal/broker/dispatch", websocket.HandleBrokerDispatch)
+	r.POST("/internal/drain", websocket.HandleDrain)
+	if config.AppConfig != nil && config.AppConfig.ServeBrokerRegistry {
+		websocket.NewRegistryServer(config.AppConfig.BrokerSecret).Register(r)
+	}
+
+	// operators
+	admin := r.Group("/admin", api.AdminMiddleware())
+	admin.GET("/agents", api.HandleListAgents)
+	admin.GET("/agents/:vps", api.HandleAgentDetail)
+	admin.GET("/agents/:vps/audit", api.HandleAgentAudit)
+
+	// browsers can't authenticate websockets with a header; this takes a
+	// token from the ws-token route below
+	r.GET("/vps/:id/tasks/:task_id/ws", api.HandleTaskEvents)
+
 	r.Use(api.AuthMiddleware())
 
 	r.POST("/chat", api.HandleChat)
 	r.POST("/agent/enable", api.HandleEnableUltaAI)
+	r.GET("/apps", api.HandleListApps)
+	r.GET("/vps/:id/security-audit", api.HandleSecurityAudit)
+	r.GET("/vps/:id/backups", api.HandleListBackups)
+	r.GET("/vps/:id/agent", api.HandleVPSAgentStatus)
+	r.GET("/vps/:id/credentials/:credential_id", api.HandleTakeCredential)
+	r.POST("/vps/:id/tasks/:task_id/cancel", api.HandleCancelTask)
+	r.GET("/vps/:id/tasks/:task_id/stream", api.HandleTaskStream)
+	r.POST("/vps/:id/tasks/:task_id/ws-token", api.HandleTaskEventsToken)
+
+	r.POST("/schedules", api.HandleCreateSchedule)
+	r.GET("/schedules", api.HandleListSchedules)
+	r.PATCH("/schedules/:id", api.HandleUpdateSchedule)
+	r.DELETE("/schedules/:id", api.HandleDeleteSchedule)
+	r.GET("/schedules/:id/runs", api.HandleScheduleRuns)
+	r.GET("/notifications", api.HandleNotifications)
 
 }
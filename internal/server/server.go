@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"ultahost-ai-gateway/internal/config"
 
 	"github.com/gin-gonic/gin"
@@ -9,14 +11,21 @@ import (
 
 type Server struct {
 	Engine *gin.Engine
+	http   *http.Server
 }
 
 func NewServer() *Server {
 	r := gin.Default()
-	return &Server{Engine: r}
+	addr := fmt.Sprintf(":%s", config.AppConfig.Port)
+	return &Server{Engine: r, http: &http.Server{Addr: addr, Handler: r}}
 }
 
+// Start serves until Shutdown, when it returns http.ErrServerClosed.
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%s", config.AppConfig.Port)
-	return s.Engine.Run(addr)
+	return s.http.ListenAndServe()
+}
+
+// Shutdown stops accepting requests and waits for active ones until ctx ends.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
 }
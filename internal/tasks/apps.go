@@ -0,0 +1,239 @@
+// internal/tasks/apps.go
+package tasks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AppParam is an installer parameter, passed to the agent as a positional arg
+type AppParam struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Required    bool     `json:"required"`
+	Default     string   `json:"default,omitempty"`
+}
+
+// PostCheck verifies an install; exactly one of Port, Service or VersionOf is set
+type PostCheck struct {
+	Name      string `json:"name"`
+	Port      int    `json:"port,omitempty"`       // something must listen here
+	Service   string `json:"service,omitempty"`    // unit must be active
+	VersionOf string `json:"version_of,omitempty"` // check_app_version must succeed
+}
+
+// App is an installable application or stack
+type App struct {
+	ID                string        `json:"id"`
+	Name              string        `json:"name"`
+	Description       string        `json:"description"`
+	Task              string        `json:"task"`
+	Params            []AppParam    `json:"params,omitempty"`
+	OSFamilies        []string      `json:"os_families"`
+	MinRAMMB          int64         `json:"min_ram_mb"`
+	MinDiskMB         int64         `json:"min_disk_mb"`
+	EstimatedDuration time.Duration `json:"estimated_duration"`
+	PostChecks        []PostCheck   `json:"post_checks,omitempty"`
+}
+
+var apps = map[string]App{}
+
+// registerApp adds the app and, unless it reuses an existing task, registers
+// an install_<id> task whose args schema mirrors the app params.
+func registerApp(a App, spec bool) {
+	if a.Task == "" {
+		a.Task = "install_" + a.ID
+	}
+	if spec {
+		Register(Spec{
+			Name:           a.Task,
+			Description:    "install " + a.Name + ": " + a.Description,
+			ArgsSchema:     a.argsSchema(),
+			DefaultTimeout: a.EstimatedDuration * 2,
+			MaxTimeout:     a.EstimatedDuration * 4,
+			Risk:           RiskHigh,
+			Confirm:        true,
+			Exclusive:      ExclusivePackages,
+		})
+	}
+	apps[a.ID] = a
+}
+
+func (a App) argsSchema() []byte {
+	if len(a.Params) == 0 {
+		return nil
+	}
+	items := make([]string, 0, len(a.Params))
+	required := 0
+	for i, p := range a.Params {
+		item := fmt.Sprintf(`{"title": %s, "description": %s, "type": "string"`, jsonString(p.Name), jsonString(p.Description))
+		if p.Pattern != "" {
+			item += `, "pattern": ` + jsonString(p.Pattern)
+		}
+		if len(p.Enum) > 0 {
+			item += `, "enum": ` + jsonValue(p.Enum)
+		}
+		items = append(items, item+"}")
+		if p.Required {
+			required = i + 1
+		}
+	}
+	return schemaJSON(fmt.Sprintf(`{"type": "array", "prefixItems": [%s], "minItems": %d, "maxItems": %d}`,
+		strings.Join(items, ", "), required, len(a.Params)))
+}
+
+// Args fills defaults for missing trailing params.
+func (a App) Args(given []string) []string {
+	args := append([]string(nil), given...)
+	for i := len(args); i < len(a.Params); i++ {
+		if a.Params[i].Default == "" {
+			break
+		}
+		args = append(args, a.Params[i].Default)
+	}
+	return args
+}
+
+// LookupApp returns the app by id or by its install task name.
+func LookupApp(idOrTask string) (App, bool) {
+	if a, ok := apps[idOrTask]; ok {
+		return a, true
+	}
+	for _, a := range apps {
+		if a.Task == idOrTask {
+			return a, true
+		}
+	}
+	return App{}, false
+}
+
+// Apps returns the catalog sorted by id.
+func Apps() []App {
+	out := make([]App, 0, len(apps))
+	for _, a := range apps {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+var phpVersions = []string{"8.1", "8.2", "8.3"}
+
+func init() {
+	Register(Spec{
+		Name:        "check_app_version",
+		Description: "report the installed version of an application such as node, docker or composer",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "app", "description": "application binary", "type": "string", "enum": ["php", "mysql", "node", "npm", "docker", "composer", "ghost", "nginx", "apache2", "httpd"]}
+			],
+			"minItems": 1,
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+	})
+
+	domainParam := AppParam{Name: "domain", Description: "domain name the site will be served on", Pattern: patternDomain, Required: true}
+	phpParam := AppParam{Name: "php_version", Description: "PHP version", Enum: phpVersions, Default: "8.2"}
+	webChecks := []PostCheck{{Name: "web server listening on port 80", Port: 80}}
+	dbCheck := PostCheck{Name: "database listening on port 3306", Port: 3306}
+
+	registerApp(App{
+		ID:                "wordpress",
+		Name:              "WordPress",
+		Description:       "WordPress with Apache, PHP and MySQL",
+		Task:              "install_wordpress",
+		Params:            []AppParam{{Name: "domain", Description: domainParam.Description, Pattern: patternDomain}},
+		OSFamilies:        []string{"debian", "rhel"},
+		MinRAMMB:          1024,
+		MinDiskMB:         3072,
+		EstimatedDuration: 8 * time.Minute,
+		PostChecks:        append(webChecks, dbCheck),
+	}, false)
+	registerApp(App{
+		ID:                "lamp",
+		Name:              "LAMP stack",
+		Description:       "Apache, MariaDB/MySQL and PHP",
+		Params:            []AppParam{phpParam},
+		OSFamilies:        []string{"debian", "rhel"},
+		MinRAMMB:          512,
+		MinDiskMB:         2048,
+		EstimatedDuration: 5 * time.Minute,
+		PostChecks:        append(append(webChecks, dbCheck), PostCheck{Name: "PHP installed", VersionOf: "php"}),
+	}, true)
+	registerApp(App{
+		ID:                "lemp",
+		Name:              "LEMP stack",
+		Description:       "Nginx, MariaDB/MySQL and PHP-FPM",
+		Params:            []AppParam{phpParam},
+		OSFamilies:        []string{"debian", "rhel"},
+		MinRAMMB:          512,
+		MinDiskMB:         2048,
+		EstimatedDuration: 5 * time.Minute,
+		PostChecks:        append(append(webChecks, dbCheck), PostCheck{Name: "PHP installed", VersionOf: "php"}),
+	}, true)
+	registerApp(App{
+		ID:                "nodejs",
+		Name:              "Node.js",
+		Description:       "Node.js LTS runtime with npm",
+		Params:            []AppParam{{Name: "node_version", Description: "Node.js major version", Enum: []string{"18", "20", "22"}, Default: "20"}},
+		OSFamilies:        []string{"debian", "rhel"},
+		MinRAMMB:          512,
+		MinDiskMB:         1024,
+		EstimatedDuration: 3 * time.Minute,
+		PostChecks:        []PostCheck{{Name: "node installed", VersionOf: "node"}, {Name: "npm installed", VersionOf: "npm"}},
+	}, true)
+	registerApp(App{
+		ID:                "docker",
+		Name:              "Docker Engine",
+		Description:       "Docker Engine, CLI and compose plugin",
+		OSFamilies:        []string{"debian", "rhel"},
+		MinRAMMB:          1024,
+		MinDiskMB:         5120,
+		EstimatedDuration: 4 * time.Minute,
+		PostChecks:        []PostCheck{{Name: "docker running", Service: "docker"}, {Name: "docker CLI installed", VersionOf: "docker"}},
+	}, true)
+	registerApp(App{
+		ID:          "nextcloud",
+		Name:        "Nextcloud",
+		Description: "Nextcloud file sync and share server",
+		Params: []AppParam{
+			domainParam,
+			{Name: "admin_user", Description: "Nextcloud admin username", Pattern: `^[a-z][a-z0-9_]{2,31}$`, Default: "admin"},
+		},
+		OSFamilies:        []string{"debian"},
+		MinRAMMB:          2048,
+		MinDiskMB:         10240,
+		EstimatedDuration: 10 * time.Minute,
+		PostChecks:        append(webChecks, dbCheck),
+	}, true)
+	registerApp(App{
+		ID:                "ghost",
+		Name:              "Ghost",
+		Description:       "Ghost publishing platform behind Nginx",
+		Params:            []AppParam{domainParam},
+		OSFamilies:        []string{"debian"},
+		MinRAMMB:          1024,
+		MinDiskMB:         3072,
+		EstimatedDuration: 10 * time.Minute,
+		PostChecks:        append(webChecks, PostCheck{Name: "ghost CLI installed", VersionOf: "ghost"}),
+	}, true)
+	registerApp(App{
+		ID:                "laravel_prereqs",
+		Name:              "Laravel prerequisites",
+		Description:       "PHP with the extensions Laravel needs, Composer and Nginx",
+		Params:            []AppParam{phpParam},
+		OSFamilies:        []string{"debian", "rhel"},
+		MinRAMMB:          1024,
+		MinDiskMB:         2048,
+		EstimatedDuration: 5 * time.Minute,
+		PostChecks:        append(webChecks, PostCheck{Name: "PHP installed", VersionOf: "php"}, PostCheck{Name: "Composer installed", VersionOf: "composer"}),
+	}, true)
+}
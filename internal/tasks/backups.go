@@ -0,0 +1,230 @@
+// internal/tasks/backups.go
+package tasks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backup ids are the UTC creation time plus an optional label, e.g. 20261019T014500Z-pre-update
+const patternBackupID = `^[0-9]{8}T[0-9]{6}Z(-[a-z0-9][a-z0-9-]{0,31})?$`
+
+const patternBackupLabel = `^[a-z0-9][a-z0-9-]{0,31}$`
+
+var (
+	backupIDRe = regexp.MustCompile(patternBackupID)
+	sha256Re   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+func init() {
+	sitePath := `{"title": "site_path", "description": "absolute path of the WordPress site, e.g. /var/www/html", "type": "string", "pattern": ` + jsonString(patternAbsPath) + `}`
+
+	Register(Spec{
+		Name:        "backup_site",
+		Description: "back up a WordPress site on the server: its files and a dump of its database",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + sitePath + `,
+				{"title": "label", "description": "optional short label such as pre-update", "type": "string", "pattern": ` + jsonString(patternBackupLabel) + `}
+			],
+			"minItems": 1,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 10 * time.Minute,
+		MaxTimeout:     30 * time.Minute,
+		Risk:           RiskLow,
+		Parse:          ParseBackupManifest,
+		Exclusive:      ExclusiveBackups,
+	})
+	Register(Spec{
+		Name:        "list_backups",
+		Description: "list the backups stored on the server with their size and date",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [` + sitePath + `],
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseBackupList,
+	})
+	Register(Spec{
+		Name:        "prune_backups",
+		Description: "delete old backups of a site, keeping the newest ones and any younger than a number of days",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + sitePath + `,
+				{"title": "keep_last", "description": "number of newest backups to keep", "type": "integer", "minimum": 1, "maximum": 100},
+				{"title": "keep_days", "description": "also keep backups younger than this many days", "type": "integer", "minimum": 0, "maximum": 365}
+			],
+			"minItems": 2,
+			"maxItems": 3
+		}`),
+		DefaultTimeout: 2 * time.Minute,
+		MaxTimeout:     10 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Parse:          ParsePruneResult,
+		Exclusive:      ExclusiveBackups,
+	})
+	Register(Spec{
+		Name:        "restore_backup",
+		Description: "restore a WordPress site's files and database from one of its backups",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + sitePath + `,
+				{"title": "backup_id", "description": "id of the backup to restore, e.g. 20261019T014500Z", "type": "string", "pattern": ` + jsonString(patternBackupID) + `}
+			],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 15 * time.Minute,
+		MaxTimeout:     30 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Parse:          ParseRestoreResult,
+		Exclusive:      ExclusiveBackups,
+	})
+}
+
+// BackupFile is one artifact of a backup
+type BackupFile struct {
+	Path      string `json:"path"`
+	Kind      string `json:"kind"` // files, database
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+// BackupManifest describes a backup as written by the agent
+type BackupManifest struct {
+	ID        string       `json:"id"`
+	SitePath  string       `json:"site_path"`
+	Database  string       `json:"database,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	SizeBytes int64        `json:"size_bytes"`
+	Files     []BackupFile `json:"files"`
+}
+
+func (m BackupManifest) validate() error {
+	if !backupIDRe.MatchString(m.ID) {
+		return fmt.Errorf("invalid backup id %q", m.ID)
+	}
+	if len(m.Files) == 0 {
+		return fmt.Errorf("backup %s has no files", m.ID)
+	}
+	for _, f := range m.Files {
+		if !strings.HasPrefix(f.Path, "/") || !sha256Re.MatchString(f.SHA256) {
+			return fmt.Errorf("backup %s: bad file entry %q", m.ID, f.Path)
+		}
+	}
+	return nil
+}
+
+// Total is the manifest size, summing the files when the agent didn't.
+func (m BackupManifest) Total() int64 {
+	if m.SizeBytes > 0 {
+		return m.SizeBytes
+	}
+	var n int64
+	for _, f := range m.Files {
+		n += f.SizeBytes
+	}
+	return n
+}
+
+func ParseBackupManifest(_ []string, out string) (interface{}, error) {
+	var m BackupManifest
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &m); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BackupList is the parsed output of list_backups, newest first
+type BackupList struct {
+	Backups []BackupManifest `json:"backups"`
+}
+
+// ParseBackupList accepts a JSON array of manifests or one manifest per line.
+func ParseBackupList(_ []string, out string) (interface{}, error) {
+	var list BackupList
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "[") {
+		if err := json.Unmarshal([]byte(out), &list.Backups); err != nil {
+			return nil, fmt.Errorf("invalid backup list: %w", err)
+		}
+	} else {
+		sc := bufio.NewScanner(strings.NewReader(out))
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			var m BackupManifest
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				return nil, fmt.Errorf("invalid backup list line: %w", err)
+			}
+			list.Backups = append(list.Backups, m)
+		}
+	}
+	for _, m := range list.Backups {
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if list.Backups == nil {
+		list.Backups = []BackupManifest{}
+	}
+	// ids sort by creation time
+	sort.Slice(list.Backups, func(i, j int) bool { return list.Backups[i].ID > list.Backups[j].ID })
+	return list, nil
+}
+
+// PruneResult is the parsed output of prune_backups
+type PruneResult struct {
+	Removed    []string `json:"removed"`
+	Kept       []string `json:"kept"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+func ParsePruneResult(_ []string, out string) (interface{}, error) {
+	var r PruneResult
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &r); err != nil {
+		return nil, fmt.Errorf("invalid prune result: %w", err)
+	}
+	return r, nil
+}
+
+// RestoreResult is the parsed output of restore_backup
+type RestoreResult struct {
+	BackupID         string `json:"backup_id"`
+	SitePath         string `json:"site_path"`
+	ChecksumVerified bool   `json:"checksum_verified"`
+	FilesRestored    bool   `json:"files_restored"`
+	DatabaseRestored bool   `json:"database_restored"`
+	SafetyBackupID   string `json:"safety_backup_id,omitempty"` // taken just before restoring
+}
+
+func ParseRestoreResult(_ []string, out string) (interface{}, error) {
+	var r RestoreResult
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &r); err != nil {
+		return nil, fmt.Errorf("invalid restore result: %w", err)
+	}
+	if r.BackupID == "" {
+		return nil, fmt.Errorf("restore result missing backup_id")
+	}
+	return r, nil
+}
@@ -0,0 +1,81 @@
+// internal/tasks/builtin.go
+package tasks
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// argument patterns shared by task schemas
+const (
+	patternDomain = `^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`
+	// absolute path whose segments never start with a dot, so ".." can't appear
+	patternAbsPath = `^(/|(/[A-Za-z0-9_-][A-Za-z0-9._-]*)+/?)$`
+)
+
+func init() {
+	Register(Spec{
+		Name:           "check_uptime",
+		Description:    "show how long the server has been running and its load average",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseUptime,
+	})
+
+	Register(Spec{
+		Name:        "check_diskspace",
+		Description: "show disk usage of the server's filesystems",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "mount_point", "description": "filesystem mount point to check, e.g. /var", "type": "string", "pattern": ` + jsonString(patternAbsPath) + `}
+			],
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseDF,
+	})
+
+	Register(Spec{
+		Name:           "check_memory",
+		Description:    "show memory and swap usage of the server",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseFree,
+	})
+
+	Register(Spec{
+		Name:        "install_wordpress",
+		Description: "install WordPress with Apache, PHP and MySQL on the server",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "domain", "description": "domain name the site will be served on", "type": "string", "pattern": ` + jsonString(patternDomain) + `}
+			],
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 10 * time.Minute,
+		MaxTimeout:     20 * time.Minute,
+		Risk:           RiskHigh,
+		Exclusive:      ExclusivePackages,
+	})
+}
+
+// schemaJSON keeps schema literals readable at the call site
+func schemaJSON(s string) json.RawMessage {
+	return json.RawMessage(s)
+}
+
+// jsonString quotes a Go string (e.g. a regex pattern) for use inside a schema literal
+func jsonString(s string) string {
+	return jsonValue(s)
+}
+
+func jsonValue(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,222 @@
+// internal/tasks/catalog.go
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Risk describes what a task can do to the VPS
+type Risk string
+
+const (
+	RiskReadOnly Risk = "read_only" // only inspects state
+	RiskLow      Risk = "low"       // changes state in an easily reversible way
+	RiskHigh     Risk = "high"      // destructive, disruptive or long-running
+)
+
+// conflict groups for Spec.Exclusive
+const (
+	ExclusivePackages = "packages" // apt/dnf hold a lock for the whole install
+	ExclusiveFirewall = "firewall" // each change arms its own rollback
+	ExclusiveBackups  = "backups"  // backups, restores and pruning of the same store
+)
+
+// Spec declares an allowlisted agent task
+type Spec struct {
+	Name           string               `json:"name"`
+	Description    string               `json:"description"`
+	ArgsSchema     json.RawMessage      `json:"args_schema"` // JSON schema for the positional args array
+	DefaultTimeout time.Duration        `json:"default_timeout"`
+	MaxTimeout     time.Duration        `json:"max_timeout"`
+	Risk           Risk                 `json:"risk"`
+	Capability     string               `json:"capability"` // what the agent must announce in its hello to run the task; defaults to Name
+	Confirm        bool                 `json:"confirm"`    // ask the user before dispatching
+	Parse          Parser               `json:"-"`          // optional structured output parser
+	Check          func([]string) error `json:"-"`          // optional checks the schema can't express
+	// MaxConcurrent caps how many copies of the task one agent runs at once;
+	// zero leaves only the per-agent limit
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// Exclusive names a conflict group: tasks sharing it never run at the
+	// same time on one VPS (two package installs, two firewall changes)
+	Exclusive string `json:"exclusive,omitempty"`
+	// Unmetered tasks start at once, outside the per-agent limit: short
+	// follow-ups that must not wait behind other tasks, like the commit
+	// that stops a firewall change rolling back
+	Unmetered bool `json:"unmetered,omitempty"`
+
+	schema *Schema
+}
+
+const (
+	defaultTaskTimeout = 2 * time.Minute
+	defaultMaxTimeout  = 15 * time.Minute
+)
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = map[string]*Spec{}
+)
+
+// Register adds a task to the catalog. It panics on invalid specs since the
+// catalog is declared in code.
+func Register(s Spec) {
+	if s.Name == "" {
+		panic("tasks: spec without name")
+	}
+	schema, err := parseSchema(s.ArgsSchema)
+	if err != nil {
+		panic(fmt.Sprintf("tasks: invalid args schema for %s: %v", s.Name, err))
+	}
+	s.schema = schema
+	if s.DefaultTimeout == 0 {
+		s.DefaultTimeout = defaultTaskTimeout
+	}
+	if s.MaxTimeout == 0 {
+		s.MaxTimeout = defaultMaxTimeout
+	}
+	if s.MaxTimeout < s.DefaultTimeout {
+		s.MaxTimeout = s.DefaultTimeout
+	}
+	if s.Risk == "" {
+		s.Risk = RiskReadOnly
+	}
+	if s.Capability == "" {
+		s.Capability = s.Name
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if _, dup := catalog[s.Name]; dup {
+		panic("tasks: duplicate spec " + s.Name)
+	}
+	catalog[s.Name] = &s
+}
+
+// Lookup returns the spec for an exact task name.
+func Lookup(name string) (Spec, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	s, ok := catalog[name]
+	if !ok {
+		return Spec{}, false
+	}
+	return *s, true
+}
+
+// Resolve maps a loosely formatted name ("checkDiskSpace", "check-diskspace")
+// to a catalog entry.
+func Resolve(name string) (Spec, bool) {
+	if s, ok := Lookup(name); ok {
+		return s, true
+	}
+	want := looseName(name)
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for n, s := range catalog {
+		if looseName(n) == want {
+			return *s, true
+		}
+	}
+	return Spec{}, false
+}
+
+func looseName(name string) string {
+	r := strings.NewReplacer("_", "", "-", "", " ", "")
+	return strings.ToLower(r.Replace(strings.TrimSpace(name)))
+}
+
+// All returns every spec sorted by name.
+func All() []Spec {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	out := make([]Spec, 0, len(catalog))
+	for _, s := range catalog {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Names returns every task name sorted.
+func Names() []string {
+	specs := All()
+	out := make([]string, len(specs))
+	for i, s := range specs {
+		out[i] = s.Name
+	}
+	return out
+}
+
+// ValidateArgs checks args against the task's schema. Unknown tasks are rejected.
+func ValidateArgs(task string, args []string) error {
+	s, ok := Lookup(task)
+	if !ok {
+		return fmt.Errorf("task %q is not in the catalog", task)
+	}
+	return s.ValidateArgs(args)
+}
+
+func (s Spec) ValidateArgs(args []string) error {
+	if s.schema == nil {
+		return nil
+	}
+	if err := s.schema.validateArgs(args); err != nil {
+		return fmt.Errorf("invalid args for %s: %w", s.Name, err)
+	}
+	if s.Check != nil {
+		if err := s.Check(args); err != nil {
+			return fmt.Errorf("invalid args for %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// Params describes the positional args, in order, so they can be extracted
+// from a chat message.
+func (s Spec) Params() []Param {
+	if s.schema == nil {
+		return nil
+	}
+	out := make([]Param, 0, len(s.schema.PrefixItems))
+	for i, item := range s.schema.PrefixItems {
+		name := item.Title
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i+1)
+		}
+		out = append(out, Param{Name: name, Description: item.Description, Required: i < s.schema.minItems()})
+	}
+	return out
+}
+
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// Timeout returns requested clamped to the task's limits; zero means default.
+func Timeout(task string, requested time.Duration) time.Duration {
+	s, ok := Lookup(task)
+	if !ok {
+		if requested > 0 {
+			return requested
+		}
+		return defaultTaskTimeout
+	}
+	return s.Timeout(requested)
+}
+
+func (s Spec) Timeout(requested time.Duration) time.Duration {
+	switch {
+	case requested <= 0:
+		return s.DefaultTimeout
+	case requested > s.MaxTimeout:
+		return s.MaxTimeout
+	}
+	return requested
+}
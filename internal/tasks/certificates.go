@@ -0,0 +1,143 @@
+// internal/tasks/certificates.go
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// acme account emails; kept simple enough to pass to certbot unquoted
+const patternEmail = `^[A-Za-z0-9._%+-]{1,64}@([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`
+
+func init() {
+	Register(Spec{
+		Name:        "issue_certificate",
+		Description: "obtain a Let's Encrypt certificate for a domain and install it in the web server (nginx or Apache) to enable HTTPS",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "domain", "description": "domain to secure, e.g. example.com", "type": "string", "pattern": ` + jsonString(patternDomain) + `},
+				{"title": "email", "description": "email for expiry notices from Let's Encrypt", "type": "string", "pattern": ` + jsonString(patternEmail) + `}
+			],
+			"minItems": 1,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 3 * time.Minute,
+		MaxTimeout:     10 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Parse:          ParseCertificate,
+	})
+	Register(Spec{
+		Name:           "list_certificates",
+		Description:    "list the TLS certificates installed on the server with the domains they cover and when they expire",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseCertificateList,
+	})
+}
+
+// Certificate is one certificate on the server
+type Certificate struct {
+	Name      string    `json:"name"`    // certbot lineage name, usually the first domain
+	Domains   []string  `json:"domains"` // subject alternative names
+	Issuer    string    `json:"issuer,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Path      string    `json:"path"`                 // fullchain path
+	WebServer string    `json:"web_server,omitempty"` // nginx or apache, where it was installed
+}
+
+// DaysLeft is the number of whole days until the certificate expires, negative once expired.
+func (c Certificate) DaysLeft(now time.Time) int {
+	d := c.NotAfter.Sub(now)
+	if d < 0 {
+		return -int((-d).Hours()/24) - 1
+	}
+	return int(d.Hours() / 24)
+}
+
+// Covers reports whether the certificate is valid for domain, including one-level wildcards.
+func (c Certificate) Covers(domain string) bool {
+	for _, d := range c.Domains {
+		if d == domain {
+			return true
+		}
+		if strings.HasPrefix(d, "*.") {
+			if i := strings.Index(domain, "."); i > 0 && domain[i+1:] == d[2:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Certificate) validate() error {
+	if len(c.Domains) == 0 || c.NotAfter.IsZero() {
+		return fmt.Errorf("certificate %q missing domains or expiry", c.Name)
+	}
+	return nil
+}
+
+func ParseCertificate(_ []string, out string) (interface{}, error) {
+	var c Certificate
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &c); err != nil {
+		return nil, fmt.Errorf("invalid certificate result: %w", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CertificateList is the parsed output of list_certificates, soonest expiry first
+type CertificateList struct {
+	Certificates []Certificate `json:"certificates"`
+}
+
+// Expiring returns the certificates that expire within d of now.
+func (l CertificateList) Expiring(now time.Time, d time.Duration) []Certificate {
+	var out []Certificate
+	for _, c := range l.Certificates {
+		if c.NotAfter.Before(now.Add(d)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// For returns the certificate covering domain that expires last.
+func (l CertificateList) For(domain string) (Certificate, bool) {
+	var best Certificate
+	found := false
+	for _, c := range l.Certificates {
+		if c.Covers(domain) && (!found || c.NotAfter.After(best.NotAfter)) {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+func ParseCertificateList(_ []string, out string) (interface{}, error) {
+	list := CertificateList{Certificates: []Certificate{}}
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return list, nil
+	}
+	if err := json.Unmarshal([]byte(out), &list.Certificates); err != nil {
+		return nil, fmt.Errorf("invalid certificate list: %w", err)
+	}
+	for _, c := range list.Certificates {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(list.Certificates, func(i, j int) bool {
+		return list.Certificates[i].NotAfter.Before(list.Certificates[j].NotAfter)
+	})
+	return list, nil
+}
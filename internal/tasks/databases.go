@@ -0,0 +1,206 @@
+// internal/tasks/databases.go
+package tasks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// identifiers are passed to the agent unquoted, so keep them to a subset
+	// valid in both MySQL and PostgreSQL without quoting
+	patternDBName = `^[a-z][a-z0-9_]{0,62}$`
+	patternDBUser = `^[a-z][a-z0-9_]{0,31}$`
+)
+
+var dbEngines = []string{"mysql", "postgresql"}
+
+// system databases and accounts no task may create, grant on or dump
+var (
+	reservedDatabases = map[string]bool{
+		"mysql": true, "information_schema": true, "performance_schema": true, "sys": true,
+		"postgres": true, "template0": true, "template1": true,
+	}
+	reservedDBUsers = map[string]bool{
+		"root": true, "mysql": true, "postgres": true, "debian_sys_maint": true, "admin": true,
+	}
+)
+
+func checkDBNames(dbIndex, userIndex int) func([]string) error {
+	return func(args []string) error {
+		if dbIndex < len(args) && reservedDatabases[args[dbIndex]] {
+			return fmt.Errorf("%q is a system database", args[dbIndex])
+		}
+		if userIndex >= 0 && userIndex < len(args) && reservedDBUsers[args[userIndex]] {
+			return fmt.Errorf("%q is a reserved account", args[userIndex])
+		}
+		return nil
+	}
+}
+
+func init() {
+	engine := `{"title": "engine", "description": "database server: mysql (also MariaDB) or postgresql", "type": "string", "enum": ` + jsonValue(dbEngines) + `}`
+	database := `{"title": "database", "description": "database name: lowercase letters, digits and underscores", "type": "string", "pattern": ` + jsonString(patternDBName) + `}`
+	user := `{"title": "user", "description": "database user name: lowercase letters, digits and underscores", "type": "string", "pattern": ` + jsonString(patternDBUser) + `}`
+
+	Register(Spec{
+		Name:           "db_list",
+		Description:    "list the databases on the server with their sizes",
+		ArgsSchema:     schemaJSON(`{"type": "array", "prefixItems": [` + engine + `], "minItems": 1, "maxItems": 1}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseDatabaseList,
+	})
+	Register(Spec{
+		Name:        "db_create",
+		Description: "create a database and a user that owns it, with a generated password",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [` + engine + `, ` + database + `, ` + user + `],
+			"minItems": 3,
+			"maxItems": 3
+		}`),
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Check:          checkDBNames(1, 2),
+	})
+	Register(Spec{
+		Name:        "db_grant",
+		Description: "grant a database user read-only, read-write or full access to a database",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [` + engine + `, ` + database + `, ` + user + `,
+				{"title": "access", "description": "readonly, readwrite or all", "type": "string", "enum": ["readonly", "readwrite", "all"]}
+			],
+			"minItems": 4,
+			"maxItems": 4
+		}`),
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Check:          checkDBNames(1, 2),
+	})
+	Register(Spec{
+		Name:        "db_dump",
+		Description: "dump a database to a compressed file on the server",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [` + engine + `, ` + database + `],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 10 * time.Minute,
+		MaxTimeout:     30 * time.Minute,
+		Risk:           RiskLow,
+		Check:          checkDBNames(1, -1),
+		Parse:          ParseDBDump,
+		MaxConcurrent:  1,
+	})
+	Register(Spec{
+		Name:        "db_slow_queries",
+		Description: "summarize the slowest database queries",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [` + engine + `,
+				{"title": "limit", "description": "number of queries to show, default 10", "type": "integer", "minimum": 1, "maximum": 50}
+			],
+			"minItems": 1,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseSlowQueries,
+	})
+}
+
+// Database is one row of db_list
+type Database struct {
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// DatabaseList is the parsed output of db_list
+type DatabaseList struct {
+	Engine    string     `json:"engine"`
+	Databases []Database `json:"databases"`
+}
+
+// ParseDatabaseList reads "name<TAB>size_bytes" rows; system databases are dropped.
+func ParseDatabaseList(args []string, out string) (interface{}, error) {
+	list := DatabaseList{Databases: []Database{}}
+	if len(args) > 0 {
+		list.Engine = args[0]
+	}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if reservedDatabases[fields[0]] {
+			continue
+		}
+		list.Databases = append(list.Databases, Database{Name: fields[0], SizeBytes: size})
+	}
+	return list, nil
+}
+
+// DBDump is the parsed output of db_dump
+type DBDump struct {
+	Database  string `json:"database"`
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+func ParseDBDump(_ []string, out string) (interface{}, error) {
+	var d DBDump
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &d); err != nil {
+		return nil, fmt.Errorf("invalid dump result: %w", err)
+	}
+	if !strings.HasPrefix(d.Path, "/") || !sha256Re.MatchString(d.SHA256) {
+		return nil, fmt.Errorf("dump result missing path or checksum")
+	}
+	return d, nil
+}
+
+// SlowQuery is one normalized query from the slow log / pg_stat_statements
+type SlowQuery struct {
+	Query        string  `json:"query"`
+	Calls        int64   `json:"calls"`
+	TotalTimeMS  float64 `json:"total_time_ms"`
+	AvgTimeMS    float64 `json:"avg_time_ms"`
+	RowsExamined int64   `json:"rows_examined,omitempty"`
+}
+
+// SlowQueries is the parsed output of db_slow_queries, slowest total first
+type SlowQueries struct {
+	Queries []SlowQuery `json:"queries"`
+}
+
+func ParseSlowQueries(_ []string, out string) (interface{}, error) {
+	var s SlowQueries
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &s.Queries); err != nil {
+		return nil, fmt.Errorf("invalid slow query summary: %w", err)
+	}
+	for i := range s.Queries {
+		if s.Queries[i].AvgTimeMS == 0 && s.Queries[i].Calls > 0 {
+			s.Queries[i].AvgTimeMS = s.Queries[i].TotalTimeMS / float64(s.Queries[i].Calls)
+		}
+		s.Queries[i].Query = truncateLine(s.Queries[i].Query)
+	}
+	return s, nil
+}
@@ -0,0 +1,120 @@
+// internal/tasks/diagnostics.go
+package tasks
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Spec{
+		Name:        "check_dns_resolution",
+		Description: "resolve a domain from the server and list the addresses it points to",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "domain", "description": "domain name to resolve", "type": "string", "pattern": ` + jsonString(patternDomain) + `}
+			],
+			"minItems": 1,
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseDNSResolution,
+	})
+	Register(Spec{
+		Name:        "check_oom_kills",
+		Description: "list processes the kernel killed because the server ran out of memory",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "window", "description": "how far back, as a number followed by m, h or d (e.g. 30m, 6h, 1d)", "type": "string", "pattern": ` + jsonString(patternLogWindow) + `}
+			],
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseOOMKills,
+	})
+}
+
+// ErrNoAddresses is returned by ParseDNSResolution when the resolver found
+// no A/AAAA records.
+var ErrNoAddresses = errors.New("no addresses in resolver output")
+
+// DNSResolution is the parsed output of check_dns_resolution
+type DNSResolution struct {
+	Domain    string   `json:"domain"`
+	Addresses []string `json:"addresses"`
+}
+
+// Contains reports whether ip is one of the resolved addresses.
+func (d DNSResolution) Contains(ip string) bool {
+	want := net.ParseIP(ip)
+	for _, a := range d.Addresses {
+		if want != nil && want.Equal(net.ParseIP(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDNSResolution accepts `dig +short` or `getent ahosts` output; lines
+// that don't start with an IP (CNAME targets, errors) are skipped.
+func ParseDNSResolution(args []string, out string) (interface{}, error) {
+	res := DNSResolution{}
+	if len(args) > 0 {
+		res.Domain = args[0]
+	}
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || net.ParseIP(fields[0]) == nil || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		res.Addresses = append(res.Addresses, fields[0])
+	}
+	if len(res.Addresses) == 0 {
+		return nil, ErrNoAddresses
+	}
+	return res, nil
+}
+
+// OOMKill is one kernel OOM-killer event
+type OOMKill struct {
+	Time    string `json:"time,omitempty"` // as printed in the kernel log
+	PID     int    `json:"pid"`
+	Process string `json:"process"`
+}
+
+// OOMKills is the parsed output of check_oom_kills
+type OOMKills struct {
+	Kills []OOMKill `json:"kills"`
+}
+
+var oomKillLine = regexp.MustCompile(`^(.*?)\s*(?:kernel:\s*)?(?:\[[\d.\s]+\]\s*)?Out of memory: Kill(?:ed)? process (\d+) \(([^)]+)\)`)
+
+// ParseOOMKills parses kernel log lines; output without OOM lines is an
+// empty result, not an error.
+func ParseOOMKills(_ []string, out string) (interface{}, error) {
+	res := OOMKills{Kills: []OOMKill{}}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		m := oomKillLine.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		pid, _ := strconv.Atoi(m[2])
+		res.Kills = append(res.Kills, OOMKill{Time: strings.TrimSpace(m[1]), PID: pid, Process: m[3]})
+	}
+	return res, nil
+}
@@ -0,0 +1,130 @@
+// internal/tasks/facts.go
+package tasks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Spec{
+		Name:           "system_facts",
+		Description:    "report the server's operating system, architecture, CPU count, memory and free disk space",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseFacts,
+	})
+	Register(Spec{
+		Name:           "check_listening_ports",
+		Description:    "list the network ports the server is listening on and which programs own them",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseListeningPorts,
+	})
+}
+
+// Facts describe the VPS as reported by the agent
+type Facts struct {
+	OSFamily   string `json:"os_family"` // debian, rhel, ...
+	Distro     string `json:"distro"`
+	Version    string `json:"version"`
+	Arch       string `json:"arch"`
+	CPUs       int    `json:"cpus"`
+	RAMMB      int64  `json:"ram_mb"`
+	DiskFreeMB int64  `json:"disk_free_mb"` // on the root filesystem
+	Firewall   string `json:"firewall"`     // ufw, firewalld, nftables or none
+}
+
+func ParseFacts(_ []string, out string) (interface{}, error) {
+	var f Facts
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &f); err != nil {
+		return nil, fmt.Errorf("invalid facts JSON: %w", err)
+	}
+	if f.OSFamily == "" {
+		return nil, fmt.Errorf("facts missing os_family")
+	}
+	f.OSFamily = strings.ToLower(f.OSFamily)
+	return f, nil
+}
+
+// ListeningPort is one row of `ss -ltnp`
+type ListeningPort struct {
+	Proto   string `json:"proto"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Process string `json:"process,omitempty"`
+}
+
+// ListeningPorts is the parsed output of check_listening_ports
+type ListeningPorts struct {
+	Ports []ListeningPort `json:"ports"`
+}
+
+// Has reports whether anything listens on port.
+func (l ListeningPorts) Has(port int) bool {
+	for _, p := range l.Ports {
+		if p.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseListeningPorts parses `ss -ltnp` (optionally with -u and without header).
+func ParseListeningPorts(_ []string, out string) (interface{}, error) {
+	var res ListeningPorts
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 || fields[0] == "State" || fields[0] == "Netid" {
+			continue
+		}
+
+		proto := "tcp"
+		if fields[0] == "tcp" || fields[0] == "udp" {
+			proto = fields[0]
+			fields = fields[1:]
+		}
+		if len(fields) < 4 || (fields[0] != "LISTEN" && fields[0] != "UNCONN") {
+			continue
+		}
+
+		local := fields[3]
+		i := strings.LastIndex(local, ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(local[i+1:])
+		if err != nil {
+			continue
+		}
+		p := ListeningPort{Proto: proto, Address: local[:i], Port: port}
+		if len(fields) > 5 {
+			p.Process = processName(strings.Join(fields[5:], " "))
+		}
+		res.Ports = append(res.Ports, p)
+	}
+	if len(res.Ports) == 0 {
+		return nil, fmt.Errorf("no listening sockets in output")
+	}
+	return res, nil
+}
+
+// processName extracts "nginx" from users:(("nginx",pid=1,fd=6))
+func processName(users string) string {
+	start := strings.Index(users, `(("`)
+	if start < 0 {
+		return ""
+	}
+	rest := users[start+3:]
+	if end := strings.Index(rest, `"`); end >= 0 {
+		return rest[:end]
+	}
+	return ""
+}
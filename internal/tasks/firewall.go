@@ -0,0 +1,311 @@
+// internal/tasks/firewall.go
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// a port or an inclusive range such as 8000:8100
+	patternPortSpec = `^[0-9]{1,5}(:[0-9]{1,5})?$`
+	// an IPv4/IPv6 address or CIDR; checkSource does the real parsing
+	patternSource = `^[0-9a-fA-F.:]{2,39}(/[0-9]{1,3})?$`
+	// change ids are generated by the agent
+	patternChangeID = `^[a-f0-9-]{8,36}$`
+)
+
+// FirewallTasks are the tasks that change the firewall; each is applied
+// with an automatic rollback the gateway must cancel with firewall_commit.
+var FirewallTasks = map[string]bool{}
+
+func init() {
+	port := `{"title": "port", "description": "port number or range, e.g. 443 or 8000:8100", "type": "string", "pattern": ` + jsonString(patternPortSpec) + `}`
+	proto := `{"title": "protocol", "description": "tcp or udp, default tcp", "type": "string", "enum": ["tcp", "udp"]}`
+	source := `{"title": "ip", "description": "IP address or CIDR range, e.g. 203.0.113.7 or 203.0.113.0/24", "type": "string", "pattern": ` + jsonString(patternSource) + `}`
+
+	register := func(s Spec) {
+		s.DefaultTimeout, s.MaxTimeout = time.Minute, 2*time.Minute
+		s.Risk, s.Confirm = RiskHigh, true
+		s.Exclusive = ExclusiveFirewall
+		s.Parse = ParseFirewallChange
+		Register(s)
+		FirewallTasks[s.Name] = true
+	}
+
+	Register(Spec{
+		Name:           "firewall_rules",
+		Description:    "list the firewall rules in order with the default policy",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseFirewallRuleset,
+	})
+	register(Spec{
+		Name:        "firewall_open_port",
+		Description: "open a port in the firewall to everyone",
+		ArgsSchema:  schemaJSON(`{"type": "array", "prefixItems": [` + port + `, ` + proto + `], "minItems": 1, "maxItems": 2}`),
+		Check:       checkPortArg(0),
+	})
+	register(Spec{
+		Name:        "firewall_close_port",
+		Description: "close a port in the firewall by removing the rules that allow it",
+		ArgsSchema:  schemaJSON(`{"type": "array", "prefixItems": [` + port + `, ` + proto + `], "minItems": 1, "maxItems": 2}`),
+		Check:       checkPortArg(0),
+	})
+	register(Spec{
+		Name:        "firewall_allow_ip",
+		Description: "allow an IP address or range through the firewall, to every port or one port",
+		ArgsSchema:  schemaJSON(`{"type": "array", "prefixItems": [` + source + `, ` + port + `], "minItems": 1, "maxItems": 2}`),
+		Check: func(args []string) error {
+			if err := checkSource(args[0]); err != nil {
+				return err
+			}
+			return checkPortArg(1)(args)
+		},
+	})
+	register(Spec{
+		Name:        "firewall_deny_ip",
+		Description: "block an IP address or range at the firewall",
+		ArgsSchema:  schemaJSON(`{"type": "array", "prefixItems": [` + source + `], "minItems": 1, "maxItems": 1}`),
+		Check:       func(args []string) error { return checkSource(args[0]) },
+	})
+	Register(Spec{
+		Name:        "firewall_commit",
+		Description: "keep a firewall change, cancelling its automatic rollback",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [{"title": "change_id", "type": "string", "pattern": ` + jsonString(patternChangeID) + `}],
+			"minItems": 1,
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 15 * time.Second,
+		MaxTimeout:     30 * time.Second,
+		Risk:           RiskLow,
+		Unmetered:      true,
+	})
+}
+
+func checkPortArg(i int) func([]string) error {
+	return func(args []string) error {
+		if i >= len(args) {
+			return nil
+		}
+		_, _, err := parsePortSpec(args[i])
+		return err
+	}
+}
+
+func parsePortSpec(s string) (lo, hi int, err error) {
+	a, b, isRange := strings.Cut(s, ":")
+	lo, err = strconv.Atoi(a)
+	hi = lo
+	if err == nil && isRange {
+		hi, err = strconv.Atoi(b)
+	}
+	if err != nil || lo < 1 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	return lo, hi, nil
+}
+
+func checkSource(s string) error {
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	return fmt.Errorf("invalid IP address or range %q", s)
+}
+
+// FirewallRule is one rule in evaluation order. Port is empty for every
+// port; Source is "any" or an address/CIDR.
+type FirewallRule struct {
+	Action string `json:"action"` // allow or deny
+	Proto  string `json:"proto"`  // tcp, udp or any
+	Port   string `json:"port,omitempty"`
+	Source string `json:"source"`
+}
+
+func (r FirewallRule) String() string {
+	port := "all ports"
+	if r.Port != "" {
+		port = "port " + r.Port
+	}
+	proto := ""
+	if r.Proto != "any" && r.Proto != "" {
+		proto = "/" + r.Proto
+	}
+	return fmt.Sprintf("%s %s%s from %s", r.Action, port, proto, r.Source)
+}
+
+func (r FirewallRule) matches(ip net.IP, port int, proto string) bool {
+	if r.Proto != "any" && r.Proto != "" && r.Proto != proto {
+		return false
+	}
+	if r.Port != "" {
+		lo, hi, err := parsePortSpec(r.Port)
+		if err != nil || port < lo || port > hi {
+			return false
+		}
+	}
+	return sourceContains(r.Source, ip)
+}
+
+func sourceContains(source string, ip net.IP) bool {
+	if source == "any" || source == "" {
+		return true
+	}
+	if _, n, err := net.ParseCIDR(source); err == nil {
+		return n.Contains(ip)
+	}
+	return net.ParseIP(source).Equal(ip)
+}
+
+// FirewallRuleset is the parsed output of firewall_rules
+type FirewallRuleset struct {
+	Backend         string         `json:"backend"` // ufw, firewalld or nftables
+	Active          bool           `json:"active"`
+	DefaultIncoming string         `json:"default_incoming"` // allow or deny
+	Rules           []FirewallRule `json:"rules"`
+	SSHPort         int            `json:"ssh_port"`
+	AgentPeer       string         `json:"agent_peer,omitempty"` // gateway address the agent is connected to
+}
+
+// Allows reports whether traffic from ip to port/proto gets through: the
+// first matching rule decides, then the default policy. An inactive firewall
+// allows everything.
+func (fw FirewallRuleset) Allows(ip string, port int, proto string) bool {
+	if !fw.Active {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, r := range fw.Rules {
+		if r.matches(addr, port, proto) {
+			return r.Action == "allow"
+		}
+	}
+	return fw.DefaultIncoming != "deny"
+}
+
+// Denies reports whether a deny rule blocks all traffic from ip.
+func (fw FirewallRuleset) Denies(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, r := range fw.Rules {
+		if r.Action == "deny" && r.Port == "" && sourceContains(r.Source, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the ruleset as it would be after running a firewall change
+// task with args. Denies and per-IP allows go first so they take precedence
+// over port rules, as the agent inserts them.
+func (fw FirewallRuleset) Apply(task string, args []string) (FirewallRuleset, error) {
+	arg := func(i int, def string) string {
+		if i < len(args) && args[i] != "" {
+			return args[i]
+		}
+		return def
+	}
+	out := fw
+	out.Rules = append([]FirewallRule(nil), fw.Rules...)
+
+	switch task {
+	case "firewall_open_port":
+		r := FirewallRule{Action: "allow", Proto: arg(1, "tcp"), Port: args[0], Source: "any"}
+		if !containsRule(out.Rules, r) {
+			out.Rules = append(out.Rules, r)
+		}
+	case "firewall_close_port":
+		kept := out.Rules[:0]
+		for _, r := range out.Rules {
+			if r.Action == "allow" && r.Port == args[0] && (r.Proto == arg(1, "tcp") || r.Proto == "any") {
+				continue
+			}
+			kept = append(kept, r)
+		}
+		out.Rules = kept
+	case "firewall_allow_ip":
+		r := FirewallRule{Action: "allow", Proto: "any", Port: arg(1, ""), Source: args[0]}
+		if r.Port != "" {
+			r.Proto = "tcp"
+		}
+		if !containsRule(out.Rules, r) {
+			out.Rules = append([]FirewallRule{r}, out.Rules...)
+		}
+	case "firewall_deny_ip":
+		r := FirewallRule{Action: "deny", Proto: "any", Source: args[0]}
+		if !containsRule(out.Rules, r) {
+			out.Rules = append([]FirewallRule{r}, out.Rules...)
+		}
+	default:
+		return fw, fmt.Errorf("%s does not change the firewall", task)
+	}
+	return out, nil
+}
+
+func containsRule(rules []FirewallRule, r FirewallRule) bool {
+	for _, x := range rules {
+		if x == r {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseFirewallRuleset(_ []string, out string) (interface{}, error) {
+	var fw FirewallRuleset
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &fw); err != nil {
+		return nil, fmt.Errorf("invalid firewall ruleset: %w", err)
+	}
+	if fw.Backend == "" {
+		return nil, fmt.Errorf("firewall backend not reported")
+	}
+	if fw.SSHPort == 0 {
+		fw.SSHPort = 22
+	}
+	if fw.Rules == nil {
+		fw.Rules = []FirewallRule{}
+	}
+	for i, r := range fw.Rules {
+		if r.Source == "" {
+			fw.Rules[i].Source = "any"
+		}
+		if r.Proto == "" {
+			fw.Rules[i].Proto = "any"
+		}
+	}
+	return fw, nil
+}
+
+// FirewallChange is the parsed output of the firewall change tasks
+type FirewallChange struct {
+	ChangeID       string          `json:"change_id"`
+	RollbackAfter  int             `json:"rollback_after_seconds"` // unless firewall_commit arrives first
+	Ruleset        FirewallRuleset `json:"ruleset"`
+	AlreadyApplied bool            `json:"already_applied,omitempty"` // nothing changed
+}
+
+func ParseFirewallChange(_ []string, out string) (interface{}, error) {
+	var c FirewallChange
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &c); err != nil {
+		return nil, fmt.Errorf("invalid firewall change result: %w", err)
+	}
+	if c.ChangeID == "" && !c.AlreadyApplied {
+		return nil, fmt.Errorf("firewall change result missing change_id")
+	}
+	return c, nil
+}
@@ -0,0 +1,250 @@
+// internal/tasks/logs.go
+package tasks
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KnownLogs are the log ids the agent maps to files on the VPS
+var KnownLogs = []string{
+	"nginx_access",
+	"nginx_error",
+	"apache_access",
+	"apache_error",
+	"php_fpm",
+	"mysql_error",
+	"syslog",
+}
+
+const patternLogWindow = `^[1-9][0-9]{0,3}[mhd]$` // e.g. 30m, 6h, 2d
+
+func init() {
+	logEnum := jsonValue(KnownLogs)
+
+	Register(Spec{
+		Name:        "read_log",
+		Description: "show the last lines of a server log (nginx, apache, php-fpm, mysql, syslog) and summarize errors",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "log", "description": "which log: ` + strings.Join(KnownLogs, ", ") + `", "type": "string", "enum": ` + logEnum + `},
+				{"title": "lines", "description": "number of lines from the end, default 200", "type": "integer", "minimum": 1, "maximum": 2000}
+			],
+			"minItems": 1,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          parseLogOutput,
+	})
+	Register(Spec{
+		Name:        "read_log_since",
+		Description: "show a server log for a recent time window, e.g. errors in the last hour",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "log", "description": "which log: ` + strings.Join(KnownLogs, ", ") + `", "type": "string", "enum": ` + logEnum + `},
+				{"title": "window", "description": "how far back, as a number followed by m, h or d (e.g. 30m, 6h, 1d)", "type": "string", "pattern": ` + jsonString(patternLogWindow) + `}
+			],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          parseLogOutput,
+	})
+	Register(Spec{
+		Name:        "read_journal",
+		Description: "show the systemd journal of a service for a recent time window",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "service", "description": "systemd service name, e.g. nginx or mysql", "type": "string", "pattern": ` + jsonString(patternServiceName) + `},
+				{"title": "window", "description": "how far back, as a number followed by m, h or d (e.g. 30m, 6h, 1d)", "type": "string", "pattern": ` + jsonString(patternLogWindow) + `}
+			],
+			"minItems": 1,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          parseJournalOutput,
+	})
+}
+
+// ErrorGroup is a set of log lines that differ only in variable parts
+type ErrorGroup struct {
+	Signature string `json:"signature"`
+	Level     string `json:"level"`
+	Count     int    `json:"count"`
+	Sample    string `json:"sample"`    // most recent full line
+	LastIndex int    `json:"last_line"` // line number of the most recent occurrence
+}
+
+// LogDigest is a compact, summarizer-friendly view of a log excerpt
+type LogDigest struct {
+	Log            string         `json:"log"`
+	TotalLines     int            `json:"total_lines"`
+	ErrorLines     int            `json:"error_lines"`
+	WarningLines   int            `json:"warning_lines"`
+	StatusCounts   map[string]int `json:"status_counts,omitempty"` // access logs: 2xx/3xx/4xx/5xx
+	Groups         []ErrorGroup   `json:"groups,omitempty"`        // most frequent first
+	RecentFailures []string       `json:"recent_failures,omitempty"`
+}
+
+const (
+	maxDigestGroups   = 10
+	maxRecentFailures = 5
+	maxSampleLength   = 300
+)
+
+var (
+	// "2024/01/02 10:11:12", "2024-01-02T10:11:12.123Z", "[Tue Jan 02 10:11:12.123 2024]", "Jan  2 10:11:12"
+	logTimestamp = regexp.MustCompile(`^(\[[^\]]*\d{2}:\d{2}:\d{2}[^\]]*\]|\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?|[A-Z][a-z]{2} +\d{1,2} \d{2}:\d{2}:\d{2})\s*`)
+	logIP        = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)
+	logHex       = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-f]{12,}\b`)
+	logNumber    = regexp.MustCompile(`\d+`)
+	logBracketTS = regexp.MustCompile(`\[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\]`)
+	accessStatus = regexp.MustCompile(`" (\d{3}) `)
+	errorLevel   = regexp.MustCompile(`(?i)\b(emerg|emergency|alert|crit|critical|fatal|error|err|failed|failure|panic)\b`)
+	warnLevel    = regexp.MustCompile(`(?i)\b(warn|warning)\b`)
+)
+
+// DigestLog groups repeated errors in a log excerpt and keeps the most recent
+// failures. Access logs are grouped by 5xx responses, everything else by level.
+func DigestLog(logName, out string) LogDigest {
+	d := LogDigest{Log: logName}
+	access := strings.HasSuffix(logName, "_access")
+	if access {
+		d.StatusCounts = map[string]int{}
+	}
+
+	groups := map[string]*ErrorGroup{}
+	var failures []string
+
+	sc := bufio.NewScanner(strings.NewReader(out))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		d.TotalLines++
+
+		level := ""
+		if access {
+			if m := accessStatus.FindStringSubmatch(line); m != nil {
+				d.StatusCounts[m[1][:1]+"xx"]++
+				if m[1][0] == '5' {
+					level = "error"
+				}
+			}
+		} else if errorLevel.MatchString(line) {
+			level = "error"
+		} else if warnLevel.MatchString(line) {
+			level = "warning"
+		}
+
+		switch level {
+		case "error":
+			d.ErrorLines++
+			failures = append(failures, truncateLine(line))
+		case "warning":
+			d.WarningLines++
+		default:
+			continue
+		}
+
+		sig := logSignature(line)
+		g, ok := groups[sig]
+		if !ok {
+			g = &ErrorGroup{Signature: sig, Level: level}
+			groups[sig] = g
+		}
+		g.Count++
+		g.Sample = truncateLine(line)
+		g.LastIndex = d.TotalLines
+	}
+
+	for _, g := range groups {
+		d.Groups = append(d.Groups, *g)
+	}
+	sort.Slice(d.Groups, func(i, j int) bool {
+		a, b := d.Groups[i], d.Groups[j]
+		if a.Level != b.Level {
+			return a.Level == "error"
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.LastIndex > b.LastIndex
+	})
+	if len(d.Groups) > maxDigestGroups {
+		d.Groups = d.Groups[:maxDigestGroups]
+	}
+
+	// newest first
+	for i := len(failures) - 1; i >= 0 && len(d.RecentFailures) < maxRecentFailures; i-- {
+		d.RecentFailures = append(d.RecentFailures, failures[i])
+	}
+	return d
+}
+
+// logSignature strips timestamps and variable tokens so repeats group together
+func logSignature(line string) string {
+	s := logTimestamp.ReplaceAllString(line, "")
+	s = logBracketTS.ReplaceAllString(s, "")
+	s = logIP.ReplaceAllString(s, "<ip>")
+	s = logHex.ReplaceAllString(s, "<hex>")
+	s = logNumber.ReplaceAllString(s, "N")
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > 160 {
+		s = s[:160]
+	}
+	return s
+}
+
+func truncateLine(s string) string {
+	if len(s) <= maxSampleLength {
+		return s
+	}
+	return s[:maxSampleLength] + "..."
+}
+
+// String renders the digest as compact text for the summarizer.
+func (d LogDigest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Log %s: %d lines, %d errors, %d warnings.\n", d.Log, d.TotalLines, d.ErrorLines, d.WarningLines)
+	if len(d.StatusCounts) > 0 {
+		keys := make([]string, 0, len(d.StatusCounts))
+		for k := range d.StatusCounts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("HTTP responses:")
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%d", k, d.StatusCounts[k])
+		}
+		b.WriteString("\n")
+	}
+	if len(d.Groups) > 0 {
+		b.WriteString("Repeated problems (most frequent first):\n")
+		for _, g := range d.Groups {
+			fmt.Fprintf(&b, "- %dx %s: %s\n", g.Count, g.Level, g.Sample)
+		}
+	}
+	if len(d.RecentFailures) > 0 {
+		b.WriteString("Most recent failures:\n")
+		for _, f := range d.RecentFailures {
+			fmt.Fprintf(&b, "- %s\n", f)
+		}
+	}
+	return b.String()
+}
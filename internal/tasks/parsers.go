@@ -0,0 +1,294 @@
+// internal/tasks/parsers.go
+package tasks
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parser turns a task's stdout into a typed value. It returns an error when
+// the output isn't in the expected shape so callers can fall back to raw text.
+type Parser func(args []string, stdout string) (interface{}, error)
+
+// ErrNoParser is returned by ParseOutput for tasks without a parser.
+var ErrNoParser = errors.New("no parser for task")
+
+// ParseOutput runs the task's parser over stdout.
+func ParseOutput(task string, args []string, stdout string) (interface{}, error) {
+	s, ok := Lookup(task)
+	if !ok || s.Parse == nil {
+		return nil, ErrNoParser
+	}
+	if strings.TrimSpace(stdout) == "" {
+		return nil, fmt.Errorf("%s: empty output", task)
+	}
+	v, err := s.Parse(args, stdout)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", task, err)
+	}
+	return v, nil
+}
+
+// Filesystem is one row of `df -P` / `df -h`
+type Filesystem struct {
+	Filesystem  string `json:"filesystem"`
+	Size        string `json:"size"`
+	Used        string `json:"used"`
+	Available   string `json:"available"`
+	UsePercent  int    `json:"use_percent"`
+	MountedOn   string `json:"mounted_on"`
+	SizeKB      int64  `json:"size_kb,omitempty"` // set when df printed 1K blocks
+	AvailableKB int64  `json:"available_kb,omitempty"`
+}
+
+// DiskUsage is the parsed output of check_diskspace
+type DiskUsage struct {
+	Filesystems []Filesystem `json:"filesystems"`
+}
+
+// Fullest returns the filesystem with the highest usage.
+func (d DiskUsage) Fullest() (Filesystem, bool) {
+	var best Filesystem
+	found := false
+	for _, fs := range d.Filesystems {
+		if !found || fs.UsePercent > best.UsePercent {
+			best, found = fs, true
+		}
+	}
+	return best, found
+}
+
+// ParseDF parses df output, including rows wrapped onto two lines when the
+// filesystem name is long.
+func ParseDF(_ []string, out string) (interface{}, error) {
+	var usage DiskUsage
+	kb := false
+	pending := ""
+
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "Filesystem") {
+			kb = strings.Contains(line, "1K-blocks") || strings.Contains(line, "1024-blocks")
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 1 && pending == "" {
+			pending = fields[0]
+			continue
+		}
+		if pending != "" {
+			fields = append([]string{pending}, fields...)
+			pending = ""
+		}
+		if len(fields) < 6 || !strings.HasSuffix(fields[4], "%") {
+			continue
+		}
+
+		pct, err := strconv.Atoi(strings.TrimSuffix(fields[4], "%"))
+		if err != nil {
+			continue
+		}
+		fs := Filesystem{
+			Filesystem: fields[0],
+			Size:       fields[1],
+			Used:       fields[2],
+			Available:  fields[3],
+			UsePercent: pct,
+			MountedOn:  strings.Join(fields[5:], " "),
+		}
+		if kb {
+			fs.SizeKB, _ = strconv.ParseInt(fields[1], 10, 64)
+			fs.AvailableKB, _ = strconv.ParseInt(fields[3], 10, 64)
+		}
+		usage.Filesystems = append(usage.Filesystems, fs)
+	}
+	if len(usage.Filesystems) == 0 {
+		return nil, fmt.Errorf("no filesystems in df output")
+	}
+	return usage, nil
+}
+
+// Uptime is the parsed output of `uptime`
+type Uptime struct {
+	Up        string  `json:"up"` // as printed, e.g. "5 days, 3:04"
+	UpSeconds int64   `json:"up_seconds"`
+	Users     int     `json:"users"`
+	Load1     float64 `json:"load_1"`
+	Load5     float64 `json:"load_5"`
+	Load15    float64 `json:"load_15"`
+}
+
+var (
+	uptimeLine  = regexp.MustCompile(`up\s+(.*?),\s+(\d+)\s+users?,\s+load averages?:\s+([\d.]+),?\s+([\d.]+),?\s+([\d.]+)`)
+	uptimeNoUsr = regexp.MustCompile(`up\s+(.*?),\s+load averages?:\s+([\d.]+),?\s+([\d.]+),?\s+([\d.]+)`)
+	upDays      = regexp.MustCompile(`(\d+)\s+days?`)
+	upHHMM      = regexp.MustCompile(`(\d+):(\d{2})`)
+	upMin       = regexp.MustCompile(`(\d+)\s+min`)
+	upHours     = regexp.MustCompile(`(\d+)\s+hours?`)
+)
+
+func ParseUptime(_ []string, out string) (interface{}, error) {
+	line := strings.TrimSpace(out)
+	var u Uptime
+	var loads []string
+	if m := uptimeLine.FindStringSubmatch(line); m != nil {
+		u.Up = m[1]
+		u.Users, _ = strconv.Atoi(m[2])
+		loads = m[3:6]
+	} else if m := uptimeNoUsr.FindStringSubmatch(line); m != nil {
+		u.Up = m[1]
+		loads = m[2:5]
+	} else {
+		return nil, fmt.Errorf("unrecognised uptime output")
+	}
+
+	u.Load1, _ = strconv.ParseFloat(loads[0], 64)
+	u.Load5, _ = strconv.ParseFloat(loads[1], 64)
+	u.Load15, _ = strconv.ParseFloat(loads[2], 64)
+
+	var secs int64
+	if m := upDays.FindStringSubmatch(u.Up); m != nil {
+		d, _ := strconv.ParseInt(m[1], 10, 64)
+		secs += d * 86400
+	}
+	if m := upHHMM.FindStringSubmatch(u.Up); m != nil {
+		h, _ := strconv.ParseInt(m[1], 10, 64)
+		mi, _ := strconv.ParseInt(m[2], 10, 64)
+		secs += h*3600 + mi*60
+	} else {
+		if m := upHours.FindStringSubmatch(u.Up); m != nil {
+			h, _ := strconv.ParseInt(m[1], 10, 64)
+			secs += h * 3600
+		}
+		if m := upMin.FindStringSubmatch(u.Up); m != nil {
+			mi, _ := strconv.ParseInt(m[1], 10, 64)
+			secs += mi * 60
+		}
+	}
+	u.UpSeconds = secs
+	return u, nil
+}
+
+// Memory is the parsed output of `free -m`, in MiB
+type Memory struct {
+	TotalMB     int64 `json:"total_mb"`
+	UsedMB      int64 `json:"used_mb"`
+	FreeMB      int64 `json:"free_mb"`
+	SharedMB    int64 `json:"shared_mb"`
+	BuffCacheMB int64 `json:"buff_cache_mb"`
+	AvailableMB int64 `json:"available_mb"`
+	SwapTotalMB int64 `json:"swap_total_mb"`
+	SwapUsedMB  int64 `json:"swap_used_mb"`
+	SwapFreeMB  int64 `json:"swap_free_mb"`
+}
+
+// UsedPercent is the share of memory not available to new processes.
+func (m Memory) UsedPercent() int {
+	if m.TotalMB == 0 {
+		return 0
+	}
+	avail := m.AvailableMB
+	if avail == 0 {
+		avail = m.FreeMB + m.BuffCacheMB
+	}
+	return int((m.TotalMB - avail) * 100 / m.TotalMB)
+}
+
+func ParseFree(_ []string, out string) (interface{}, error) {
+	var m Memory
+	var header []string
+	found := false
+
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "total" {
+			header = fields
+			continue
+		}
+		nums := make([]int64, 0, len(fields)-1)
+		for _, f := range fields[1:] {
+			n, err := strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				break
+			}
+			nums = append(nums, n)
+		}
+
+		switch strings.TrimSuffix(fields[0], ":") {
+		case "Mem":
+			if len(nums) < 3 {
+				return nil, fmt.Errorf("short Mem row")
+			}
+			m.TotalMB, m.UsedMB, m.FreeMB = nums[0], nums[1], nums[2]
+			for i, col := range header {
+				if i >= len(nums) {
+					break
+				}
+				switch col {
+				case "shared":
+					m.SharedMB = nums[i]
+				case "buff/cache":
+					m.BuffCacheMB = nums[i]
+				case "buffers", "cached":
+					m.BuffCacheMB += nums[i]
+				case "available":
+					m.AvailableMB = nums[i]
+				}
+			}
+			found = true
+		case "Swap":
+			if len(nums) >= 3 {
+				m.SwapTotalMB, m.SwapUsedMB, m.SwapFreeMB = nums[0], nums[1], nums[2]
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no Mem row in free output")
+	}
+	return m, nil
+}
+
+func parseServiceStatusOutput(_ []string, out string) (interface{}, error) {
+	st, ok := ParseServiceStatus(out)
+	if !ok {
+		return nil, fmt.Errorf("unrecognised systemctl status output")
+	}
+	return st, nil
+}
+
+func parseServiceListOutput(_ []string, out string) (interface{}, error) {
+	units := ParseServiceList(out)
+	if len(units) == 0 {
+		return nil, fmt.Errorf("no units in list-units output")
+	}
+	return units, nil
+}
+
+func parseLogOutput(args []string, out string) (interface{}, error) {
+	name := "log"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	return DigestLog(name, out), nil
+}
+
+func parseJournalOutput(args []string, out string) (interface{}, error) {
+	name := "journal"
+	if len(args) > 0 {
+		name = "journal:" + args[0]
+	}
+	return DigestLog(name, out), nil
+}
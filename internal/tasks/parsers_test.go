@@ -0,0 +1,711 @@
+package tasks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDF(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    []Filesystem
+		wantErr bool
+	}{
+		{
+			name: "df -h",
+			out: `Filesystem      Size  Used Avail Use% Mounted on
+udev            966M     0  966M   0% /dev
+tmpfs           199M  1.1M  198M   1% /run
+/dev/vda1        25G   11G   14G  45% /
+tmpfs           994M     0  994M   0% /dev/shm
+`,
+			want: []Filesystem{
+				{Filesystem: "udev", Size: "966M", Used: "0", Available: "966M", UsePercent: 0, MountedOn: "/dev"},
+				{Filesystem: "tmpfs", Size: "199M", Used: "1.1M", Available: "198M", UsePercent: 1, MountedOn: "/run"},
+				{Filesystem: "/dev/vda1", Size: "25G", Used: "11G", Available: "14G", UsePercent: 45, MountedOn: "/"},
+				{Filesystem: "tmpfs", Size: "994M", Used: "0", Available: "994M", UsePercent: 0, MountedOn: "/dev/shm"},
+			},
+		},
+		{
+			name: "df -P with 1K blocks",
+			out: `Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/sda1         51474912 47312040   1524180      97% /
+`,
+			want: []Filesystem{
+				{Filesystem: "/dev/sda1", Size: "51474912", Used: "47312040", Available: "1524180", UsePercent: 97, MountedOn: "/", SizeKB: 51474912, AvailableKB: 1524180},
+			},
+		},
+		{
+			name: "wrapped long device name",
+			out: `Filesystem                        Size  Used Avail Use% Mounted on
+/dev/mapper/ubuntu--vg-ubuntu--lv
+                                   98G   71G   23G  76% /
+`,
+			want: []Filesystem{
+				{Filesystem: "/dev/mapper/ubuntu--vg-ubuntu--lv", Size: "98G", Used: "71G", Available: "23G", UsePercent: 76, MountedOn: "/"},
+			},
+		},
+		{
+			name:    "not df output",
+			out:     "df: /mnt/x: No such file or directory\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDF(nil, tt.out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.(DiskUsage).Filesystems, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUptime(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    Uptime
+		wantErr bool
+	}{
+		{
+			name: "days and hours",
+			out:  " 10:11:12 up 5 days,  3:04,  1 user,  load average: 0.00, 0.01, 0.05\n",
+			want: Uptime{Up: "5 days,  3:04", UpSeconds: 5*86400 + 3*3600 + 4*60, Users: 1, Load1: 0, Load5: 0.01, Load15: 0.05},
+		},
+		{
+			name: "minutes only",
+			out:  " 08:00:01 up 23 min,  2 users,  load average: 1.52, 0.98, 0.40",
+			want: Uptime{Up: "23 min", UpSeconds: 23 * 60, Users: 2, Load1: 1.52, Load5: 0.98, Load15: 0.40},
+		},
+		{
+			name: "single day and minutes",
+			out:  " 14:22:07 up 1 day, 12 min,  0 users,  load average: 0.08, 0.03, 0.01",
+			want: Uptime{Up: "1 day, 12 min", UpSeconds: 86400 + 12*60, Users: 0, Load1: 0.08, Load5: 0.03, Load15: 0.01},
+		},
+		{
+			name: "no users field (busybox)",
+			out:  " 09:15:00 up 2:01,  load average: 0.10, 0.20, 0.30",
+			want: Uptime{Up: "2:01", UpSeconds: 2*3600 + 60, Load1: 0.10, Load5: 0.20, Load15: 0.30},
+		},
+		{
+			name:    "garbage",
+			out:     "command not found",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUptime(nil, tt.out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.(Uptime) != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFree(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    Memory
+		usedPct int
+		wantErr bool
+	}{
+		{
+			name: "procps 3.3.10+",
+			out: `               total        used        free      shared  buff/cache   available
+Mem:            1987         612         154          12        1220        1186
+Swap:           2047          31        2016
+`,
+			want:    Memory{TotalMB: 1987, UsedMB: 612, FreeMB: 154, SharedMB: 12, BuffCacheMB: 1220, AvailableMB: 1186, SwapTotalMB: 2047, SwapUsedMB: 31, SwapFreeMB: 2016},
+			usedPct: 40,
+		},
+		{
+			name: "older procps with buffers and cached",
+			out: `             total       used       free     shared    buffers     cached
+Mem:          3951       3755        196          0        223       2541
+-/+ buffers/cache:        990       2961
+Swap:         4095          0       4095
+`,
+			want:    Memory{TotalMB: 3951, UsedMB: 3755, FreeMB: 196, BuffCacheMB: 2764, SwapTotalMB: 4095, SwapFreeMB: 4095},
+			usedPct: 25,
+		},
+		{
+			name:    "missing Mem row",
+			out:     "Swap: 0 0 0\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFree(nil, tt.out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			m := got.(Memory)
+			if m != tt.want {
+				t.Errorf("got %+v\nwant %+v", m, tt.want)
+			}
+			if m.UsedPercent() != tt.usedPct {
+				t.Errorf("UsedPercent() = %d, want %d", m.UsedPercent(), tt.usedPct)
+			}
+		})
+	}
+}
+
+func TestParseServiceStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    ServiceStatus
+		running bool
+		ok      bool
+	}{
+		{
+			name: "active nginx",
+			out: `● nginx.service - A high performance web server and a reverse proxy server
+     Loaded: loaded (/lib/systemd/system/nginx.service; enabled; vendor preset: enabled)
+     Active: active (running) since Mon 2024-01-01 10:00:00 UTC; 2 days ago
+       Docs: man:nginx(8)
+   Main PID: 456 (nginx)
+      Tasks: 3 (limit: 1131)
+     Memory: 5.2M
+     CGroup: /system.slice/nginx.service
+             ├─456 "nginx: master process /usr/sbin/nginx -g daemon on; master_process on;"
+             └─457 "nginx: worker process"
+
+Jan 01 10:00:00 web1 systemd[1]: Starting A high performance web server...
+Jan 01 10:00:00 web1 systemd[1]: Started A high performance web server.
+`,
+			want: ServiceStatus{
+				Unit: "nginx", Description: "A high performance web server and a reverse proxy server",
+				LoadState: "loaded", UnitFile: "enabled", ActiveState: "active", SubState: "running",
+				Since: "Mon 2024-01-01 10:00:00 UTC", MainPID: 456, Tasks: 3, Memory: "5.2M",
+				RecentLogs: []string{
+					"Jan 01 10:00:00 web1 systemd[1]: Starting A high performance web server...",
+					"Jan 01 10:00:00 web1 systemd[1]: Started A high performance web server.",
+				},
+			},
+			running: true,
+			ok:      true,
+		},
+		{
+			name: "failed mysql",
+			out: `× mysql.service - MySQL Community Server
+     Loaded: loaded (/lib/systemd/system/mysql.service; enabled; vendor preset: enabled)
+     Active: failed (Result: exit-code) since Tue 2024-01-02 03:04:05 UTC; 5min ago
+    Process: 991 ExecStart=/usr/sbin/mysqld (code=exited, status=1/FAILURE)
+   Main PID: 991 (code=exited, status=1/FAILURE)
+
+Jan 02 03:04:05 db1 systemd[1]: mysql.service: Failed with result 'exit-code'.
+`,
+			want: ServiceStatus{
+				Unit: "mysql", Description: "MySQL Community Server",
+				LoadState: "loaded", UnitFile: "enabled", ActiveState: "failed", SubState: "Result: exit-code",
+				Since: "Tue 2024-01-02 03:04:05 UTC", MainPID: 991,
+				RecentLogs: []string{"Jan 02 03:04:05 db1 systemd[1]: mysql.service: Failed with result 'exit-code'."},
+			},
+			ok: true,
+		},
+		{
+			name: "inactive and disabled",
+			out: `○ redis-server.service - Advanced key-value store
+     Loaded: loaded (/lib/systemd/system/redis-server.service; disabled; vendor preset: enabled)
+     Active: inactive (dead)
+`,
+			want: ServiceStatus{
+				Unit: "redis-server", Description: "Advanced key-value store",
+				LoadState: "loaded", UnitFile: "disabled", ActiveState: "inactive", SubState: "dead",
+			},
+			ok: true,
+		},
+		{
+			name: "unknown unit",
+			out:  "Unit foo.service could not be found.\n",
+			want: ServiceStatus{Unit: "foo", LoadState: "not-found", ActiveState: "inactive"},
+			ok:   true,
+		},
+		{
+			name: "not a status report",
+			out:  "permission denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseServiceStatus(tt.out)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+			if got.Running() != tt.running {
+				t.Errorf("Running() = %v, want %v", got.Running(), tt.running)
+			}
+		})
+	}
+}
+
+func TestParseServiceList(t *testing.T) {
+	out := `cron.service                 loaded active   running Regular background program processing daemon
+● mysql.service              loaded failed   failed  MySQL Community Server
+nginx.service                loaded active   running A high performance web server
+systemd-tmpfiles.timer       loaded active   waiting Daily Cleanup of Temporary Directories
+`
+	want := []ServiceUnit{
+		{Unit: "cron", Load: "loaded", Active: "active", Sub: "running", Description: "Regular background program processing daemon"},
+		{Unit: "mysql", Load: "loaded", Active: "failed", Sub: "failed", Description: "MySQL Community Server"},
+		{Unit: "nginx", Load: "loaded", Active: "active", Sub: "running", Description: "A high performance web server"},
+	}
+	if got := ParseServiceList(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseListeningPorts(t *testing.T) {
+	out := `State  Recv-Q Send-Q Local Address:Port  Peer Address:Port Process
+LISTEN 0      511          0.0.0.0:80         0.0.0.0:*     users:(("nginx",pid=812,fd=6),("nginx",pid=811,fd=6))
+LISTEN 0      151        127.0.0.1:3306       0.0.0.0:*     users:(("mysqld",pid=700,fd=23))
+LISTEN 0      128             [::]:22            [::]:*
+`
+	want := ListeningPorts{Ports: []ListeningPort{
+		{Proto: "tcp", Address: "0.0.0.0", Port: 80, Process: "nginx"},
+		{Proto: "tcp", Address: "127.0.0.1", Port: 3306, Process: "mysqld"},
+		{Proto: "tcp", Address: "[::]", Port: 22},
+	}}
+	got, err := ParseListeningPorts(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if !want.Has(3306) || want.Has(443) {
+		t.Errorf("Has mismatch")
+	}
+}
+
+func TestParseFacts(t *testing.T) {
+	got, err := ParseFacts(nil, `{"os_family":"Debian","distro":"ubuntu","version":"22.04","arch":"x86_64","cpus":2,"ram_mb":1987,"disk_free_mb":14200}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Facts{OSFamily: "debian", Distro: "ubuntu", Version: "22.04", Arch: "x86_64", CPUs: 2, RAMMB: 1987, DiskFreeMB: 14200}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if _, err := ParseFacts(nil, `{"distro":"ubuntu"}`); err == nil {
+		t.Error("expected error for missing os_family")
+	}
+}
+
+func TestParseDNSResolution(t *testing.T) {
+	out := `www.example.com.
+93.184.216.34
+93.184.216.34
+2606:2800:220:1:248:1893:25c8:1946
+`
+	got, err := ParseDNSResolution([]string{"example.com"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := got.(DNSResolution)
+	want := []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"}
+	if !reflect.DeepEqual(res.Addresses, want) {
+		t.Errorf("got %v, want %v", res.Addresses, want)
+	}
+	if !res.Contains("93.184.216.34") || res.Contains("10.0.0.1") {
+		t.Errorf("Contains mismatch")
+	}
+	if _, err := ParseDNSResolution(nil, ";; connection timed out; no servers could be reached\n"); err == nil {
+		t.Error("expected error for resolver failure")
+	}
+}
+
+func TestParseOOMKills(t *testing.T) {
+	out := `Oct 18 03:12:44 web1 kernel: [812345.123456] Out of memory: Killed process 2211 (mysqld) total-vm:1803212kB, anon-rss:611232kB
+Oct 18 03:12:45 web1 kernel: oom_reaper: reaped process 2211 (mysqld), now anon-rss:0kB
+Oct 18 04:01:02 web1 kernel: Out of memory: Kill process 3100 (php-fpm8.2) score 120 or sacrifice child
+`
+	got, err := ParseOOMKills(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OOMKills{Kills: []OOMKill{
+		{Time: "Oct 18 03:12:44 web1", PID: 2211, Process: "mysqld"},
+		{Time: "Oct 18 04:01:02 web1", PID: 3100, Process: "php-fpm8.2"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseSSHConfig(t *testing.T) {
+	out := `port 2222
+permitrootlogin prohibit-password
+pubkeyauthentication yes
+passwordauthentication yes
+permitemptypasswords no
+maxauthtries 6
+`
+	got, err := ParseSSHConfig(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SSHConfig{Port: 2222, PermitRootLogin: "prohibit-password", PubkeyAuthentication: true, PasswordAuthentication: true, MaxAuthTries: 6}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseFailedLogins(t *testing.T) {
+	out := `Oct 18 10:00:01 web1 sshd[100]: Failed password for root from 203.0.113.5 port 40000 ssh2
+Oct 18 10:00:03 web1 sshd[101]: Failed password for invalid user admin from 203.0.113.5 port 40002 ssh2
+Oct 18 10:00:09 web1 sshd[102]: Failed publickey for deploy from 198.51.100.7 port 5000 ssh2
+Oct 18 10:00:10 web1 sshd[103]: Accepted publickey for deploy from 198.51.100.7 port 5001 ssh2
+`
+	got, err := ParseFailedLogins(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FailedLogins{Total: 3, InvalidUsr: 1, RootTries: 1, Sources: []LoginSource{
+		{IP: "203.0.113.5", Count: 2},
+		{IP: "198.51.100.7", Count: 1},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseSecurityUpdates(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []string
+	}{
+		{
+			name: "apt",
+			out: `Listing...
+openssl/jammy-security 3.0.2-0ubuntu1.15 amd64 [upgradable from: 3.0.2-0ubuntu1.14]
+libssl3/jammy-security 3.0.2-0ubuntu1.15 amd64 [upgradable from: 3.0.2-0ubuntu1.14]
+`,
+			want: []string{"openssl", "libssl3"},
+		},
+		{
+			name: "dnf",
+			out: `Last metadata expiration check: 0:12:01 ago on Sat 18 Oct 2026.
+RHSA-2024:1234 Important/Sec. openssl-1:3.0.7-25.el9.x86_64
+`,
+			want: []string{"openssl-1:3.0.7-25.el9.x86_64"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecurityUpdates(nil, tt.out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkgs := got.(SecurityUpdates).Packages; !reflect.DeepEqual(pkgs, tt.want) {
+				t.Errorf("got %v, want %v", pkgs, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFirewallState(t *testing.T) {
+	out := `backend=ufw
+Status: active
+
+To                         Action      From
+--                         ------      ----
+22/tcp                     ALLOW       Anywhere
+80,443/tcp                 ALLOW       Anywhere
+`
+	got, err := ParseFirewallState(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FirewallState{Backend: "ufw", Active: true, Rules: []string{
+		"22/tcp                     ALLOW       Anywhere",
+		"80,443/tcp                 ALLOW       Anywhere",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if _, err := ParseFirewallState(nil, "Status: inactive\n"); err == nil {
+		t.Error("expected error without backend line")
+	}
+}
+
+func TestParseBackupList(t *testing.T) {
+	sum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	out := `{"id":"20261017T020000Z","site_path":"/var/www/html","created_at":"2026-10-17T02:00:00Z","files":[{"path":"/var/backups/wp/20261017T020000Z/files.tar.gz","kind":"files","size_bytes":1000,"sha256":"` + sum + `"}]}
+{"id":"20261018T020000Z-pre-update","site_path":"/var/www/html","created_at":"2026-10-18T02:00:00Z","size_bytes":5000,"files":[{"path":"/var/backups/wp/20261018T020000Z/db.sql.gz","kind":"database","size_bytes":200,"sha256":"` + sum + `"}]}
+`
+	got, err := ParseBackupList(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := got.(BackupList)
+	if len(list.Backups) != 2 || list.Backups[0].ID != "20261018T020000Z-pre-update" {
+		t.Fatalf("unexpected order: %+v", list.Backups)
+	}
+	if list.Backups[0].Total() != 5000 || list.Backups[1].Total() != 1000 {
+		t.Errorf("totals: %d, %d", list.Backups[0].Total(), list.Backups[1].Total())
+	}
+
+	bad := `{"id":"20261017T020000Z","site_path":"/var/www/html","files":[{"path":"relative.tar","sha256":"` + sum + `"}]}`
+	if _, err := ParseBackupManifest(nil, bad); err == nil {
+		t.Error("expected error for relative path")
+	}
+}
+
+func TestParseWPInventory(t *testing.T) {
+	out := `{
+  "core_version": "6.4.2",
+  "core_update": "6.6.1",
+  "plugins": [
+    {"name": "akismet", "status": "active", "version": "5.3", "update": "available", "update_version": "5.3.3"},
+    {"name": "contact-form-7", "status": "active", "version": "5.8", "update": "none", "vulnerabilities": ["Unrestricted file upload < 5.8.4"]},
+    {"name": "hello", "status": "inactive", "version": "1.7.2", "update": "none"}
+  ],
+  "themes": [
+    {"name": "twentytwentyfour", "status": "active", "version": "1.0", "update": "available"}
+  ]
+}`
+	got, err := ParseWPInventory([]string{"example.com"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := got.(WPInventory)
+	if inv.Site != "example.com" || inv.CoreVersion != "6.4.2" || inv.CoreUpdate != "6.6.1" {
+		t.Errorf("core: %+v", inv)
+	}
+	if want := []string{"akismet", "twentytwentyfour"}; !reflect.DeepEqual(inv.Outdated(), want) {
+		t.Errorf("outdated: got %v, want %v", inv.Outdated(), want)
+	}
+	if vuln := inv.Vulnerable(); len(vuln) != 1 || vuln[0].Name != "contact-form-7" {
+		t.Errorf("vulnerable: %+v", vuln)
+	}
+	if _, err := ParseWPInventory(nil, `{"plugins": []}`); err == nil {
+		t.Error("expected error without core_version")
+	}
+}
+
+func TestParseDatabaseList(t *testing.T) {
+	out := "information_schema\t0\nshop\t1048576\nblog\t2048\nmysql\t2506752\n"
+	got, err := ParseDatabaseList([]string{"mysql"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DatabaseList{Engine: "mysql", Databases: []Database{{Name: "shop", SizeBytes: 1048576}, {Name: "blog", SizeBytes: 2048}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	spec, _ := Lookup("db_create")
+	for _, args := range [][]string{
+		{"mysql", "shop", "shop_user"},
+		{"postgresql", "app_1", "app"},
+	} {
+		if err := spec.ValidateArgs(args); err != nil {
+			t.Errorf("%v: unexpected error %v", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"mysql", "shop; DROP DATABASE x", "u"},
+		{"mysql", "Shop", "u"},
+		{"mysql", "mysql", "u"},
+		{"postgresql", "app", "postgres"},
+		{"mongodb", "app", "u"},
+	} {
+		if err := spec.ValidateArgs(args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestParseCertificateList(t *testing.T) {
+	out := `[
+  {"name": "example.com", "domains": ["example.com", "www.example.com"], "not_after": "2026-12-20T00:00:00Z", "path": "/etc/letsencrypt/live/example.com/fullchain.pem"},
+  {"name": "shop.example.org", "domains": ["*.example.org"], "not_after": "2026-10-25T12:00:00Z", "path": "/etc/letsencrypt/live/shop.example.org/fullchain.pem"}
+]`
+	got, err := ParseCertificateList(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := got.(CertificateList)
+	if len(list.Certificates) != 2 || list.Certificates[0].Name != "shop.example.org" {
+		t.Fatalf("want soonest expiry first, got %+v", list.Certificates)
+	}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	if days := list.Certificates[0].DaysLeft(now); days != 6 {
+		t.Errorf("days left = %d, want 6", days)
+	}
+	if exp := list.Expiring(now, 21*24*time.Hour); len(exp) != 1 || exp[0].Name != "shop.example.org" {
+		t.Errorf("expiring: %+v", exp)
+	}
+	if c, ok := list.For("shop.example.org"); !ok || c.Name != "shop.example.org" {
+		t.Errorf("wildcard should cover shop.example.org, got %+v %v", c, ok)
+	}
+	if _, ok := list.For("a.b.example.org"); ok {
+		t.Error("wildcard should not cover two levels")
+	}
+	if _, err := ParseCertificateList(nil, `[{"name": "x", "domains": []}]`); err == nil {
+		t.Error("expected error for certificate without domains")
+	}
+}
+
+func TestFirewallRulesetApply(t *testing.T) {
+	out := `{"backend": "ufw", "active": true, "default_incoming": "deny", "ssh_port": 22, "agent_peer": "198.51.100.10",
+  "rules": [
+    {"action": "allow", "proto": "tcp", "port": "22"},
+    {"action": "allow", "proto": "tcp", "port": "80:443", "source": "any"}
+  ]}`
+	got, err := ParseFirewallRuleset(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := got.(FirewallRuleset)
+	if fw.Rules[0].Source != "any" || !fw.Allows("203.0.113.7", 443, "tcp") || fw.Allows("203.0.113.7", 3306, "tcp") {
+		t.Fatalf("unexpected ruleset %+v", fw)
+	}
+
+	tests := []struct {
+		name     string
+		task     string
+		args     []string
+		ip       string
+		port     int
+		allowed  bool
+		nRules   int
+		peerDeny bool
+	}{
+		{name: "open port", task: "firewall_open_port", args: []string{"3306"}, ip: "203.0.113.7", port: 3306, allowed: true, nRules: 3},
+		{name: "close ssh", task: "firewall_close_port", args: []string{"22"}, ip: "203.0.113.7", port: 22, allowed: false, nRules: 1},
+		{name: "deny ip goes first", task: "firewall_deny_ip", args: []string{"203.0.113.0/24"}, ip: "203.0.113.7", port: 22, allowed: false, nRules: 3},
+		{name: "deny gateway", task: "firewall_deny_ip", args: []string{"198.51.100.10"}, ip: "203.0.113.7", port: 22, allowed: true, nRules: 3, peerDeny: true},
+		{name: "allow ip to port", task: "firewall_allow_ip", args: []string{"192.0.2.5", "3306"}, ip: "192.0.2.5", port: 3306, allowed: true, nRules: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after, err := fw.Apply(tt.task, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := after.Allows(tt.ip, tt.port, "tcp"); got != tt.allowed {
+				t.Errorf("Allows(%s, %d) = %v, want %v", tt.ip, tt.port, got, tt.allowed)
+			}
+			if len(after.Rules) != tt.nRules {
+				t.Errorf("got %d rules, want %d: %v", len(after.Rules), tt.nRules, after.Rules)
+			}
+			if got := after.Denies(fw.AgentPeer); got != tt.peerDeny {
+				t.Errorf("Denies(agent peer) = %v, want %v", got, tt.peerDeny)
+			}
+		})
+	}
+	if len(fw.Rules) != 2 {
+		t.Errorf("Apply modified the original ruleset: %v", fw.Rules)
+	}
+}
+
+func TestDigestLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		log        string
+		out        string
+		errors     int
+		warnings   int
+		topCount   int
+		recent     string
+		statusCode map[string]int
+	}{
+		{
+			name: "nginx error log groups repeated upstream failures",
+			log:  "nginx_error",
+			out: `2024/01/02 10:11:12 [error] 1234#1234: *5 connect() failed (111: Connection refused) while connecting to upstream, client: 1.2.3.4, server: example.com
+2024/01/02 10:11:13 [error] 1234#1234: *6 connect() failed (111: Connection refused) while connecting to upstream, client: 5.6.7.8, server: example.com
+2024/01/02 10:11:14 [warn] 1234#1234: *7 an upstream response is buffered to a temporary file
+2024/01/02 10:11:15 [notice] 1#1: signal process started
+`,
+			errors:   2,
+			warnings: 1,
+			topCount: 2,
+			recent:   "2024/01/02 10:11:13 [error] 1234#1234: *6 connect() failed (111: Connection refused) while connecting to upstream, client: 5.6.7.8, server: example.com",
+		},
+		{
+			name: "access log counts 5xx as errors",
+			log:  "nginx_access",
+			out: `1.2.3.4 - - [02/Jan/2024:10:11:12 +0000] "GET / HTTP/1.1" 502 157 "-" "curl/8.0"
+1.2.3.5 - - [02/Jan/2024:10:11:13 +0000] "GET / HTTP/1.1" 502 157 "-" "curl/8.0"
+1.2.3.6 - - [02/Jan/2024:10:11:14 +0000] "GET /favicon.ico HTTP/1.1" 404 0 "-" "Mozilla/5.0"
+1.2.3.7 - - [02/Jan/2024:10:11:15 +0000] "GET / HTTP/1.1" 200 612 "-" "Mozilla/5.0"
+`,
+			errors:     2,
+			topCount:   2,
+			recent:     `1.2.3.5 - - [02/Jan/2024:10:11:13 +0000] "GET / HTTP/1.1" 502 157 "-" "curl/8.0"`,
+			statusCode: map[string]int{"2xx": 1, "4xx": 1, "5xx": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DigestLog(tt.log, tt.out)
+			if d.ErrorLines != tt.errors || d.WarningLines != tt.warnings {
+				t.Fatalf("errors/warnings = %d/%d, want %d/%d", d.ErrorLines, d.WarningLines, tt.errors, tt.warnings)
+			}
+			if len(d.Groups) == 0 || d.Groups[0].Count != tt.topCount {
+				t.Fatalf("top group = %+v, want count %d", d.Groups, tt.topCount)
+			}
+			if len(d.RecentFailures) == 0 || d.RecentFailures[0] != tt.recent {
+				t.Errorf("most recent failure = %v, want %q", d.RecentFailures, tt.recent)
+			}
+			if tt.statusCode != nil && !reflect.DeepEqual(d.StatusCounts, tt.statusCode) {
+				t.Errorf("status counts = %v, want %v", d.StatusCounts, tt.statusCode)
+			}
+		})
+	}
+}
+
+func TestParseOutputFallback(t *testing.T) {
+	if _, err := ParseOutput("install_wordpress", nil, "done"); err != ErrNoParser {
+		t.Errorf("expected ErrNoParser for task without parser, got %v", err)
+	}
+	if _, err := ParseOutput("check_diskspace", nil, ""); err == nil {
+		t.Error("expected error for empty output")
+	}
+	if _, err := ParseOutput("check_uptime", nil, "bash: uptime: command not found"); err == nil {
+		t.Error("expected error for unparseable output")
+	}
+}
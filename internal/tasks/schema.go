@@ -0,0 +1,171 @@
+// internal/tasks/schema.go
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Schema is the subset of JSON schema used to describe positional task args.
+// The top level is an array; prefixItems describe each position.
+type Schema struct {
+	Type        string   `json:"type"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	PrefixItems []Schema `json:"prefixItems,omitempty"`
+	Items       *Schema  `json:"items,omitempty"` // schema for args beyond prefixItems
+	MinItems    *int     `json:"minItems,omitempty"`
+	MaxItems    *int     `json:"maxItems,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	MinLength   *int     `json:"minLength,omitempty"`
+	MaxLength   *int     `json:"maxLength,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+	re          *regexp.Regexp
+}
+
+func parseSchema(raw json.RawMessage) (*Schema, error) {
+	if len(raw) == 0 {
+		// no schema: the task takes no args
+		zero := 0
+		return &Schema{Type: "array", MaxItems: &zero}, nil
+	}
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	if s.Type != "array" {
+		return nil, fmt.Errorf("top-level type must be array, got %q", s.Type)
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("bad pattern %q: %w", s.Pattern, err)
+		}
+		s.re = re
+	}
+	for i := range s.PrefixItems {
+		if err := s.PrefixItems[i].compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+func (s *Schema) minItems() int {
+	if s.MinItems != nil {
+		return *s.MinItems
+	}
+	return 0
+}
+
+func (s *Schema) validateArgs(args []string) error {
+	if len(args) < s.minItems() {
+		return fmt.Errorf("expected at least %d argument(s), got %d", s.minItems(), len(args))
+	}
+	maxItems := -1
+	if s.MaxItems != nil {
+		maxItems = *s.MaxItems
+	} else if s.Items == nil {
+		maxItems = len(s.PrefixItems)
+	}
+	if maxItems >= 0 && len(args) > maxItems {
+		return fmt.Errorf("expected at most %d argument(s), got %d", maxItems, len(args))
+	}
+
+	for i, a := range args {
+		item := s.Items
+		if i < len(s.PrefixItems) {
+			item = &s.PrefixItems[i]
+		}
+		if item == nil {
+			continue
+		}
+		if err := item.validateValue(a); err != nil {
+			name := item.Title
+			if name == "" {
+				name = fmt.Sprintf("argument %d", i+1)
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateValue(v string) error {
+	if v == "" {
+		return fmt.Errorf("must not be empty")
+	}
+
+	switch s.Type {
+	case "", "string":
+	case "integer":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		if err := s.checkRange(float64(n)); err != nil {
+			return err
+		}
+	case "number":
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		if err := s.checkRange(n); err != nil {
+			return err
+		}
+	case "boolean":
+		if v != "true" && v != "false" {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+	default:
+		return fmt.Errorf("unsupported schema type %q", s.Type)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if v == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%q is not one of %v", v, s.Enum)
+		}
+	}
+	if s.MinLength != nil && len(v) < *s.MinLength {
+		return fmt.Errorf("must be at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && len(v) > *s.MaxLength {
+		return fmt.Errorf("must be at most %d characters", *s.MaxLength)
+	}
+	if s.re != nil && !s.re.MatchString(v) {
+		return fmt.Errorf("%q has an invalid format", v)
+	}
+	return nil
+}
+
+func (s *Schema) checkRange(n float64) error {
+	if s.Minimum != nil && n < *s.Minimum {
+		return fmt.Errorf("must be >= %v", *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		return fmt.Errorf("must be <= %v", *s.Maximum)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+// internal/tasks/security.go
+package tasks
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Spec{
+		Name:           "check_ssh_config",
+		Description:    "show the effective SSH server settings such as root login and password authentication",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseSSHConfig,
+	})
+	Register(Spec{
+		Name:        "check_failed_logins",
+		Description: "count recent failed SSH login attempts and where they came from",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				{"title": "window", "description": "how far back, as a number followed by m, h or d (e.g. 30m, 6h, 1d)", "type": "string", "pattern": ` + jsonString(patternLogWindow) + `}
+			],
+			"maxItems": 1
+		}`),
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseFailedLogins,
+	})
+	Register(Spec{
+		Name:           "check_security_updates",
+		Description:    "list pending security updates for installed packages",
+		DefaultTimeout: 2 * time.Minute,
+		MaxTimeout:     5 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseSecurityUpdates,
+	})
+	Register(Spec{
+		Name:           "check_webroot_permissions",
+		Description:    "find world-writable files and directories under the web roots",
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     5 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseWritablePaths,
+	})
+	Register(Spec{
+		Name:           "check_firewall",
+		Description:    "show whether the firewall (ufw, firewalld or nftables) is active and its rules",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseFirewallState,
+	})
+}
+
+// SSHConfig holds the `sshd -T` settings the audit looks at
+type SSHConfig struct {
+	Port                   int    `json:"port"`
+	PermitRootLogin        string `json:"permit_root_login"`
+	PasswordAuthentication bool   `json:"password_authentication"`
+	PubkeyAuthentication   bool   `json:"pubkey_authentication"`
+	PermitEmptyPasswords   bool   `json:"permit_empty_passwords"`
+	MaxAuthTries           int    `json:"max_auth_tries"`
+	X11Forwarding          bool   `json:"x11_forwarding"`
+}
+
+// ParseSSHConfig parses `sshd -T` (lowercase "key value" lines).
+func ParseSSHConfig(_ []string, out string) (interface{}, error) {
+	cfg := SSHConfig{Port: 22}
+	found := false
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		val := strings.ToLower(fields[1])
+		switch strings.ToLower(fields[0]) {
+		case "port":
+			cfg.Port, _ = strconv.Atoi(val)
+		case "permitrootlogin":
+			cfg.PermitRootLogin = val
+		case "passwordauthentication":
+			cfg.PasswordAuthentication = val == "yes"
+		case "pubkeyauthentication":
+			cfg.PubkeyAuthentication = val == "yes"
+		case "permitemptypasswords":
+			cfg.PermitEmptyPasswords = val == "yes"
+		case "maxauthtries":
+			cfg.MaxAuthTries, _ = strconv.Atoi(val)
+		case "x11forwarding":
+			cfg.X11Forwarding = val == "yes"
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no sshd settings in output")
+	}
+	return cfg, nil
+}
+
+// LoginSource counts failed logins from one address
+type LoginSource struct {
+	IP    string `json:"ip"`
+	Count int    `json:"count"`
+}
+
+// FailedLogins is the parsed output of check_failed_logins
+type FailedLogins struct {
+	Total      int           `json:"total"`
+	InvalidUsr int           `json:"invalid_user"` // attempts for users that don't exist
+	RootTries  int           `json:"root"`
+	Sources    []LoginSource `json:"sources"` // most attempts first
+}
+
+var failedLoginLine = regexp.MustCompile(`Failed (?:password|publickey|none) for (invalid user )?(\S+) from (\S+)`)
+
+// ParseFailedLogins parses auth.log / journal sshd lines; output with no
+// failures is an empty result.
+func ParseFailedLogins(_ []string, out string) (interface{}, error) {
+	res := FailedLogins{Sources: []LoginSource{}}
+	counts := map[string]int{}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		m := failedLoginLine.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		res.Total++
+		if m[1] != "" {
+			res.InvalidUsr++
+		}
+		if m[2] == "root" {
+			res.RootTries++
+		}
+		counts[m[3]]++
+	}
+	for ip, n := range counts {
+		res.Sources = append(res.Sources, LoginSource{IP: ip, Count: n})
+	}
+	sort.Slice(res.Sources, func(i, j int) bool {
+		if res.Sources[i].Count != res.Sources[j].Count {
+			return res.Sources[i].Count > res.Sources[j].Count
+		}
+		return res.Sources[i].IP < res.Sources[j].IP
+	})
+	return res, nil
+}
+
+// SecurityUpdates is the parsed output of check_security_updates
+type SecurityUpdates struct {
+	Packages []string `json:"packages"`
+}
+
+// ParseSecurityUpdates accepts `apt list --upgradable` lines filtered to
+// -security pockets ("openssl/jammy-security 3.0.2 amd64 [...]") or
+// `dnf updateinfo list --security` lines ("RHSA-2024:1 Important/Sec. openssl-3.0.7").
+func ParseSecurityUpdates(_ []string, out string) (interface{}, error) {
+	res := SecurityUpdates{Packages: []string{}}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0, strings.HasPrefix(line, "Listing"), strings.HasPrefix(line, "Last metadata"):
+		case strings.Contains(fields[0], "/"):
+			res.Packages = append(res.Packages, fields[0][:strings.Index(fields[0], "/")])
+		case len(fields) >= 3 && strings.Contains(fields[1], "/Sec"):
+			res.Packages = append(res.Packages, fields[2])
+		}
+	}
+	return res, nil
+}
+
+// WritablePaths is the parsed output of check_webroot_permissions
+type WritablePaths struct {
+	Paths []string `json:"paths"`
+}
+
+// ParseWritablePaths takes one absolute path per line (find -perm -o+w).
+func ParseWritablePaths(_ []string, out string) (interface{}, error) {
+	res := WritablePaths{Paths: []string{}}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		if p := strings.TrimSpace(sc.Text()); strings.HasPrefix(p, "/") {
+			res.Paths = append(res.Paths, p)
+		}
+	}
+	return res, nil
+}
+
+// FirewallState is the parsed output of check_firewall
+type FirewallState struct {
+	Backend string   `json:"backend"` // ufw, firewalld, nftables or none
+	Active  bool     `json:"active"`
+	Rules   []string `json:"rules,omitempty"`
+}
+
+// ParseFirewallState expects a "backend=<name>" line followed by the
+// backend's own status output (ufw status, firewall-cmd --state and
+// --list-all, or nft list ruleset).
+func ParseFirewallState(_ []string, out string) (interface{}, error) {
+	var st FirewallState
+	inRules := false
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "backend=") {
+			st.Backend = strings.TrimPrefix(line, "backend=")
+			continue
+		}
+		switch st.Backend {
+		case "ufw":
+			switch {
+			case strings.HasPrefix(line, "Status:"):
+				st.Active = strings.TrimSpace(strings.TrimPrefix(line, "Status:")) == "active"
+			case strings.HasPrefix(line, "--"):
+				inRules = true
+			case inRules:
+				st.Rules = append(st.Rules, line)
+			}
+		case "firewalld":
+			switch {
+			case line == "running":
+				st.Active = true
+			case strings.HasPrefix(line, "services:"), strings.HasPrefix(line, "ports:"), strings.HasPrefix(line, "rich rules:"):
+				st.Rules = append(st.Rules, line)
+			}
+		case "nftables":
+			if strings.Contains(line, "hook input") {
+				st.Active = true
+			}
+			if strings.Contains(line, "accept") || strings.Contains(line, "drop") || strings.Contains(line, "reject") {
+				st.Rules = append(st.Rules, line)
+			}
+		}
+	}
+	if st.Backend == "" {
+		return nil, fmt.Errorf("firewall backend not reported")
+	}
+	return st, nil
+}
@@ -0,0 +1,216 @@
+// internal/tasks/services.go
+package tasks
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemd unit names: no leading dash so a name can never be read as a flag
+const patternServiceName = `^[A-Za-z0-9_][A-Za-z0-9@._-]{0,63}$`
+
+// well-known services customers may manage without the agent reporting them first
+var serviceAllowlist = regexp.MustCompile(`^(nginx|apache2|httpd|lsws|lshttpd|mysql|mysqld|mariadb|postgresql(@[0-9.]+-main)?|php([0-9.]+)?-fpm|redis|redis-server|memcached|docker|containerd|ssh|sshd|cron|crond|postfix|dovecot|exim4?|named|bind9|fail2ban|ufw|firewalld|supervisor|pm2-[a-z0-9_-]+|varnish|mongod|elasticsearch|rabbitmq-server)$`)
+
+var serviceArgSchema = schemaJSON(`{
+	"type": "array",
+	"prefixItems": [
+		{"title": "service", "description": "systemd service name, e.g. nginx, mysql, php8.2-fpm", "type": "string", "pattern": ` + jsonString(patternServiceName) + `}
+	],
+	"minItems": 1,
+	"maxItems": 1
+}`)
+
+func init() {
+	Register(Spec{
+		Name:           "list_services",
+		Description:    "list the systemd services on the server and whether they are running",
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          parseServiceListOutput,
+	})
+	Register(Spec{
+		Name:           "service_status",
+		Description:    "check whether a service such as nginx or mysql is running",
+		ArgsSchema:     serviceArgSchema,
+		DefaultTimeout: 30 * time.Second,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          parseServiceStatusOutput,
+	})
+	Register(Spec{
+		Name:           "service_restart",
+		Description:    "restart a service such as nginx or mysql",
+		ArgsSchema:     serviceArgSchema,
+		DefaultTimeout: 2 * time.Minute,
+		MaxTimeout:     5 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+	})
+	Register(Spec{
+		Name:           "service_stop",
+		Description:    "stop a running service",
+		ArgsSchema:     serviceArgSchema,
+		DefaultTimeout: 2 * time.Minute,
+		MaxTimeout:     5 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+	})
+	Register(Spec{
+		Name:           "service_reload",
+		Description:    "reload a service's configuration without restarting it",
+		ArgsSchema:     serviceArgSchema,
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     5 * time.Minute,
+		Risk:           RiskLow,
+	})
+	Register(Spec{
+		Name:           "service_enable",
+		Description:    "make a service start automatically at boot",
+		ArgsSchema:     serviceArgSchema,
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskLow,
+	})
+	Register(Spec{
+		Name:           "service_disable",
+		Description:    "stop a service from starting automatically at boot",
+		ArgsSchema:     serviceArgSchema,
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     2 * time.Minute,
+		Risk:           RiskLow,
+	})
+}
+
+// ServiceTasks are the tasks that take a service name as their only arg.
+var ServiceTasks = map[string]bool{
+	"service_status":  true,
+	"service_restart": true,
+	"service_stop":    true,
+	"service_reload":  true,
+	"service_enable":  true,
+	"service_disable": true,
+}
+
+// NormalizeServiceName strips the ".service" suffix and surrounding space.
+func NormalizeServiceName(name string) string {
+	return strings.TrimSuffix(strings.TrimSpace(name), ".service")
+}
+
+// IsAllowlistedService reports whether name is a well-known manageable service.
+func IsAllowlistedService(name string) bool {
+	return serviceAllowlist.MatchString(NormalizeServiceName(name))
+}
+
+// ServiceStatus is the structured form of `systemctl status <unit>`
+type ServiceStatus struct {
+	Unit        string   `json:"unit"`
+	Description string   `json:"description,omitempty"`
+	LoadState   string   `json:"load_state"`          // loaded, not-found, masked
+	UnitFile    string   `json:"unit_file,omitempty"` // enabled, disabled, static...
+	ActiveState string   `json:"active_state"`        // active, inactive, failed...
+	SubState    string   `json:"sub_state,omitempty"` // running, dead, exited...
+	Since       string   `json:"since,omitempty"`     // as printed by systemctl
+	MainPID     int      `json:"main_pid,omitempty"`
+	Tasks       int      `json:"tasks,omitempty"`
+	Memory      string   `json:"memory,omitempty"`
+	RecentLogs  []string `json:"recent_logs,omitempty"` // journal lines printed under the status
+}
+
+// Running reports whether the unit is active and running.
+func (s ServiceStatus) Running() bool {
+	return s.ActiveState == "active" && (s.SubState == "" || s.SubState == "running")
+}
+
+var (
+	statusHeader = regexp.MustCompile(`^[●○×*]?\s*(\S+?)(?:\.service)?\s+-\s+(.*)$`)
+	loadedLine   = regexp.MustCompile(`^Loaded:\s+(\S+)(?:\s+\(([^;)]*)(?:;\s*([^;)]*))?.*\))?`)
+	activeLine   = regexp.MustCompile(`^Active:\s+(\S+)(?:\s+\(([^)]*)\))?(?:\s+since\s+([^;]+))?`)
+	mainPIDLine  = regexp.MustCompile(`^Main PID:\s+(\d+)`)
+	tasksLine    = regexp.MustCompile(`^Tasks:\s+(\d+)`)
+	memoryLine   = regexp.MustCompile(`^Memory:\s+(\S+)`)
+	notFoundLine = regexp.MustCompile(`Unit (\S+?)(?:\.service)? could not be found`)
+)
+
+// ParseServiceStatus parses `systemctl status` output. ok is false when the
+// output doesn't look like a status report at all.
+func ParseServiceStatus(out string) (ServiceStatus, bool) {
+	var st ServiceStatus
+	if m := notFoundLine.FindStringSubmatch(out); m != nil {
+		return ServiceStatus{Unit: m[1], LoadState: "not-found", ActiveState: "inactive"}, true
+	}
+
+	inLogs := false
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			// journal excerpt follows the first blank line
+			if st.Unit != "" {
+				inLogs = true
+			}
+			continue
+		}
+		if inLogs {
+			st.RecentLogs = append(st.RecentLogs, line)
+			continue
+		}
+
+		switch {
+		case st.Unit == "" && statusHeader.MatchString(line):
+			m := statusHeader.FindStringSubmatch(line)
+			st.Unit, st.Description = m[1], m[2]
+		case loadedLine.MatchString(line):
+			m := loadedLine.FindStringSubmatch(line)
+			st.LoadState = m[1]
+			st.UnitFile = strings.TrimSpace(m[3])
+		case activeLine.MatchString(line):
+			m := activeLine.FindStringSubmatch(line)
+			st.ActiveState, st.SubState, st.Since = m[1], m[2], strings.TrimSpace(m[3])
+		case mainPIDLine.MatchString(line):
+			st.MainPID, _ = strconv.Atoi(mainPIDLine.FindStringSubmatch(line)[1])
+		case tasksLine.MatchString(line):
+			st.Tasks, _ = strconv.Atoi(tasksLine.FindStringSubmatch(line)[1])
+		case memoryLine.MatchString(line):
+			st.Memory = memoryLine.FindStringSubmatch(line)[1]
+		}
+	}
+	if st.ActiveState == "" {
+		return ServiceStatus{}, false
+	}
+	return st, true
+}
+
+// ServiceUnit is one row of `systemctl list-units --type=service --no-legend --plain`
+type ServiceUnit struct {
+	Unit        string `json:"unit"`
+	Load        string `json:"load"`
+	Active      string `json:"active"`
+	Sub         string `json:"sub"`
+	Description string `json:"description,omitempty"`
+}
+
+// ParseServiceList parses list-units output; unparseable lines are skipped.
+func ParseServiceList(out string) []ServiceUnit {
+	var units []ServiceUnit
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(strings.TrimLeft(sc.Text(), "●* "))
+		if len(fields) < 4 || !strings.HasSuffix(fields[0], ".service") {
+			continue
+		}
+		units = append(units, ServiceUnit{
+			Unit:        strings.TrimSuffix(fields[0], ".service"),
+			Load:        fields[1],
+			Active:      fields[2],
+			Sub:         fields[3],
+			Description: strings.Join(fields[4:], " "),
+		})
+	}
+	return units
+}
@@ -0,0 +1,243 @@
+// internal/tasks/wordpress.go
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// a WordPress site is addressed by its directory or by a domain the agent
+	// maps to a document root; the bare root directory is not a site
+	patternWPSite = `^((/[A-Za-z0-9_-][A-Za-z0-9._-]*)+/?|([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63})$`
+	// plugin and theme slugs as used by wordpress.org
+	patternWPSlug = `^[a-z0-9][a-z0-9_-]{0,99}$`
+	// login names wp-cli accepts without quoting
+	patternWPUser = `^[A-Za-z0-9_.@-]{1,60}$`
+)
+
+// WordPressTasks are the wp-cli based management tasks
+var WordPressTasks = map[string]bool{}
+
+func init() {
+	site := `{"title": "site", "description": "WordPress site path (e.g. /var/www/html) or domain (e.g. example.com)", "type": "string", "pattern": ` + jsonString(patternWPSite) + `}`
+	siteOnly := schemaJSON(`{"type": "array", "prefixItems": [` + site + `], "minItems": 1, "maxItems": 1}`)
+
+	register := func(s Spec) {
+		Register(s)
+		WordPressTasks[s.Name] = true
+	}
+
+	register(Spec{
+		Name:           "wp_inventory",
+		Description:    "show a WordPress site's core version and its plugins and themes with versions, available updates and known vulnerabilities",
+		ArgsSchema:     siteOnly,
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskReadOnly,
+		Parse:          ParseWPInventory,
+	})
+	register(Spec{
+		Name:           "wp_core_update",
+		Description:    "update WordPress core to the latest version",
+		ArgsSchema:     siteOnly,
+		DefaultTimeout: 5 * time.Minute,
+		MaxTimeout:     15 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Parse:          ParseWPUpdates,
+	})
+	register(Spec{
+		Name:        "wp_plugin_update",
+		Description: "update one WordPress plugin, or all plugins",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + site + `,
+				{"title": "plugin", "description": "plugin slug, or all", "type": "string", "pattern": ` + jsonString(patternWPSlug) + `}
+			],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: 5 * time.Minute,
+		MaxTimeout:     15 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+		Parse:          ParseWPUpdates,
+	})
+	register(Spec{
+		Name:        "wp_plugin_deactivate",
+		Description: "deactivate a WordPress plugin",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + site + `,
+				{"title": "plugin", "description": "plugin slug", "type": "string", "pattern": ` + jsonString(patternWPSlug) + `}
+			],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+	})
+	register(Spec{
+		Name:        "wp_reset_password",
+		Description: "reset a WordPress user's password; WordPress emails the user a reset link",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + site + `,
+				{"title": "user", "description": "login name or email of the WordPress user", "type": "string", "pattern": ` + jsonString(patternWPUser) + `}
+			],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskHigh,
+		Confirm:        true,
+	})
+	register(Spec{
+		Name:        "wp_maintenance_mode",
+		Description: "turn WordPress maintenance mode on or off, or show whether it is on",
+		ArgsSchema: schemaJSON(`{
+			"type": "array",
+			"prefixItems": [
+				` + site + `,
+				{"title": "mode", "description": "on, off or status", "type": "string", "enum": ["on", "off", "status"]}
+			],
+			"minItems": 2,
+			"maxItems": 2
+		}`),
+		DefaultTimeout: time.Minute,
+		MaxTimeout:     3 * time.Minute,
+		Risk:           RiskLow,
+	})
+}
+
+// WPComponent is a plugin or theme as reported by wp-cli
+type WPComponent struct {
+	Name            string   `json:"name"`
+	Title           string   `json:"title,omitempty"`
+	Status          string   `json:"status"` // active, inactive, must-use, parent...
+	Version         string   `json:"version"`
+	UpdateVersion   string   `json:"update_version,omitempty"`
+	Vulnerabilities []string `json:"vulnerabilities,omitempty"` // advisory titles reported by the agent
+	Outdated        bool     `json:"outdated"`
+	Vulnerable      bool     `json:"vulnerable"`
+}
+
+// WPInventory is the parsed output of wp_inventory
+type WPInventory struct {
+	Site              string        `json:"site"`
+	CoreVersion       string        `json:"core_version"`
+	CoreUpdate        string        `json:"core_update,omitempty"`
+	CoreVulnerable    bool          `json:"core_vulnerable"`
+	Plugins           []WPComponent `json:"plugins"`
+	Themes            []WPComponent `json:"themes"`
+	MaintenanceActive bool          `json:"maintenance_active"`
+}
+
+// Outdated returns the names of plugins and themes with an update available.
+func (inv WPInventory) Outdated() []string {
+	var out []string
+	for _, c := range append(append([]WPComponent(nil), inv.Plugins...), inv.Themes...) {
+		if c.Outdated {
+			out = append(out, c.Name)
+		}
+	}
+	return out
+}
+
+// Vulnerable returns the plugins and themes with known vulnerabilities.
+func (inv WPInventory) Vulnerable() []WPComponent {
+	var out []WPComponent
+	for _, c := range append(append([]WPComponent(nil), inv.Plugins...), inv.Themes...) {
+		if c.Vulnerable {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ParseWPInventory reads the agent's JSON inventory and derives the
+// outdated/vulnerable flags; "update" follows wp-cli's plugin list column
+// ("available" or "none") when update_version is missing.
+func ParseWPInventory(args []string, out string) (interface{}, error) {
+	var raw struct {
+		WPInventory
+		CoreVulnerabilities []string `json:"core_vulnerabilities"`
+		Plugins             []struct {
+			WPComponent
+			Update string `json:"update"`
+		} `json:"plugins"`
+		Themes []struct {
+			WPComponent
+			Update string `json:"update"`
+		} `json:"themes"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &raw); err != nil {
+		return nil, fmt.Errorf("invalid WordPress inventory: %w", err)
+	}
+	if raw.CoreVersion == "" {
+		return nil, fmt.Errorf("inventory missing core_version")
+	}
+
+	inv := raw.WPInventory
+	if inv.Site == "" && len(args) > 0 {
+		inv.Site = args[0]
+	}
+	inv.CoreVulnerable = inv.CoreVulnerable || len(raw.CoreVulnerabilities) > 0
+	inv.Plugins = make([]WPComponent, 0, len(raw.Plugins))
+	for _, p := range raw.Plugins {
+		inv.Plugins = append(inv.Plugins, wpFlags(p.WPComponent, p.Update))
+	}
+	inv.Themes = make([]WPComponent, 0, len(raw.Themes))
+	for _, t := range raw.Themes {
+		inv.Themes = append(inv.Themes, wpFlags(t.WPComponent, t.Update))
+	}
+	return inv, nil
+}
+
+func wpFlags(c WPComponent, update string) WPComponent {
+	c.Outdated = (c.UpdateVersion != "" && c.UpdateVersion != c.Version) || update == "available"
+	c.Vulnerable = len(c.Vulnerabilities) > 0
+	return c
+}
+
+// WPUpdate is one row of `wp core update` / `wp plugin update --format=json`
+type WPUpdate struct {
+	Name       string `json:"name"`
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+	Status     string `json:"status"` // Updated, Error, Up to date...
+}
+
+// WPUpdates is the parsed output of the update tasks
+type WPUpdates struct {
+	Updates []WPUpdate `json:"updates"`
+}
+
+// Failed returns the rows whose update didn't succeed.
+func (u WPUpdates) Failed() []WPUpdate {
+	var out []WPUpdate
+	for _, r := range u.Updates {
+		if r.Status != "Updated" && r.Status != "Up to date" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func ParseWPUpdates(_ []string, out string) (interface{}, error) {
+	var u WPUpdates
+	out = strings.TrimSpace(out)
+	if err := json.Unmarshal([]byte(out), &u.Updates); err != nil {
+		return nil, fmt.Errorf("invalid update result: %w", err)
+	}
+	return u, nil
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -28,16 +29,16 @@ var upgrader = ws.Upgrader{
 type AgentConn struct {
 	Conn                 *ws.Conn
 	IdentityToken        string
+	VPSID                string
+	Generation           uint64 // increases with every connection; see registerConn
+	ConnectedAt          time.Time
 	LastHeartbeatCounter uint64
 	LastSeen             time.Time
+	Hello                AgentHello    // set before the connection is registered
+	PingRTT              time.Duration // round trip of the last answered ping
 	mu                   sync.Mutex
 }
 
-var (
-	connectedMtx sync.RWMutex
-	ConnectedVPS = make(map[string]*AgentConn) // identityToken -> AgentConn
-)
-
 const (
 	readTimeout  = 60 * time.Second
 	writeTimeout = 15 * time.Second
@@ -51,6 +52,12 @@ func HandleAgentWebSocket(c *gin.Context) {
 		return
 	}
 
+	// a draining node sends agents elsewhere
+	if Draining() {
+		c.String(http.StatusServiceUnavailable, "gateway draining")
+		return
+	}
+
 	clientCert := c.Request.TLS.PeerCertificates[0]
 	cn := clientCert.Subject.CommonName
 
@@ -83,19 +90,32 @@ func HandleAgentWebSocket(c *gin.Context) {
 		return
 	}
 
+	// the agent must introduce itself before anything is sent to it
+	hello, code, err := readHello(conn, keyInfo)
+	if err != nil {
+		log.Printf("agent %s refused: %v", cn, err)
+		conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(code, closeReason(err)), time.Now().Add(writeTimeout))
+		conn.Close()
+		return
+	}
+
 	// Build AgentConn and register
-	agentConn := &AgentConn{Conn: conn, IdentityToken: keyInfo.IdentityToken, LastSeen: time.Now()}
-	connectedMtx.Lock()
-	ConnectedVPS[keyInfo.IdentityToken] = agentConn
-	connectedMtx.Unlock()
+	now := time.Now()
+	agentConn := &AgentConn{Conn: conn, IdentityToken: keyInfo.IdentityToken, VPSID: vpsIDFromCN(cn), ConnectedAt: now, LastSeen: now, Hello: hello}
+	registerConn(agentConn)
+	go deliverQueued(agentConn)
 
 	// Setup ping/pong and deadlines
 	conn.SetReadLimit(1024 * 1024)
 	conn.SetReadDeadline(time.Now().Add(pongWait))
-	conn.SetPongHandler(func(string) error {
+	conn.SetPongHandler(func(appData string) error {
 		conn.SetReadDeadline(time.Now().Add(pongWait))
 		agentConn.mu.Lock()
 		agentConn.LastSeen = time.Now()
+		// pongs echo the ping's send time
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			agentConn.PingRTT = time.Since(time.Unix(0, sent))
+		}
 		agentConn.mu.Unlock()
 		return nil
 	})
@@ -105,7 +125,8 @@ func HandleAgentWebSocket(c *gin.Context) {
 	// Ping loop (server -> client)
 	go handleAgentPingLoop(agentConn)
 
-	log.Printf("Agent connected: CN=%s, IdentityToken=%s", cn, keyInfo.IdentityToken)
+	log.Printf("Agent connected: CN=%s, IdentityToken=%s, version=%s, protocol=%d, %s %s %s", cn, keyInfo.IdentityToken,
+		hello.AgentVersion, hello.ProtocolVersion, hello.Distro, hello.DistroVersion, hello.Arch)
 }
 
 func handleAgentPingLoop(a *AgentConn) {
@@ -117,7 +138,7 @@ func handleAgentPingLoop(a *AgentConn) {
 	for range ticker.C {
 		a.mu.Lock()
 		a.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-		if err := a.Conn.WriteMessage(ws.PingMessage, nil); err != nil {
+		if err := a.Conn.WriteMessage(ws.PingMessage, []byte(strconv.FormatInt(time.Now().UnixNano(), 10))); err != nil {
 			a.mu.Unlock()
 			return
 		}
@@ -168,12 +189,12 @@ func handleAgentPingLoop(a *AgentConn) {
 
 func handleAgentReadLoop(a *AgentConn, keyInfo utils.AgentKeys) {
 	defer func() {
-		connectedMtx.Lock()
-		delete(ConnectedVPS, a.IdentityToken)
-		connectedMtx.Unlock()
+		// a superseded connection leaves the registry and the newer
+		// connection's tasks alone
+		unregisterConn(a)
 
-		// fail all pending tasks intended for this agent so waiters don't hang
-		failPendingForAgent(keyInfo.IdentityToken, "connection closed")
+		// fail the tasks sent on this connection so waiters don't hang
+		failPendingForAgent(keyInfo.IdentityToken, a.Generation, "connection closed")
 		a.Conn.Close()
 	}()
 
@@ -195,7 +216,7 @@ func handleAgentReadLoop(a *AgentConn, keyInfo utils.AgentKeys) {
 					switch t {
 					case "heartbeat":
 						// existing heartbeat verification
-						if err := verifyHeartbeat(msg, keyInfo); err != nil {
+						if err := verifyHeartbeat(msg, keyInfo, a); err != nil {
 							log.Printf("heartbeat verification failed: %v", err)
 							return
 						}
@@ -210,6 +231,12 @@ func handleAgentReadLoop(a *AgentConn, keyInfo utils.AgentKeys) {
 							log.Printf("invalid task_result format from %s: %v", keyInfo.IdentityToken, err)
 							continue
 						}
+						if err := verifyTaskResult(tr, keyInfo, a); err != nil {
+							recordAudit(AuditEvent{Kind: "task_result_rejected", VPSID: a.VPSID, AgentIdentity: keyInfo.IdentityToken, TaskID: tr.TaskID, Reason: err.Error()})
+							continue
+						}
+						tr = completeFromStream(tr)
+						recordTaskResult(tr)
 						if resolved := resolvePending(tr.TaskID, tr); resolved {
 							log.Printf("resolved pending task %s for agent %s", tr.TaskID, keyInfo.IdentityToken)
 						} else {
@@ -217,6 +244,12 @@ func handleAgentReadLoop(a *AgentConn, keyInfo utils.AgentKeys) {
 						}
 						continue
 
+					case "task_output", "task_progress":
+						if err := handleStreamChunk(a, msg); err != nil {
+							log.Printf("invalid %s from %s: %v", t, keyInfo.IdentityToken, err)
+						}
+						continue
+
 					default:
 						// unknown message type; fallthrough to general log
 					}
@@ -229,7 +262,7 @@ func handleAgentReadLoop(a *AgentConn, keyInfo utils.AgentKeys) {
 }
 
 // verifyHeartbeat parses heartbeat JSON, checks signature using keyInfo.SignatureSecret
-func verifyHeartbeat(msg []byte, keyInfo utils.AgentKeys) error {
+func verifyHeartbeat(msg []byte, keyInfo utils.AgentKeys, aConn *AgentConn) error {
 	type hb struct {
 		Type      string `json:"type"`
 		Version   int    `json:"version"`
@@ -263,7 +296,7 @@ func verifyHeartbeat(msg []byte, keyInfo utils.AgentKeys) error {
 	}
 
 	// Rebuild canonical string
-	canon := fmt.Sprintf("%s|%s|%d|%s|%s", h.Version, h.AgentID, h.Counter, h.Nonce, h.Timestamp)
+	canon := fmt.Sprintf("%d|%s|%d|%s|%s", h.Version, h.AgentID, h.Counter, h.Nonce, h.Timestamp)
 	// verify HMAC
 	expected := utils.HMACSHA256Base64([]byte(keyInfo.SignatureSecret), canon)
 	if expected != h.Signature {
@@ -271,15 +304,12 @@ func verifyHeartbeat(msg []byte, keyInfo utils.AgentKeys) error {
 	}
 
 	// TODO: store/compare counter to prevent replay. For demo, accept increasing counter check:
-	// You can persist lastCounter per agent (DB) in production. Here we keep in-memory in ConnectedVPS.
-	connectedMtx.RLock()
-	aConn, ok := ConnectedVPS[keyInfo.IdentityToken]
-	connectedMtx.RUnlock()
-	if ok {
-		if h.Counter <= aConn.LastHeartbeatCounter {
-			return errors.New("replay or old counter")
-		}
-		aConn.LastHeartbeatCounter = h.Counter
+	// You can persist lastCounter per agent (DB) in production. Here we keep it in memory on the connection.
+	aConn.mu.Lock()
+	defer aConn.mu.Unlock()
+	if h.Counter <= aConn.LastHeartbeatCounter {
+		return errors.New("replay or old counter")
 	}
+	aConn.LastHeartbeatCounter = h.Counter
 	return nil
 }
@@ -0,0 +1,303 @@
+// internal/websocket/broker.go
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+	"ultahost-ai-gateway/internal/tasks"
+
+	"github.com/google/uuid"
+)
+
+// extra time a forwarding node waits beyond the task's timeout, for the
+// owning node's own timeout to come back first
+const forwardGrace = 10 * time.Second
+
+// how long an owner lookup that found no agent is trusted
+const ownerMissTTL = 5 * time.Second
+
+// AgentOwner is the node holding an agent's connection
+type AgentOwner struct {
+	Node       string `json:"node"`
+	URL        string `json:"url,omitempty"` // where other nodes reach it; NetBroker only
+	Generation uint64 `json:"generation"`
+}
+
+// DispatchRequest is a task forwarded to the node that owns the agent
+type DispatchRequest struct {
+	VPSID   string        `json:"vps_id"`
+	Task    string        `json:"task"`
+	Args    []string      `json:"args,omitempty"`
+	Timeout time.Duration `json:"timeout"`
+	Origin  string        `json:"origin"` // forwarding node, for logs
+
+	// TaskID is the forwarding node's id for the task, so both nodes
+	// record it under one id; empty picks a new one.
+	TaskID string `json:"task_id,omitempty"`
+	// Cancel asks the owning node to cancel TaskID rather than run a task.
+	Cancel bool `json:"cancel,omitempty"`
+}
+
+// DispatchFunc runs a forwarded task on the node that owns the agent.
+type DispatchFunc func(ctx context.Context, req DispatchRequest) (TaskResult, error)
+
+// Broker shares agent ownership between gateway nodes and carries task
+// dispatches, and their results, to the node that holds the connection.
+type Broker interface {
+	// Node is this node's name.
+	Node() string
+	// ClaimAgent records this node as the owner of vpsId's connection.
+	ClaimAgent(vpsId string, generation uint64) error
+	// ReleaseAgent forgets the ownership if this node still holds it with
+	// generation; a newer connection elsewhere is left alone.
+	ReleaseAgent(vpsId string, generation uint64) error
+	// AgentOwner looks up the node holding vpsId's connection.
+	AgentOwner(vpsId string) (AgentOwner, bool, error)
+	// Agents returns the owner of every connected agent, by VPS id.
+	Agents() (map[string]AgentOwner, error)
+	// Dispatch runs req on owner and waits for the result.
+	Dispatch(ctx context.Context, owner AgentOwner, req DispatchRequest) (TaskResult, error)
+	// Serve sets the function that runs dispatches sent to this node.
+	Serve(fn DispatchFunc)
+}
+
+var (
+	brokerMtx sync.RWMutex
+	broker    Broker = NewMemoryBroker("local")
+
+	ownerMissMtx sync.Mutex
+	ownerMisses  = map[string]time.Time{} // vpsId -> when to look it up again
+)
+
+// StartBroker picks the broker from the config: a NetBroker when a
+// registry is configured, otherwise a single-node MemoryBroker.
+func StartBroker() {
+	cfg := config.AppConfig
+	if cfg == nil || cfg.BrokerRegistryURL == "" {
+		node := "local"
+		if cfg != nil && cfg.NodeID != "" {
+			node = cfg.NodeID
+		}
+		SetBroker(NewMemoryBroker(node))
+		return
+	}
+	SetBroker(NewNetBroker(cfg.NodeID, cfg.NodeURL, cfg.BrokerRegistryURL, cfg.BrokerSecret))
+	log.Printf("broker: node %s (%s) using registry %s", cfg.NodeID, cfg.NodeURL, cfg.BrokerRegistryURL)
+}
+
+// SetBroker replaces the broker and has it serve dispatches to this node.
+func SetBroker(b Broker) {
+	b.Serve(serveDispatch)
+	brokerMtx.Lock()
+	broker = b
+	brokerMtx.Unlock()
+
+	ownerMissMtx.Lock()
+	clear(ownerMisses)
+	ownerMissMtx.Unlock()
+}
+
+func currentBroker() Broker {
+	brokerMtx.RLock()
+	defer brokerMtx.RUnlock()
+	return broker
+}
+
+func claimAgent(a *AgentConn) {
+	if err := currentBroker().ClaimAgent(a.VPSID, a.Generation); err != nil {
+		log.Printf("broker: claim %s (generation %d): %v", a.VPSID, a.Generation, err)
+	}
+}
+
+func releaseAgent(a *AgentConn) {
+	if err := currentBroker().ReleaseAgent(a.VPSID, a.Generation); err != nil {
+		log.Printf("broker: release %s (generation %d): %v", a.VPSID, a.Generation, err)
+	}
+}
+
+// remoteOwner returns the other node holding vpsId's connection, if any.
+// A lookup that finds no owner, or fails, is remembered for ownerMissTTL so
+// tasks for an offline agent don't each wait on the registry.
+func remoteOwner(vpsId string) (AgentOwner, bool) {
+	now := time.Now()
+	ownerMissMtx.Lock()
+	retry, missed := ownerMisses[vpsId]
+	ownerMissMtx.Unlock()
+	if missed && now.Before(retry) {
+		return AgentOwner{}, false
+	}
+
+	b := currentBroker()
+	owner, ok, err := b.AgentOwner(vpsId)
+	if err != nil {
+		log.Printf("broker: owner of %s: %v", vpsId, err)
+	}
+	ownerMissMtx.Lock()
+	defer ownerMissMtx.Unlock()
+	if err != nil || !ok {
+		for id, t := range ownerMisses {
+			if now.After(t) {
+				delete(ownerMisses, id)
+			}
+		}
+		ownerMisses[vpsId] = now.Add(ownerMissTTL)
+		return AgentOwner{}, false
+	}
+	delete(ownerMisses, vpsId)
+	return owner, owner.Node != b.Node()
+}
+
+// forwardTask runs a task on the node that owns the agent. The task is
+// recorded here too, under the same id, so it shows up, and can be
+// cancelled, on whichever node was asked; its live output stays on the
+// owning node.
+func forwardTask(ctx context.Context, owner AgentOwner, vpsId, taskID, task string, args []string, timeout time.Duration) (TaskResult, error) {
+	if err := tasks.ValidateArgs(task, args); err != nil {
+		return TaskResult{}, err
+	}
+	timeout = tasks.Timeout(task, timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout+forwardGrace)
+	defer cancel()
+
+	b := currentBroker()
+	recordTaskSent(vpsId, taskID, task, args)
+	res, err := b.Dispatch(ctx, owner, DispatchRequest{VPSID: vpsId, Task: task, Args: args, Timeout: timeout, Origin: b.Node(), TaskID: taskID})
+	if err != nil {
+		err = fmt.Errorf("via node %s: %w", owner.Node, err)
+		status := TaskStatusFailed
+		if rec, _ := GetTask(taskID); !rec.CancelRequestedAt.IsZero() {
+			status = TaskStatusCancelled
+		}
+		transitionTask(taskID, TaskStatusRunning, status, err.Error())
+		return TaskResult{}, err
+	}
+	res.TaskID = taskID
+	recordTaskResult(res)
+	return res, nil
+}
+
+// forwardInBackground is forwardTask for callers that don't wait for the
+// result; it arrives through the task store.
+func forwardInBackground(owner AgentOwner, vpsId, taskID, task string, args []string) {
+	recordTaskSent(vpsId, taskID, task, args)
+	go func() {
+		if _, err := forwardTask(context.Background(), owner, vpsId, taskID, task, args, 0); err != nil {
+			log.Printf("task %s (%s) on %s: %v", taskID, task, vpsId, err)
+		}
+	}()
+}
+
+// cancelRemote asks the node running taskID to cancel it.
+func cancelRemote(owner AgentOwner, rec TaskRecord) error {
+	b := currentBroker()
+	ctx, cancel := context.WithTimeout(context.Background(), forwardGrace)
+	defer cancel()
+	// marked first: the forwarded task can end as soon as the cancel lands
+	markCancelRequested(rec.TaskID)
+	if _, err := b.Dispatch(ctx, owner, DispatchRequest{VPSID: rec.VPSID, TaskID: rec.TaskID, Cancel: true, Origin: b.Node()}); err != nil {
+		return fmt.Errorf("via node %s: %w", owner.Node, err)
+	}
+	return nil
+}
+
+// serveDispatch runs, or cancels, a task forwarded from another node. Only
+// an agent connected here is used, so a stale owner record can't bounce a
+// task between nodes.
+func serveDispatch(ctx context.Context, req DispatchRequest) (TaskResult, error) {
+	if _, ok := AgentByVPS(req.VPSID); !ok {
+		return TaskResult{}, fmt.Errorf("agent %s is not connected to node %s", req.VPSID, currentBroker().Node())
+	}
+	if req.Cancel {
+		if rec, ok := GetTask(req.TaskID); !ok || rec.VPSID != req.VPSID {
+			return TaskResult{}, fmt.Errorf("unknown task %s", req.TaskID)
+		}
+		log.Printf("broker: cancelling %s on %s for node %s", req.TaskID, req.VPSID, req.Origin)
+		return TaskResult{TaskID: req.TaskID}, CancelTask(req.TaskID)
+	}
+	taskID := req.TaskID
+	if taskID == "" {
+		taskID = uuid.NewString()
+	}
+	log.Printf("broker: running %s on %s for node %s", req.Task, req.VPSID, req.Origin)
+	return sendAndWait(ctx, req.VPSID, taskID, req.Task, req.Args, req.Timeout)
+}
+
+// MemoryBroker keeps ownership in process memory. On its own it serves a
+// single node; brokers made with Peer share its state, which runs several
+// nodes in one process.
+type MemoryBroker struct {
+	node   string
+	shared *memoryShared
+}
+
+type memoryShared struct {
+	mu     sync.Mutex
+	owners map[string]AgentOwner   // vpsId -> owner
+	nodes  map[string]DispatchFunc // node -> dispatch handler
+}
+
+func NewMemoryBroker(node string) *MemoryBroker {
+	return &MemoryBroker{node: node, shared: &memoryShared{owners: map[string]AgentOwner{}, nodes: map[string]DispatchFunc{}}}
+}
+
+// Peer returns a broker for another node sharing m's state.
+func (m *MemoryBroker) Peer(node string) *MemoryBroker {
+	return &MemoryBroker{node: node, shared: m.shared}
+}
+
+func (m *MemoryBroker) Node() string { return m.node }
+
+func (m *MemoryBroker) ClaimAgent(vpsId string, generation uint64) error {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	m.shared.owners[vpsId] = AgentOwner{Node: m.node, Generation: generation}
+	return nil
+}
+
+func (m *MemoryBroker) ReleaseAgent(vpsId string, generation uint64) error {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	if cur, ok := m.shared.owners[vpsId]; ok && cur.Node == m.node && cur.Generation == generation {
+		delete(m.shared.owners, vpsId)
+	}
+	return nil
+}
+
+func (m *MemoryBroker) AgentOwner(vpsId string) (AgentOwner, bool, error) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	owner, ok := m.shared.owners[vpsId]
+	return owner, ok, nil
+}
+
+func (m *MemoryBroker) Agents() (map[string]AgentOwner, error) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	out := make(map[string]AgentOwner, len(m.shared.owners))
+	for vpsId, owner := range m.shared.owners {
+		out[vpsId] = owner
+	}
+	return out, nil
+}
+
+func (m *MemoryBroker) Dispatch(ctx context.Context, owner AgentOwner, req DispatchRequest) (TaskResult, error) {
+	m.shared.mu.Lock()
+	fn, ok := m.shared.nodes[owner.Node]
+	m.shared.mu.Unlock()
+	if !ok {
+		return TaskResult{}, errors.New("node is not serving")
+	}
+	return fn(ctx, req)
+}
+
+func (m *MemoryBroker) Serve(fn DispatchFunc) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+	m.shared.nodes[m.node] = fn
+}
@@ -0,0 +1,258 @@
+// internal/websocket/broker_net.go
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NetBroker shares ownership through a registry service over HTTP and
+// sends dispatches straight to the owning node's /internal/broker/dispatch.
+// Nodes and the registry authenticate each other with a shared secret; run
+// them on a private network or behind TLS, since results can carry secrets.
+type NetBroker struct {
+	node        string
+	nodeURL     string
+	registryURL string
+	secret      string
+	client      *http.Client // registry calls; dispatches use the context
+
+	mu    sync.RWMutex
+	serve DispatchFunc
+}
+
+func NewNetBroker(node, nodeURL, registryURL, secret string) *NetBroker {
+	return &NetBroker{
+		node:        node,
+		nodeURL:     strings.TrimRight(nodeURL, "/"),
+		registryURL: strings.TrimRight(registryURL, "/"),
+		secret:      secret,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (n *NetBroker) Node() string { return n.node }
+
+func (n *NetBroker) ClaimAgent(vpsId string, generation uint64) error {
+	owner := AgentOwner{Node: n.node, URL: n.nodeURL, Generation: generation}
+	_, err := n.call(context.Background(), n.client, http.MethodPut, n.agentURL(vpsId), owner, nil)
+	return err
+}
+
+func (n *NetBroker) ReleaseAgent(vpsId string, generation uint64) error {
+	q := url.Values{"node": {n.node}, "generation": {strconv.FormatUint(generation, 10)}}
+	_, err := n.call(context.Background(), n.client, http.MethodDelete, n.agentURL(vpsId)+"?"+q.Encode(), nil, nil)
+	return err
+}
+
+func (n *NetBroker) AgentOwner(vpsId string) (AgentOwner, bool, error) {
+	var owner AgentOwner
+	status, err := n.call(context.Background(), n.client, http.MethodGet, n.agentURL(vpsId), nil, &owner)
+	if status == http.StatusNotFound {
+		return AgentOwner{}, false, nil
+	}
+	if err != nil {
+		return AgentOwner{}, false, err
+	}
+	return owner, true, nil
+}
+
+func (n *NetBroker) Agents() (map[string]AgentOwner, error) {
+	var owners map[string]AgentOwner
+	if _, err := n.call(context.Background(), n.client, http.MethodGet, n.registryURL+"/internal/broker/agents", nil, &owners); err != nil {
+		return nil, err
+	}
+	return owners, nil
+}
+
+func (n *NetBroker) Dispatch(ctx context.Context, owner AgentOwner, req DispatchRequest) (TaskResult, error) {
+	if owner.URL == "" {
+		return TaskResult{}, errors.New("node has no address")
+	}
+	var res TaskResult
+	if _, err := n.call(ctx, http.DefaultClient, http.MethodPost, owner.URL+"/internal/broker/dispatch", req, &res); err != nil {
+		return TaskResult{}, err
+	}
+	return res, nil
+}
+
+func (n *NetBroker) Serve(fn DispatchFunc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.serve = fn
+}
+
+func (n *NetBroker) agentURL(vpsId string) string {
+	return n.registryURL + "/internal/broker/agents/" + url.PathEscape(vpsId)
+}
+
+// call sends body as JSON and decodes a 2xx response into out. Error
+// responses carry {"error": "..."}.
+func (n *NetBroker) call(ctx context.Context, client *http.Client, method, u string, body, out interface{}) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.secret)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = resp.Status
+		}
+		return resp.StatusCode, errors.New(e.Error)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode %s response: %w", u, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// brokerAuthorized checks the shared secret on node-to-node requests.
+func brokerAuthorized(c *gin.Context, secret string) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if secret == "" || !hmac.Equal([]byte(token), []byte(secret)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return false
+	}
+	return true
+}
+
+// HandleBrokerDispatch runs a task forwarded by another node. The task is
+// abandoned, and cancelled on the agent, if the forwarding node goes away.
+func HandleBrokerDispatch(c *gin.Context) {
+	n, ok := currentBroker().(*NetBroker)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not a broker node"})
+		return
+	}
+	if !brokerAuthorized(c, n.secret) {
+		return
+	}
+	var req DispatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	n.mu.RLock()
+	serve := n.serve
+	n.mu.RUnlock()
+	if serve == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "node is not serving"})
+		return
+	}
+	res, err := serve(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// RegistryServer is a minimal in-memory registry for NetBroker: one node
+// (or a test) can host it for the others. A shared store with the same
+// HTTP interface can replace it.
+type RegistryServer struct {
+	secret string
+	mu     sync.Mutex
+	owners map[string]AgentOwner // vpsId -> owner
+}
+
+func NewRegistryServer(secret string) *RegistryServer {
+	return &RegistryServer{secret: secret, owners: map[string]AgentOwner{}}
+}
+
+// Register mounts the registry under /internal/broker/agents.
+func (s *RegistryServer) Register(r gin.IRoutes) {
+	r.GET("/internal/broker/agents", s.list)
+	r.GET("/internal/broker/agents/:vps", s.get)
+	r.PUT("/internal/broker/agents/:vps", s.put)
+	r.DELETE("/internal/broker/agents/:vps", s.delete)
+}
+
+func (s *RegistryServer) list(c *gin.Context) {
+	if !brokerAuthorized(c, s.secret) {
+		return
+	}
+	s.mu.Lock()
+	out := make(map[string]AgentOwner, len(s.owners))
+	for vpsId, owner := range s.owners {
+		out[vpsId] = owner
+	}
+	s.mu.Unlock()
+	c.JSON(http.StatusOK, out)
+}
+
+func (s *RegistryServer) get(c *gin.Context) {
+	if !brokerAuthorized(c, s.secret) {
+		return
+	}
+	s.mu.Lock()
+	owner, ok := s.owners[c.Param("vps")]
+	s.mu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not connected"})
+		return
+	}
+	c.JSON(http.StatusOK, owner)
+}
+
+func (s *RegistryServer) put(c *gin.Context) {
+	if !brokerAuthorized(c, s.secret) {
+		return
+	}
+	var owner AgentOwner
+	if err := c.ShouldBindJSON(&owner); err != nil || owner.Node == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "node is required"})
+		return
+	}
+	s.mu.Lock()
+	s.owners[c.Param("vps")] = owner
+	s.mu.Unlock()
+	c.Status(http.StatusNoContent)
+}
+
+func (s *RegistryServer) delete(c *gin.Context) {
+	if !brokerAuthorized(c, s.secret) {
+		return
+	}
+	generation, _ := strconv.ParseUint(c.Query("generation"), 10, 64)
+	s.mu.Lock()
+	if cur, ok := s.owners[c.Param("vps")]; ok && cur.Node == c.Query("node") && cur.Generation == generation {
+		delete(s.owners, c.Param("vps"))
+	}
+	s.mu.Unlock()
+	c.Status(http.StatusNoContent)
+}
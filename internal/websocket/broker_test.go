@@ -0,0 +1,297 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useBroker makes b the broker for the test.
+func useBroker(t *testing.T, b Broker) {
+	t.Helper()
+	prev := currentBroker()
+	SetBroker(b)
+	t.Cleanup(func() { SetBroker(prev) })
+}
+
+func TestMemoryBrokerPeer(t *testing.T) {
+	a := NewMemoryBroker("a")
+	b := a.Peer("b")
+	var got DispatchRequest
+	b.Serve(func(ctx context.Context, req DispatchRequest) (TaskResult, error) {
+		got = req
+		return TaskResult{TaskID: "t1", Task: req.Task}, nil
+	})
+
+	b.ClaimAgent("v1", 3)
+	owner, ok, _ := a.AgentOwner("v1")
+	if !ok || owner.Node != "b" || owner.Generation != 3 {
+		t.Fatalf("owner = %+v, %v; want node b generation 3", owner, ok)
+	}
+
+	res, err := a.Dispatch(context.Background(), owner, DispatchRequest{VPSID: "v1", Task: "check_uptime", Origin: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TaskID != "t1" || got.VPSID != "v1" || got.Origin != "a" {
+		t.Errorf("dispatch = %+v, served %+v", res, got)
+	}
+	if _, err := a.Dispatch(context.Background(), AgentOwner{Node: "c"}, DispatchRequest{}); err == nil {
+		t.Error("dispatch to a node that isn't serving succeeded")
+	}
+
+	// only the owning node, with the current generation, releases
+	b.ReleaseAgent("v1", 2)
+	a.ReleaseAgent("v1", 3)
+	if _, ok, _ := a.AgentOwner("v1"); !ok {
+		t.Fatal("stale release dropped the owner")
+	}
+	b.ReleaseAgent("v1", 3)
+	if _, ok, _ := a.AgentOwner("v1"); ok {
+		t.Error("owner kept after release")
+	}
+}
+
+func TestNetBroker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "s3cret"
+
+	reg := gin.New()
+	NewRegistryServer(secret).Register(reg)
+	regSrv := httptest.NewServer(reg)
+	t.Cleanup(regSrv.Close)
+
+	// node b owns the agent and serves dispatches through the current broker
+	node := gin.New()
+	node.POST("/internal/broker/dispatch", HandleBrokerDispatch)
+	nodeSrv := httptest.NewServer(node)
+	t.Cleanup(nodeSrv.Close)
+	b := NewNetBroker("b", nodeSrv.URL+"/", regSrv.URL, secret)
+	useBroker(t, b)
+	var served DispatchRequest
+	b.Serve(func(ctx context.Context, req DispatchRequest) (TaskResult, error) {
+		served = req
+		if req.Task == "check_memory" {
+			return TaskResult{}, errors.New("agent v1 is not connected to node b")
+		}
+		return TaskResult{TaskID: "t1", Task: req.Task, Stdout: "up"}, nil
+	})
+
+	a := NewNetBroker("a", "", regSrv.URL, secret)
+
+	if err := b.ClaimAgent("v1", 2); err != nil {
+		t.Fatal(err)
+	}
+	owner, ok, err := a.AgentOwner("v1")
+	if err != nil || !ok {
+		t.Fatalf("AgentOwner = %v, %v", ok, err)
+	}
+	if owner.Node != "b" || owner.URL != nodeSrv.URL || owner.Generation != 2 {
+		t.Errorf("owner = %+v", owner)
+	}
+	if _, ok, err := a.AgentOwner("v2"); ok || err != nil {
+		t.Errorf("unknown agent: ok %v, err %v", ok, err)
+	}
+
+	t.Run("stale release", func(t *testing.T) {
+		b.ReleaseAgent("v1", 1)
+		a.ReleaseAgent("v1", 2)
+		if _, ok, _ := a.AgentOwner("v1"); !ok {
+			t.Error("release by an old generation or another node dropped the owner")
+		}
+	})
+
+	t.Run("dispatch", func(t *testing.T) {
+		res, err := a.Dispatch(context.Background(), owner, DispatchRequest{VPSID: "v1", Task: "check_uptime", Origin: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.TaskID != "t1" || res.Stdout != "up" || served.Origin != "a" {
+			t.Errorf("result = %+v, served %+v", res, served)
+		}
+		_, err = a.Dispatch(context.Background(), owner, DispatchRequest{VPSID: "v1", Task: "check_memory"})
+		if err == nil || !strings.Contains(err.Error(), "not connected to node b") {
+			t.Errorf("failed dispatch: error = %v", err)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		bad := NewNetBroker("x", "", regSrv.URL, "guess")
+		if _, _, err := bad.AgentOwner("v1"); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+			t.Errorf("registry lookup: error = %v", err)
+		}
+		if err := bad.ClaimAgent("v1", 9); err == nil {
+			t.Error("claim with the wrong secret succeeded")
+		}
+		if _, err := bad.Dispatch(context.Background(), owner, DispatchRequest{VPSID: "v1", Task: "check_uptime"}); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+			t.Errorf("dispatch: error = %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		owners, err := a.Agents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(owners) != 1 || owners["v1"].Node != "b" {
+			t.Errorf("agents = %+v, want v1 on b", owners)
+		}
+		if _, err := NewNetBroker("x", "", regSrv.URL, "guess").Agents(); err == nil {
+			t.Error("listing with the wrong secret succeeded")
+		}
+	})
+
+	b.ReleaseAgent("v1", 2)
+	if _, ok, _ := a.AgentOwner("v1"); ok {
+		t.Error("owner kept after release")
+	}
+}
+
+// countingBroker counts owner lookups.
+type countingBroker struct {
+	*MemoryBroker
+	lookups int
+}
+
+func (c *countingBroker) AgentOwner(vpsId string) (AgentOwner, bool, error) {
+	c.lookups++
+	return c.MemoryBroker.AgentOwner(vpsId)
+}
+
+func TestRemoteOwnerCachesMisses(t *testing.T) {
+	b := &countingBroker{MemoryBroker: NewMemoryBroker("a")}
+	useBroker(t, b)
+
+	for range 3 {
+		if _, ok := remoteOwner("v1"); ok {
+			t.Fatal("found an owner for an unclaimed agent")
+		}
+	}
+	if b.lookups != 1 {
+		t.Errorf("%d registry lookups for a missing agent, want 1", b.lookups)
+	}
+
+	// once the miss expires the agent is found on its new node
+	b.Peer("b").ClaimAgent("v1", 1)
+	ownerMissMtx.Lock()
+	clear(ownerMisses)
+	ownerMissMtx.Unlock()
+	owner, ok := remoteOwner("v1")
+	if !ok || owner.Node != "b" {
+		t.Errorf("remoteOwner = %+v, %v; want node b", owner, ok)
+	}
+
+	// agents owned by this node are not remote
+	b.ClaimAgent("v2", 1)
+	if _, ok := remoteOwner("v2"); ok {
+		t.Error("local agent reported as remote")
+	}
+}
+
+func TestFleetStatusAcrossNodes(t *testing.T) {
+	a := NewMemoryBroker("a")
+	useBroker(t, a)
+	connectTestAgent(t, "fleet-local")
+	a.Peer("b").ClaimAgent("fleet-remote", 7)
+
+	agents, err := FleetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 {
+		t.Fatalf("fleet = %+v, want the local and the remote agent", agents)
+	}
+	local, remote := agents[0], agents[1]
+	if local.VPSID != "fleet-local" || local.Node != "a" || local.ConnectedAt == nil {
+		t.Errorf("local agent = %+v", local)
+	}
+	if remote.VPSID != "fleet-remote" || !remote.Online || remote.Node != "b" || remote.Generation != 7 {
+		t.Errorf("remote agent = %+v", remote)
+	}
+}
+
+func TestForwardedTasks(t *testing.T) {
+	a := NewMemoryBroker("a")
+	useBroker(t, a)
+	const vpsId = "forward-remote"
+	saveTestKeys(vpsId)
+
+	// node b runs check_uptime at once and holds check_memory until it is
+	// cancelled
+	var mu sync.Mutex
+	var served []DispatchRequest
+	cancelled := make(chan struct{})
+	b := a.Peer("b")
+	b.Serve(func(ctx context.Context, req DispatchRequest) (TaskResult, error) {
+		mu.Lock()
+		served = append(served, req)
+		mu.Unlock()
+		switch {
+		case req.Cancel:
+			close(cancelled)
+			return TaskResult{TaskID: req.TaskID}, nil
+		case req.Task == "check_memory":
+			<-cancelled
+			return TaskResult{TaskID: req.TaskID, Task: req.Task, Cancelled: true}, nil
+		}
+		return TaskResult{TaskID: req.TaskID, Task: req.Task, Stdout: "up"}, nil
+	})
+	servedID := func(id string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, req := range served {
+			if req.TaskID == id && !req.Cancel {
+				return true
+			}
+		}
+		return false
+	}
+
+	// queued while the agent is offline
+	queued, err := QueueSignedTask(vpsId, "check_uptime", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsAgentConnected(vpsId) {
+		t.Fatal("offline agent reported connected")
+	}
+
+	b.ClaimAgent(vpsId, 1)
+	ownerMissMtx.Lock()
+	clear(ownerMisses)
+	ownerMissMtx.Unlock()
+	if !IsAgentConnected(vpsId) {
+		t.Fatal("agent on node b reported offline")
+	}
+
+	sweepQueue(time.Now())
+	sent, err := SendSignedTask(vpsId, "check_uptime", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{queued, sent} {
+		waitUntil(t, "the forwarded task to finish", func() bool { return taskStatus(t, id) == TaskStatusCompleted })
+		if !servedID(id) {
+			t.Errorf("task %s not run on node b under its own id", id)
+		}
+	}
+	if q := QueuedTasks(vpsId); len(q) != 0 {
+		t.Errorf("%d tasks left in the queue", len(q))
+	}
+
+	running, err := SendSignedTask(vpsId, "check_memory", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "node b to run the task", func() bool { return servedID(running) })
+	if err := CancelTask(running); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the cancel to come back", func() bool { return taskStatus(t, running) == TaskStatusCancelled })
+}
@@ -0,0 +1,95 @@
+// internal/websocket/cancel.go
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"ultahost-ai-gateway/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+// CancelRequest asks the agent to stop a running task. The agent answers
+// with the task's task_result, marked cancelled.
+type CancelRequest struct {
+	Type      string `json:"type"` // "cancel"
+	TaskID    string `json:"task_id"`
+	Timestamp string `json:"timestamp"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// cancelCanonicalString must exactly match the agent's canonical string for HMAC
+func cancelCanonicalString(taskID, nonce, ts string) string {
+	return fmt.Sprintf("v1|cancel|%s|%s|%s", taskID, nonce, ts)
+}
+
+// CancelTask stops a task. One still waiting for a slot or in the offline
+// queue never reached the agent: it is dropped and marked cancelled at
+// once. A running task gets a signed cancel, through the owning node when
+// the agent is connected to another one, and stays running until the agent
+// reports it cancelled (or finished).
+func CancelTask(taskID string) error {
+	rec, ok := GetTask(taskID)
+	if !ok {
+		return fmt.Errorf("unknown task %s", taskID)
+	}
+	switch rec.Status {
+	case TaskStatusWaiting:
+		return cancelWaiting(rec.VPSID, taskID)
+	case TaskStatusQueued:
+		return cancelQueued(rec.VPSID, taskID)
+	case TaskStatusRunning:
+	default:
+		return fmt.Errorf("task %s is already %s", taskID, rec.Status)
+	}
+	a, ok := AgentByVPS(rec.VPSID)
+	if !ok {
+		if owner, remote := remoteOwner(rec.VPSID); remote {
+			return cancelRemote(owner, rec)
+		}
+	}
+	if ok && !a.HasFeature(FeatureCancel) {
+		return fmt.Errorf("the agent on %s (version %s) cannot cancel tasks", rec.VPSID, a.Hello.AgentVersion)
+	}
+	keyInfo, exist := utils.GetAgentKeys("Agent_" + rec.VPSID)
+	if !exist {
+		return fmt.Errorf("no key info for VPS %s", rec.VPSID)
+	}
+
+	ts := time.Now().UTC().Format(time.RFC3339Nano)
+	nonce := uuid.NewString()
+	mac := hmac.New(sha256.New, []byte(keyInfo.SignatureSecret))
+	mac.Write([]byte(cancelCanonicalString(taskID, nonce, ts)))
+
+	payload, err := json.Marshal(CancelRequest{
+		Type:      "cancel",
+		TaskID:    taskID,
+		Timestamp: ts,
+		Nonce:     nonce,
+		Signature: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	})
+	if err != nil {
+		return err
+	}
+	if err := SendMessage(rec.VPSID, payload); err != nil {
+		return fmt.Errorf("send cancel failed: %w", err)
+	}
+	markCancelRequested(taskID)
+	return nil
+}
+
+// cancelAbandoned cancels a task whose caller stopped waiting.
+func cancelAbandoned(taskID string, cause error) {
+	if err := CancelTask(taskID); err != nil {
+		log.Printf("cancel of abandoned task %s failed: %v", taskID, err)
+		return
+	}
+	log.Printf("cancelled task %s: %v", taskID, cause)
+}
@@ -0,0 +1,127 @@
+// internal/websocket/drain.go
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// close code sent to agents when the node drains or restarts; agents
+// reconnect after the delay given in the reason
+const closeRestarting = 4003
+
+// how often a drain checks for agents whose tasks have finished
+const drainPoll = 250 * time.Millisecond
+
+var errDraining = errors.New("this gateway node is draining; retry in a few seconds")
+
+var (
+	drainMtx sync.Mutex
+	draining bool
+)
+
+// Draining reports whether the node has stopped taking agents and tasks.
+func Draining() bool {
+	drainMtx.Lock()
+	defer drainMtx.Unlock()
+	return draining
+}
+
+// ShutdownGrace is how long running tasks get to finish before the node
+// shuts down or drains; 30s unless configured.
+func ShutdownGrace() time.Duration {
+	if config.AppConfig == nil || config.AppConfig.ShutdownGraceSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(config.AppConfig.ShutdownGraceSec) * time.Second
+}
+
+func reconnectDelay() time.Duration {
+	if config.AppConfig == nil || config.AppConfig.ReconnectDelaySec <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(config.AppConfig.ReconnectDelaySec) * time.Second
+}
+
+// Drain stops accepting agent connections and new tasks, then closes each
+// agent connection as soon as it has no task in flight, telling the agent to
+// reconnect (to another node, behind a load balancer) after a delay.
+// Connections still busy when ctx ends are closed anyway; their callers get
+// "connection closed". It returns the number of tasks cut off.
+func Drain(ctx context.Context, why string) int {
+	drainMtx.Lock()
+	draining = true
+	drainMtx.Unlock()
+
+	reason := fmt.Sprintf("%s, reconnect in %d seconds", why, int(reconnectDelay().Seconds()))
+	closed := map[*AgentConn]bool{} // until their read loops unregister them
+	t := time.NewTicker(drainPoll)
+	defer t.Stop()
+	for {
+		conns := connectedAgents()
+		if len(conns) == 0 {
+			return 0
+		}
+		for _, a := range conns {
+			if !closed[a] && len(runningTasks(a.VPSID)) == 0 {
+				a.close(closeRestarting, reason)
+				closed[a] = true
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			cut := 0
+			for _, a := range connectedAgents() {
+				if closed[a] {
+					continue
+				}
+				n := len(runningTasks(a.VPSID))
+				log.Printf("drain: closing agent %s with %d tasks in flight", a.VPSID, n)
+				cut += n
+				a.close(closeRestarting, reason)
+			}
+			return cut
+		}
+	}
+}
+
+func connectedAgents() []*AgentConn {
+	connectedMtx.RLock()
+	defer connectedMtx.RUnlock()
+	out := make([]*AgentConn, 0, len(agentConns))
+	for _, a := range agentConns {
+		out = append(out, a)
+	}
+	return out
+}
+
+// HandleDrain moves this node's agents away before a deploy; it is
+// authenticated with the broker secret. Draining lasts until the process
+// restarts.
+func HandleDrain(c *gin.Context) {
+	secret := ""
+	if config.AppConfig != nil {
+		secret = config.AppConfig.BrokerSecret
+	}
+	if !brokerAuthorized(c, secret) {
+		return
+	}
+	agents := len(connectedAgents())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownGrace())
+		defer cancel()
+		cut := Drain(ctx, "server draining")
+		log.Printf("drain: finished, %d tasks cut off", cut)
+	}()
+	c.JSON(http.StatusAccepted, gin.H{"draining": true, "agents": agents, "grace_seconds": int(ShutdownGrace().Seconds())})
+}
@@ -0,0 +1,93 @@
+// internal/websocket/events_token.go
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// Browsers can't set an Authorization header on a websocket, so the task
+// events socket authenticates with a short-lived token instead: an
+// authenticated request issues it for one task and the upgrade takes it.
+const eventsTokenTTL = time.Minute
+
+type eventsToken struct {
+	taskID  string
+	expires time.Time
+}
+
+var (
+	eventsTokensMu sync.Mutex
+	eventsTokens   = map[string]eventsToken{}
+)
+
+// IssueEventsToken returns a single-use token that opens the events socket
+// of taskID within eventsTokenTTL. The caller checks that the user may see
+// the task.
+func IssueEventsToken(taskID string) (string, time.Time, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	tok := hex.EncodeToString(b)
+	now := time.Now()
+
+	eventsTokensMu.Lock()
+	defer eventsTokensMu.Unlock()
+	for k, t := range eventsTokens {
+		if now.After(t.expires) {
+			delete(eventsTokens, k)
+		}
+	}
+	expires := now.Add(eventsTokenTTL)
+	eventsTokens[tok] = eventsToken{taskID: taskID, expires: expires}
+	return tok, expires, nil
+}
+
+// takeEventsToken consumes tok and reports whether it was issued for taskID
+// and has not expired.
+func takeEventsToken(tok, taskID string) bool {
+	eventsTokensMu.Lock()
+	defer eventsTokensMu.Unlock()
+	t, ok := eventsTokens[tok]
+	if !ok {
+		return false
+	}
+	delete(eventsTokens, tok)
+	return t.taskID == taskID && time.Now().Before(t.expires)
+}
+
+// browserUpgrader is for sockets opened by web pages. Unlike the agent
+// upgrader it checks the Origin: pages on the allowed origins, or the
+// gateway's own host when none are configured.
+var browserUpgrader = ws.Upgrader{CheckOrigin: browserOriginAllowed}
+
+func browserOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser
+	}
+	var allowed []string
+	if config.AppConfig != nil {
+		allowed = config.AppConfig.BrowserOrigins
+	}
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimRight(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"ultahost-ai-gateway/internal/config"
+)
+
+func TestEventsToken(t *testing.T) {
+	tok, _, err := IssueEventsToken("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if takeEventsToken(tok, "t2") {
+		t.Error("token accepted for another task")
+	}
+	// a failed take still spends the token
+	if takeEventsToken(tok, "t1") {
+		t.Error("token accepted twice")
+	}
+
+	tok, _, _ = IssueEventsToken("t1")
+	if !takeEventsToken(tok, "t1") {
+		t.Error("fresh token rejected")
+	}
+	if takeEventsToken("", "t1") {
+		t.Error("empty token accepted")
+	}
+}
+
+func TestBrowserOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin header", origin: "", want: true},
+		{name: "same host", origin: "https://gateway.example.com", want: true},
+		{name: "other host", origin: "https://evil.example.net", want: false},
+		{name: "listed origin", allowed: []string{"https://panel.example.com/"}, origin: "https://panel.example.com", want: true},
+		{name: "unlisted origin", allowed: []string{"https://panel.example.com"}, origin: "https://gateway.example.com", want: false},
+	}
+
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig = &config.Config{BrowserOrigins: tt.allowed}
+			r := httptest.NewRequest("GET", "https://gateway.example.com/vps/v1/tasks/t1/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := browserOriginAllowed(r); got != tt.want {
+				t.Errorf("browserOriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// internal/websocket/hello.go
+package websocket
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+	"ultahost-ai-gateway/internal/tasks"
+	"ultahost-ai-gateway/internal/utils"
+
+	ws "github.com/gorilla/websocket"
+)
+
+const (
+	// the agent has this long after connecting to send its hello
+	helloTimeout = 10 * time.Second
+	maxHelloSize = 64 * 1024
+
+	// close codes for a refused hello; agents must not reconnect on
+	// closeProtocolTooOld until upgraded
+	closeBadHello       = 4001
+	closeProtocolTooOld = 4002
+)
+
+// features an agent can announce
+const (
+	FeatureStreaming = "streaming" // task_output / task_progress
+	FeatureCancel    = "cancel"    // signed cancel requests
+)
+
+// AgentHello is the first message of every agent connection. Nothing is
+// sent to the agent before it has been verified.
+type AgentHello struct {
+	Type            string   `json:"type"` // "hello"
+	AgentVersion    string   `json:"agent_version"`
+	ProtocolVersion int      `json:"protocol_version"`
+	OS              string   `json:"os"`
+	Distro          string   `json:"distro"`
+	DistroVersion   string   `json:"distro_version"`
+	Arch            string   `json:"arch"`
+	Tasks           []string `json:"tasks"`    // capabilities the agent has, usually task names; see tasks.Spec.Capability
+	Features        []string `json:"features"` // see the Feature constants
+	Timestamp       string   `json:"timestamp"`
+	Nonce           string   `json:"nonce"`
+	Signature       string   `json:"signature"`
+}
+
+// helloCanonicalString must exactly match the agent's canonical string for
+// HMAC; tasks and features are joined in the order sent.
+func helloCanonicalString(h AgentHello) string {
+	return fmt.Sprintf("v1|hello|%s|%d|%s|%s|%s|%s|%s|%s|%s|%s",
+		h.AgentVersion, h.ProtocolVersion, h.OS, h.Distro, h.DistroVersion, h.Arch,
+		strings.Join(h.Tasks, ","), strings.Join(h.Features, ","), h.Nonce, h.Timestamp)
+}
+
+func minAgentProtocol() int {
+	if config.AppConfig == nil || config.AppConfig.MinAgentProtocol < 1 {
+		return 1
+	}
+	return config.AppConfig.MinAgentProtocol
+}
+
+// readHello waits for the agent's hello and verifies it. On error it also
+// returns the close code to send.
+func readHello(conn *ws.Conn, keyInfo utils.AgentKeys) (AgentHello, int, error) {
+	conn.SetReadLimit(maxHelloSize)
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return AgentHello{}, closeBadHello, fmt.Errorf("no hello: %w", err)
+	}
+	var h AgentHello
+	if err := json.Unmarshal(msg, &h); err != nil || h.Type != "hello" {
+		return AgentHello{}, closeBadHello, errors.New("first message must be hello")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, h.Timestamp)
+	if err != nil {
+		return AgentHello{}, closeBadHello, fmt.Errorf("invalid hello timestamp: %w", err)
+	}
+	if d := time.Since(ts); d > 5*time.Minute || d < -5*time.Minute {
+		return AgentHello{}, closeBadHello, errors.New("hello timestamp outside allowed skew")
+	}
+	expected := utils.HMACSHA256Base64([]byte(keyInfo.SignatureSecret), helloCanonicalString(h))
+	if !hmac.Equal([]byte(expected), []byte(h.Signature)) {
+		return AgentHello{}, closeBadHello, errors.New("invalid hello signature")
+	}
+	if min := minAgentProtocol(); h.ProtocolVersion < min {
+		return AgentHello{}, closeProtocolTooOld, fmt.Errorf("protocol version %d is below the minimum %d; upgrade the agent", h.ProtocolVersion, min)
+	}
+	if len(h.Tasks) == 0 {
+		return AgentHello{}, closeBadHello, errors.New("hello lists no tasks")
+	}
+	return h, 0, nil
+}
+
+// SupportsTask reports whether the agent announced the capability task
+// needs in its hello; see tasks.Spec.Capability.
+func (a *AgentConn) SupportsTask(task string) bool {
+	capability := task
+	if spec, ok := tasks.Lookup(task); ok {
+		capability = spec.Capability
+	}
+	for _, t := range a.Hello.Tasks {
+		if t == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFeature reports whether the agent announced feature in its hello.
+func (a *AgentConn) HasFeature(feature string) bool {
+	for _, f := range a.Hello.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCanRun refuses a task the agent did not announce.
+func checkCanRun(a *AgentConn, task string) error {
+	if !a.SupportsTask(task) {
+		return fmt.Errorf("the agent on %s (version %s) does not support %s; upgrade the agent", a.VPSID, a.Hello.AgentVersion, task)
+	}
+	return nil
+}
+
+// closeReason fits err into a close frame's reason.
+func closeReason(err error) string {
+	const max = 120 // control frames carry at most 123 bytes of reason
+	s := err.Error()
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
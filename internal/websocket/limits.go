@@ -0,0 +1,320 @@
+// internal/websocket/limits.go
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+	"ultahost-ai-gateway/internal/tasks"
+)
+
+// how long past its maximum timeout a started task keeps its slot when no
+// final status arrives for it (a fire-and-forget task whose result was lost)
+const slotGrace = time.Minute
+
+var errAgentBusy = errors.New("the agent is busy")
+
+// slotWaiter is a task waiting for its agent to have room for it
+type slotWaiter struct {
+	taskID   string
+	task     string
+	priority int
+	position int
+	ready    chan struct{} // closed when the task may start
+	dropped  chan struct{} // closed when the task is cancelled instead
+	observer QueueObserver
+}
+
+// agentSlots tracks one VPS's started tasks and the ones waiting to start
+type agentSlots struct {
+	running map[string]string // taskID -> task
+	waiting []*slotWaiter     // by priority, then arrival
+}
+
+var (
+	slotsMtx sync.Mutex
+	slots    = map[string]*agentSlots{} // vpsId -> slots; dropped when idle
+)
+
+func init() {
+	OnTaskFinished(func(rec TaskRecord) { releaseSlot(rec.VPSID, rec.TaskID) })
+}
+
+// QueueObserver is told a waiting task's place in its agent's queue each
+// time it changes. It must not block.
+type QueueObserver func(taskID string, position int)
+
+type queueObserverKey struct{}
+
+// WithQueueObserver returns a context that reports the queue position of
+// tasks sent with it to fn, for callers that want to tell a user why a
+// task has not started yet.
+func WithQueueObserver(ctx context.Context, fn QueueObserver) context.Context {
+	return context.WithValue(ctx, queueObserverKey{}, fn)
+}
+
+func queueObserver(ctx context.Context) QueueObserver {
+	fn, _ := ctx.Value(queueObserverKey{}).(QueueObserver)
+	return fn
+}
+
+func maxTasksPerAgent() int {
+	if config.AppConfig == nil || config.AppConfig.MaxTasksPerAgent < 1 {
+		return 4
+	}
+	return config.AppConfig.MaxTasksPerAgent
+}
+
+// taskPriority orders waiting tasks, lowest first: read-only checks a user
+// is usually waiting on jump ahead of installs and other long changes.
+func taskPriority(task string) int {
+	spec, _ := tasks.Lookup(task)
+	switch spec.Risk {
+	case tasks.RiskReadOnly:
+		return 0
+	case tasks.RiskLow:
+		return 1
+	}
+	return 2
+}
+
+func slotsFor(vpsId string) *agentSlots {
+	s, ok := slots[vpsId]
+	if !ok {
+		s = &agentSlots{running: map[string]string{}}
+		slots[vpsId] = s
+	}
+	return s
+}
+
+// canStart reports whether task fits next to the running tasks: under the
+// per-agent limit and the task's MaxConcurrent, with nothing from its
+// Exclusive group running.
+func (s *agentSlots) canStart(task string) bool {
+	if len(s.running) >= maxTasksPerAgent() {
+		return false
+	}
+	spec, _ := tasks.Lookup(task)
+	same := 0
+	for _, t := range s.running {
+		if t == task {
+			same++
+		}
+		if spec.Exclusive != "" {
+			if other, _ := tasks.Lookup(t); other.Exclusive == spec.Exclusive {
+				return false
+			}
+		}
+	}
+	return spec.MaxConcurrent == 0 || same < spec.MaxConcurrent
+}
+
+// start takes a slot for taskID. The slot is normally freed when the task
+// reaches a final status; a timer frees it in case that never happens.
+func (s *agentSlots) start(vpsId, taskID, task string) {
+	s.running[taskID] = task
+	hold := tasks.Timeout(task, 24*time.Hour) + slotGrace // the task's maximum
+	time.AfterFunc(hold, func() { releaseSlot(vpsId, taskID) })
+}
+
+// grant starts the waiters that now fit, in queue order, and renumbers the
+// rest. Callers hold slotsMtx and make the returned observer calls after
+// releasing it.
+func (s *agentSlots) grant(vpsId string) []func() {
+	kept := s.waiting[:0]
+	for _, w := range s.waiting {
+		if s.canStart(w.task) {
+			s.start(vpsId, w.taskID, w.task)
+			close(w.ready)
+			continue
+		}
+		kept = append(kept, w)
+	}
+	clear(s.waiting[len(kept):])
+	s.waiting = kept
+	return s.renumber()
+}
+
+// renumber updates the queue positions of the waiters on their records and
+// to their subscribers. Callers hold slotsMtx.
+func (s *agentSlots) renumber() []func() {
+	var calls []func()
+	for i, w := range s.waiting {
+		pos := i + 1
+		if pos == w.position {
+			continue
+		}
+		w.position = pos
+		setQueuePosition(w.taskID, pos)
+		publishQueuePosition(w.taskID, pos)
+		if fn := w.observer; fn != nil {
+			taskID := w.taskID
+			calls = append(calls, func() { fn(taskID, pos) })
+		}
+	}
+	return calls
+}
+
+// unmetered reports whether task runs without a slot; see tasks.Spec.
+func unmetered(task string) bool {
+	spec, _ := tasks.Lookup(task)
+	return spec.Unmetered
+}
+
+// tryAcquireSlot takes a slot for taskID only if one is free right now.
+// Waiting tasks never fit when a slot frees up, so this does not jump the
+// queue.
+func tryAcquireSlot(vpsId, taskID, task string) bool {
+	if unmetered(task) {
+		return true
+	}
+	slotsMtx.Lock()
+	defer slotsMtx.Unlock()
+	s := slotsFor(vpsId)
+	if !s.canStart(task) {
+		return false
+	}
+	s.start(vpsId, taskID, task)
+	return true
+}
+
+// acquireSlot takes a slot for taskID on vpsId's agent, waiting in the
+// agent's priority queue while it is busy. A waiting task is recorded with
+// status waiting and its queue position; unmetered tasks never wait. It
+// gives up when ctx is done or after wait, failing the task.
+func acquireSlot(ctx context.Context, vpsId, taskID, task string, args []string, wait time.Duration) error {
+	if unmetered(task) {
+		return nil
+	}
+	slotsMtx.Lock()
+	s := slotsFor(vpsId)
+	if s.canStart(task) {
+		s.start(vpsId, taskID, task)
+		slotsMtx.Unlock()
+		return nil
+	}
+	w := &slotWaiter{taskID: taskID, task: task, priority: taskPriority(task), ready: make(chan struct{}), dropped: make(chan struct{}), observer: queueObserver(ctx)}
+	recordTaskWaiting(vpsId, taskID, task, args)
+	i := len(s.waiting)
+	for i > 0 && s.waiting[i-1].priority > w.priority {
+		i--
+	}
+	s.waiting = append(s.waiting[:i], append([]*slotWaiter{w}, s.waiting[i:]...)...)
+	calls := s.renumber()
+	slotsMtx.Unlock()
+	for _, call := range calls {
+		call()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	var reason string
+	select {
+	case <-w.ready:
+		return nil
+	case <-w.dropped:
+		return fmt.Errorf("task %s was cancelled before it started", taskID)
+	case <-timer.C:
+		reason = fmt.Sprintf("did not start within %s; the agent stayed busy", wait)
+	case <-ctx.Done():
+		reason = "stopped waiting for the agent: " + ctx.Err().Error()
+	}
+	dropWaiter(vpsId, w)
+	transitionTask(taskID, TaskStatusWaiting, TaskStatusFailed, reason)
+	return fmt.Errorf("task %s %s", taskID, reason)
+}
+
+// dropWaiter takes w out of the queue, or gives back its slot if it was
+// granted just as its caller gave up.
+func dropWaiter(vpsId string, w *slotWaiter) {
+	slotsMtx.Lock()
+	var calls []func()
+	if s, ok := slots[vpsId]; ok {
+		for i, x := range s.waiting {
+			if x == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				break
+			}
+		}
+		calls = s.renumber()
+		if len(s.running) == 0 && len(s.waiting) == 0 {
+			delete(slots, vpsId)
+		}
+	}
+	slotsMtx.Unlock()
+	for _, call := range calls {
+		call()
+	}
+	releaseSlot(vpsId, w.taskID)
+}
+
+// cancelWaiting takes a waiting task out of its agent's queue and marks it
+// cancelled; its acquireSlot returns an error.
+func cancelWaiting(vpsId, taskID string) error {
+	slotsMtx.Lock()
+	var w *slotWaiter
+	var calls []func()
+	if s, ok := slots[vpsId]; ok {
+		for i, x := range s.waiting {
+			if x.taskID == taskID {
+				w = x
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				break
+			}
+		}
+		calls = s.renumber()
+		if len(s.running) == 0 && len(s.waiting) == 0 {
+			delete(slots, vpsId)
+		}
+	}
+	slotsMtx.Unlock()
+	for _, call := range calls {
+		call()
+	}
+	if w == nil {
+		// granted a slot, or not queued yet
+		return fmt.Errorf("task %s is starting; cancel it again in a moment", taskID)
+	}
+	transitionTask(taskID, TaskStatusWaiting, TaskStatusCancelled, "cancelled before it started")
+	close(w.dropped)
+	return nil
+}
+
+// abandonSlot gives back the slot of a task that could not be sent after
+// all and fails it.
+func abandonSlot(vpsId, taskID string, err error) {
+	releaseSlot(vpsId, taskID)
+	transitionTask(taskID, TaskStatusWaiting, TaskStatusFailed, err.Error())
+}
+
+// releaseSlot frees taskID's slot, starts the waiters that now fit and
+// resumes the offline queue's delivery if it stopped on a busy agent.
+func releaseSlot(vpsId, taskID string) {
+	slotsMtx.Lock()
+	s, ok := slots[vpsId]
+	if !ok {
+		slotsMtx.Unlock()
+		return
+	}
+	if _, running := s.running[taskID]; !running {
+		slotsMtx.Unlock()
+		return
+	}
+	delete(s.running, taskID)
+	calls := s.grant(vpsId)
+	if len(s.running) == 0 && len(s.waiting) == 0 {
+		delete(slots, vpsId)
+	}
+	slotsMtx.Unlock()
+	for _, call := range calls {
+		call()
+	}
+
+	if a, ok := AgentByVPS(vpsId); ok && hasQueued(vpsId) {
+		go deliverQueued(a)
+	}
+}
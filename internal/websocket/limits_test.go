@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// limitTasks sets the per-agent task limit for the test.
+func limitTasks(t *testing.T, n int) {
+	t.Helper()
+	saved := config.AppConfig
+	config.AppConfig = &config.Config{MaxTasksPerAgent: n}
+	t.Cleanup(func() { config.AppConfig = saved })
+}
+
+// waiting returns the task ids waiting for a slot on vpsId, in queue order.
+func waiting(vpsId string) []string {
+	slotsMtx.Lock()
+	defer slotsMtx.Unlock()
+	var ids []string
+	if s, ok := slots[vpsId]; ok {
+		for _, w := range s.waiting {
+			ids = append(ids, w.taskID)
+		}
+	}
+	return ids
+}
+
+// waitUntil polls cond for up to two seconds.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlotPriorityAndQueuePosition(t *testing.T) {
+	limitTasks(t, 1)
+	const vpsId = "limits-priority"
+	running := uuid.NewString()
+	if !tryAcquireSlot(vpsId, running, "install_wordpress") {
+		t.Fatal("idle agent had no slot")
+	}
+
+	var mu sync.Mutex
+	var positions []int // the installer's, as its observer saw them
+	observe := WithQueueObserver(context.Background(), func(_ string, pos int) {
+		mu.Lock()
+		positions = append(positions, pos)
+		mu.Unlock()
+	})
+
+	install, check := uuid.NewString(), uuid.NewString()
+	started := make(chan string, 2)
+	acquire := func(ctx context.Context, taskID, task string) {
+		if err := acquireSlot(ctx, vpsId, taskID, task, nil, time.Minute); err != nil {
+			t.Errorf("%s: %v", task, err)
+		}
+		started <- taskID
+	}
+	go acquire(observe, install, "install_wordpress")
+	waitUntil(t, "the installer to queue", func() bool { return len(waiting(vpsId)) == 1 })
+	// a read-only check arriving later goes ahead of the installer
+	go acquire(context.Background(), check, "check_uptime")
+	waitUntil(t, "the check to queue", func() bool { return len(waiting(vpsId)) == 2 })
+
+	if got := waiting(vpsId); !slices.Equal(got, []string{check, install}) {
+		t.Fatalf("queue = %v, want the check before the installer", got)
+	}
+	for id, want := range map[string]int{check: 1, install: 2} {
+		if rec, _ := GetTask(id); rec.Status != TaskStatusWaiting || rec.QueuePosition != want {
+			t.Errorf("task %s: status %s position %d, want waiting at %d", id, rec.Status, rec.QueuePosition, want)
+		}
+	}
+
+	releaseSlot(vpsId, running)
+	if got := <-started; got != check {
+		t.Fatalf("%s started first, want the check", got)
+	}
+	if rec, _ := GetTask(install); rec.QueuePosition != 1 {
+		t.Errorf("installer position = %d after the check started, want 1", rec.QueuePosition)
+	}
+	releaseSlot(vpsId, check)
+	if got := <-started; got != install {
+		t.Fatalf("%s started, want the installer", got)
+	}
+	releaseSlot(vpsId, install)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(positions, []int{1, 2, 1}) {
+		t.Errorf("installer positions = %v, want [1 2 1]", positions)
+	}
+}
+
+func TestSlotExclusion(t *testing.T) {
+	limitTasks(t, 5)
+	const vpsId = "limits-exclusive"
+	tests := []struct {
+		task string
+		want bool
+	}{
+		{task: "firewall_open_port", want: true},
+		{task: "firewall_deny_ip", want: false}, // same Exclusive group
+		{task: "check_uptime", want: true},
+		{task: "install_wordpress", want: true},
+		{task: "db_dump", want: true},
+		{task: "db_dump", want: false}, // MaxConcurrent 1
+		{task: "check_memory", want: true},
+		{task: "check_diskspace", want: false}, // the agent is full
+	}
+
+	var held []string
+	for _, tt := range tests {
+		id := uuid.NewString()
+		got := tryAcquireSlot(vpsId, id, tt.task)
+		if got != tt.want {
+			t.Errorf("tryAcquireSlot(%s) = %v, want %v", tt.task, got, tt.want)
+		}
+		if got {
+			held = append(held, id)
+		}
+	}
+	// the firewall commit still gets through
+	if !tryAcquireSlot(vpsId, uuid.NewString(), "firewall_commit") {
+		t.Error("firewall_commit waited for a slot")
+	}
+
+	releaseSlot(vpsId, held[0])
+	if !tryAcquireSlot(vpsId, uuid.NewString(), "firewall_deny_ip") {
+		t.Error("firewall change still blocked after the first one finished")
+	}
+	slotsMtx.Lock()
+	delete(slots, vpsId)
+	slotsMtx.Unlock()
+}
+
+func TestSlotUnmeteredAndTimeout(t *testing.T) {
+	limitTasks(t, 1)
+	const vpsId = "limits-timeout"
+	running := uuid.NewString()
+	tryAcquireSlot(vpsId, running, "firewall_open_port")
+	defer releaseSlot(vpsId, running)
+
+	if err := acquireSlot(context.Background(), vpsId, uuid.NewString(), "firewall_commit", nil, time.Millisecond); err != nil {
+		t.Errorf("firewall_commit behind a full agent: %v", err)
+	}
+
+	id := uuid.NewString()
+	if err := acquireSlot(context.Background(), vpsId, id, "check_uptime", nil, 10*time.Millisecond); err == nil {
+		t.Fatal("acquired a slot on a full agent")
+	}
+	if rec, _ := GetTask(id); rec.Status != TaskStatusFailed || rec.Reason == "" {
+		t.Errorf("timed out task: status %s reason %q, want failed with a reason", rec.Status, rec.Reason)
+	}
+	if got := waiting(vpsId); len(got) != 0 {
+		t.Errorf("queue = %v after the wait gave up", got)
+	}
+}
+
+func TestCancelWaitingTask(t *testing.T) {
+	limitTasks(t, 1)
+	const vpsId = "limits-cancel"
+	running := uuid.NewString()
+	tryAcquireSlot(vpsId, running, "check_uptime")
+	defer releaseSlot(vpsId, running)
+
+	id := uuid.NewString()
+	done := make(chan error, 1)
+	go func() { done <- acquireSlot(context.Background(), vpsId, id, "check_memory", nil, time.Minute) }()
+	waitUntil(t, "the task to queue", func() bool { return len(waiting(vpsId)) == 1 })
+
+	if err := CancelTask(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil {
+		t.Error("cancelled task acquired a slot")
+	}
+	if rec, _ := GetTask(id); rec.Status != TaskStatusCancelled {
+		t.Errorf("status = %s, want cancelled", rec.Status)
+	}
+	if got := waiting(vpsId); len(got) != 0 {
+		t.Errorf("queue = %v after the cancel", got)
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"time"
 )
 
-// pendingEntry holds a channel and the agent identity that owns the task
+// pendingEntry holds a channel and the agent identity and connection
+// generation the task was sent on
 type pendingEntry struct {
 	ch            chan TaskResult
 	agentIdentity string
+	generation    uint64
 	created       time.Time
 }
 
@@ -20,13 +22,14 @@ var (
 )
 
 // registerPending registers a pending channel for taskID and returns the channel
-func registerPending(taskID, agentIdentity string) chan TaskResult {
+func registerPending(taskID, agentIdentity string, generation uint64) chan TaskResult {
 	pendingMtx.Lock()
 	defer pendingMtx.Unlock()
 	ch := make(chan TaskResult, 1)
 	pendingMap[taskID] = &pendingEntry{
 		ch:            ch,
 		agentIdentity: agentIdentity,
+		generation:    generation,
 		created:       time.Now(),
 	}
 	return ch
@@ -88,13 +91,14 @@ func waitForPending(taskID string, timeout time.Duration) (TaskResult, error) {
 	}
 }
 
-// failPendingForAgent fails all pending tasks that were intended for agentIdentity
-// This is invoked on agent disconnect so callers don't wait forever.
-func failPendingForAgent(agentIdentity, reason string) {
+// failPendingForAgent fails the pending tasks sent to agentIdentity on
+// connection generation. This is invoked on agent disconnect so callers
+// don't wait forever; tasks sent on a newer connection are left alone.
+func failPendingForAgent(agentIdentity string, generation uint64, reason string) {
 	pendingMtx.Lock()
 	toFail := make(map[string]*pendingEntry)
 	for id, e := range pendingMap {
-		if e.agentIdentity == agentIdentity {
+		if e.agentIdentity == agentIdentity && e.generation == generation {
 			toFail[id] = e
 			delete(pendingMap, id)
 		}
@@ -116,6 +120,7 @@ func failPendingForAgent(agentIdentity, reason string) {
 			SignatureOK:  false,
 			ScriptSHA256: "",
 		}
+		recordTaskResult(res)
 		select {
 		case e.ch <- res:
 		default:
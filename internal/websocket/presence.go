@@ -0,0 +1,113 @@
+// internal/websocket/presence.go
+package websocket
+
+import (
+	"sort"
+	"time"
+)
+
+// AgentStatus is an agent's presence as seen by this node. An agent
+// connected to another node shows only Online, Node and, in FleetStatus,
+// Generation.
+type AgentStatus struct {
+	VPSID                string       `json:"vps_id"`
+	Online               bool         `json:"online"`
+	Node                 string       `json:"node,omitempty"`
+	Generation           uint64       `json:"generation,omitempty"`
+	ConnectedAt          *time.Time   `json:"connected_at,omitempty"`
+	LastSeen             *time.Time   `json:"last_seen,omitempty"`
+	LastHeartbeatCounter uint64       `json:"last_heartbeat_counter,omitempty"`
+	PingRTTMs            float64      `json:"ping_rtt_ms,omitempty"`
+	AgentVersion         string       `json:"agent_version,omitempty"`
+	ProtocolVersion      int          `json:"protocol_version,omitempty"`
+	OS                   string       `json:"os,omitempty"`
+	Distro               string       `json:"distro,omitempty"`
+	DistroVersion        string       `json:"distro_version,omitempty"`
+	Arch                 string       `json:"arch,omitempty"`
+	Features             []string     `json:"features,omitempty"`
+	RunningTasks         []TaskRecord `json:"running_tasks"`
+	WaitingTasks         []TaskRecord `json:"waiting_tasks,omitempty"` // QueuePosition is the order they start in
+}
+
+func (a *AgentConn) status() AgentStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	connected, seen := a.ConnectedAt.UTC(), a.LastSeen.UTC()
+	return AgentStatus{
+		VPSID:                a.VPSID,
+		Online:               true,
+		Node:                 NodeName(),
+		Generation:           a.Generation,
+		ConnectedAt:          &connected,
+		LastSeen:             &seen,
+		LastHeartbeatCounter: a.LastHeartbeatCounter,
+		PingRTTMs:            float64(a.PingRTT.Microseconds()) / 1000,
+		AgentVersion:         a.Hello.AgentVersion,
+		ProtocolVersion:      a.Hello.ProtocolVersion,
+		OS:                   a.Hello.OS,
+		Distro:               a.Hello.Distro,
+		DistroVersion:        a.Hello.DistroVersion,
+		Arch:                 a.Hello.Arch,
+		Features:             a.Hello.Features,
+		RunningTasks:         runningTasks(a.VPSID),
+		WaitingTasks:         tasksWithStatus(a.VPSID, TaskStatusWaiting),
+	}
+}
+
+// NodeName is this gateway node's name.
+func NodeName() string {
+	return currentBroker().Node()
+}
+
+// FleetStatus lists every connected agent the broker knows of, by VPS id:
+// agents on this node in full, those on other nodes with Online and Node.
+// When the broker can't be asked it returns this node's agents and the
+// error.
+func FleetStatus() ([]AgentStatus, error) {
+	conns := connectedAgents()
+	out := make([]AgentStatus, 0, len(conns))
+	local := make(map[string]bool, len(conns))
+	for _, a := range conns {
+		out = append(out, a.status())
+		local[a.VPSID] = true
+	}
+	owners, err := currentBroker().Agents()
+	for vpsId, owner := range owners {
+		if !local[vpsId] {
+			out = append(out, AgentStatus{VPSID: vpsId, Online: true, Node: owner.Node, Generation: owner.Generation, RunningTasks: runningTasks(vpsId)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].VPSID < out[j].VPSID })
+	return out, err
+}
+
+// AgentStatusFor returns the presence of the agent on vpsId, looking up
+// other nodes through the broker.
+func AgentStatusFor(vpsId string) AgentStatus {
+	if a, ok := AgentByVPS(vpsId); ok {
+		return a.status()
+	}
+	st := AgentStatus{VPSID: vpsId, RunningTasks: runningTasks(vpsId)}
+	if owner, ok := remoteOwner(vpsId); ok {
+		st.Online, st.Node = true, owner.Node
+	}
+	return st
+}
+
+// runningTasks returns vpsId's tasks still waiting for a result, oldest
+// first.
+func runningTasks(vpsId string) []TaskRecord {
+	return tasksWithStatus(vpsId, TaskStatusRunning)
+}
+
+func tasksWithStatus(vpsId, status string) []TaskRecord {
+	taskStoreMtx.RLock()
+	defer taskStoreMtx.RUnlock()
+	out := []TaskRecord{}
+	for _, id := range vpsTaskIndex[vpsId] {
+		if rec, ok := taskRecords[id]; ok && rec.Status == status {
+			out = append(out, *rec)
+		}
+	}
+	return out
+}
@@ -0,0 +1,363 @@
+// internal/websocket/queue.go
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/config"
+)
+
+const (
+	MaxQueueTTL        = 24 * time.Hour
+	maxQueuedPerVPS    = 20
+	queueSweepInterval = 30 * time.Second
+)
+
+// QueuedTask is a task waiting for its agent to connect. It is signed only
+// when delivered, so the agent sees a fresh timestamp and nonce.
+type QueuedTask struct {
+	TaskID    string    `json:"task_id"`
+	VPSID     string    `json:"vps_id"`
+	Task      string    `json:"task"`
+	Args      []string  `json:"args,omitempty"`
+	QueuedAt  time.Time `json:"queued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	queueMtx   sync.Mutex
+	taskQueue  = map[string][]QueuedTask{} // vpsId -> tasks, oldest first
+	delivering = map[string]bool{}         // vpsId -> a delivery loop is running
+
+	queueOnce sync.Once
+)
+
+// StartTaskQueue loads the persisted queue and starts expiring tasks that
+// outlive their TTL.
+func StartTaskQueue() {
+	queueOnce.Do(func() {
+		queueMtx.Lock()
+		if err := loadQueue(); err != nil {
+			log.Printf("task queue: could not load: %v", err)
+		}
+		queueMtx.Unlock()
+
+		go func() {
+			t := time.NewTicker(queueSweepInterval)
+			defer t.Stop()
+			for now := range t.C {
+				sweepQueue(now)
+			}
+		}()
+	})
+}
+
+// QueueSignedTask is SendSignedTask for callers that can wait for an
+// offline agent: when the agent is not connected the task is queued and
+// delivered, in order, when it reconnects, to this node or another. A task not delivered within ttl
+// ends with status expired. It returns the taskID; the result arrives
+// through the task store like any other.
+func QueueSignedTask(vpsId, task string, args []string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > MaxQueueTTL {
+		return "", fmt.Errorf("queue ttl must be between 0 and %s", MaxQueueTTL)
+	}
+	// validates the task and that the agent has keys; the signature itself
+	// is only used if the agent is connected now
+	tr, _, err := buildSignedTask(vpsId, task, args)
+	if err != nil {
+		return "", err
+	}
+
+	// a draining node keeps new tasks queued until it is back, and a busy
+	// agent gets them once a slot frees up
+	if _, ok := AgentByVPS(vpsId); !ok && !hasQueued(vpsId) && !Draining() {
+		if owner, remote := remoteOwner(vpsId); remote {
+			forwardInBackground(owner, vpsId, tr.TaskID, task, args)
+			return tr.TaskID, nil
+		}
+	}
+	if a, ok := AgentByVPS(vpsId); ok && !hasQueued(vpsId) && !Draining() {
+		if err := checkCanRun(a, task); err != nil {
+			return "", err
+		}
+		payload, err := json.Marshal(tr)
+		if err != nil {
+			return "", err
+		}
+		if tryAcquireSlot(vpsId, tr.TaskID, task) {
+			recordTaskSent(vpsId, tr.TaskID, task, args)
+			if err := a.send(payload); err == nil {
+				return tr.TaskID, nil
+			}
+			// the connection went away under us; queue instead
+			releaseSlot(vpsId, tr.TaskID)
+		}
+	}
+
+	now := time.Now().UTC()
+	q := QueuedTask{TaskID: tr.TaskID, VPSID: vpsId, Task: task, Args: args, QueuedAt: now, ExpiresAt: now.Add(ttl)}
+	recordTaskQueued(vpsId, q.TaskID, task, args)
+	queueMtx.Lock()
+	if len(taskQueue[vpsId]) >= maxQueuedPerVPS {
+		queueMtx.Unlock()
+		err := fmt.Errorf("the offline queue for %s is full (%d tasks)", vpsId, maxQueuedPerVPS)
+		transitionTask(q.TaskID, TaskStatusQueued, TaskStatusFailed, err.Error())
+		return "", err
+	}
+	taskQueue[vpsId] = append(taskQueue[vpsId], q)
+	persistQueue()
+	queueMtx.Unlock()
+
+	// the agent may have connected between the check and the append
+	if a, ok := AgentByVPS(vpsId); ok {
+		go deliverQueued(a)
+	}
+	return q.TaskID, nil
+}
+
+// QueuedTasks returns the tasks waiting for the agent on vpsId, oldest first.
+func QueuedTasks(vpsId string) []QueuedTask {
+	queueMtx.Lock()
+	defer queueMtx.Unlock()
+	return append([]QueuedTask(nil), taskQueue[vpsId]...)
+}
+
+func hasQueued(vpsId string) bool {
+	queueMtx.Lock()
+	defer queueMtx.Unlock()
+	return len(taskQueue[vpsId]) > 0
+}
+
+// deliverQueued sends a's queued tasks one at a time, oldest first. It stops
+// at the first send error; the rest wait for the next connection, or for a
+// slot when the agent is busy. Only one delivery runs per VPS.
+func deliverQueued(a *AgentConn) {
+	queueMtx.Lock()
+	if delivering[a.VPSID] || Draining() {
+		queueMtx.Unlock()
+		return
+	}
+	delivering[a.VPSID] = true
+	queueMtx.Unlock()
+
+	for {
+		queueMtx.Lock()
+		cur, ok := AgentByVPS(a.VPSID)
+		if len(taskQueue[a.VPSID]) == 0 || !ok || cur != a {
+			delete(delivering, a.VPSID)
+			queueMtx.Unlock()
+			return
+		}
+		q := taskQueue[a.VPSID][0]
+		queueMtx.Unlock()
+
+		if err := deliver(a, q); err != nil {
+			if !errors.Is(err, errAgentBusy) {
+				log.Printf("task queue: delivering %s to %s: %v", q.TaskID, a.VPSID, err)
+			}
+			queueMtx.Lock()
+			delete(delivering, a.VPSID)
+			queueMtx.Unlock()
+			return
+		}
+	}
+}
+
+// deliver signs q afresh and sends it on a. A task that can no longer be
+// signed (its keys or catalog entry are gone) or that the reconnected agent
+// does not support fails rather than blocking the ones behind it.
+func deliver(a *AgentConn, q QueuedTask) error {
+	if time.Now().After(q.ExpiresAt) {
+		dequeue(q)
+		expireQueued(q)
+		return nil
+	}
+	if err := checkCanRun(a, q.Task); err != nil {
+		dequeue(q)
+		transitionTask(q.TaskID, TaskStatusQueued, TaskStatusFailed, err.Error())
+		return nil
+	}
+	tr, _, err := signTask(q.VPSID, q.TaskID, q.Task, q.Args)
+	if err != nil {
+		dequeue(q)
+		transitionTask(q.TaskID, TaskStatusQueued, TaskStatusFailed, "could not sign queued task: "+err.Error())
+		return nil
+	}
+	payload, err := json.Marshal(tr)
+	if err != nil {
+		return err
+	}
+
+	if !tryAcquireSlot(q.VPSID, q.TaskID, q.Task) {
+		return errAgentBusy
+	}
+	// mark it running before the send so an immediate result finds it; a
+	// task that expired meanwhile is not sent
+	if !transitionTask(q.TaskID, TaskStatusQueued, TaskStatusRunning, "") {
+		releaseSlot(q.VPSID, q.TaskID)
+		dequeue(q)
+		return nil
+	}
+	if err := a.send(payload); err != nil {
+		transitionTask(q.TaskID, TaskStatusRunning, TaskStatusQueued, "")
+		releaseSlot(q.VPSID, q.TaskID)
+		return err
+	}
+	dequeue(q)
+	log.Printf("task queue: delivered %s (%s) to %s after %s", q.TaskID, q.Task, q.VPSID, time.Since(q.QueuedAt).Round(time.Second))
+	return nil
+}
+
+func dequeue(q QueuedTask) {
+	queueMtx.Lock()
+	defer queueMtx.Unlock()
+	list := taskQueue[q.VPSID]
+	for i, x := range list {
+		if x.TaskID == q.TaskID {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(taskQueue, q.VPSID)
+	} else {
+		taskQueue[q.VPSID] = list
+	}
+	persistQueue()
+}
+
+// cancelQueued drops taskID from vpsId's offline queue and marks it
+// cancelled.
+func cancelQueued(vpsId, taskID string) error {
+	dequeue(QueuedTask{TaskID: taskID, VPSID: vpsId})
+	// a delivery that got there first has sent it
+	if !transitionTask(taskID, TaskStatusQueued, TaskStatusCancelled, "cancelled before it was delivered") {
+		return fmt.Errorf("task %s was just delivered; cancel it again", taskID)
+	}
+	return nil
+}
+
+func expireQueued(q QueuedTask) {
+	reason := fmt.Sprintf("not delivered within %s; the agent stayed offline", q.ExpiresAt.Sub(q.QueuedAt).Round(time.Second))
+	transitionTask(q.TaskID, TaskStatusQueued, TaskStatusExpired, reason)
+}
+
+// sweepQueue expires overdue tasks and retries delivery to agents that are
+// connected but still have tasks queued, here or on another node.
+func sweepQueue(now time.Time) {
+	var expired []QueuedTask
+	var waiting []string
+	queueMtx.Lock()
+	for vpsId, list := range taskQueue {
+		kept := list[:0]
+		for _, q := range list {
+			if now.After(q.ExpiresAt) {
+				expired = append(expired, q)
+			} else {
+				kept = append(kept, q)
+			}
+		}
+		if len(kept) == 0 {
+			delete(taskQueue, vpsId)
+			continue
+		}
+		taskQueue[vpsId] = kept
+		waiting = append(waiting, vpsId)
+	}
+	if len(expired) > 0 {
+		persistQueue()
+	}
+	queueMtx.Unlock()
+
+	for _, q := range expired {
+		expireQueued(q)
+	}
+	for _, vpsId := range waiting {
+		if a, ok := AgentByVPS(vpsId); ok {
+			go deliverQueued(a)
+		} else if owner, remote := remoteOwner(vpsId); remote && !Draining() {
+			forwardQueued(owner, vpsId)
+		}
+	}
+}
+
+// forwardQueued hands vpsId's queued tasks, oldest first, to the node its
+// agent is connected to.
+func forwardQueued(owner AgentOwner, vpsId string) {
+	for _, q := range QueuedTasks(vpsId) {
+		dequeue(q)
+		// a local delivery or a cancel got there first
+		if !transitionTask(q.TaskID, TaskStatusQueued, TaskStatusRunning, "") {
+			continue
+		}
+		log.Printf("task queue: forwarding %s (%s) to node %s after %s", q.TaskID, q.Task, owner.Node, time.Since(q.QueuedAt).Round(time.Second))
+		forwardInBackground(owner, vpsId, q.TaskID, q.Task, q.Args)
+	}
+}
+
+func queuePath() string {
+	if config.AppConfig == nil {
+		return ""
+	}
+	return config.AppConfig.TaskQueuePath
+}
+
+// loadQueue restores the queue and the records of its tasks. Callers hold
+// queueMtx.
+func loadQueue() error {
+	path := queuePath()
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored map[string][]QueuedTask
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return err
+	}
+	for vpsId, list := range stored {
+		for _, q := range list {
+			recordTaskQueued(q.VPSID, q.TaskID, q.Task, q.Args)
+		}
+		taskQueue[vpsId] = list
+	}
+	return nil
+}
+
+// persistQueue writes the queue atomically; failures are logged and the
+// in-memory queue stays authoritative. Callers hold queueMtx.
+func persistQueue() {
+	path := queuePath()
+	if path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(taskQueue, "", "  ")
+	if err != nil {
+		log.Printf("task queue: encode: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		log.Printf("task queue: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Printf("task queue: write: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("task queue: write: %v", err)
+	}
+}
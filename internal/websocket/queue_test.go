@@ -0,0 +1,232 @@
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ultahost-ai-gateway/internal/utils"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// saveTestKeys gives vpsId's agent keys so tasks for it can be signed.
+func saveTestKeys(vpsId string) {
+	utils.SaveAgentKeys("Agent_"+vpsId, utils.AgentKeys{SignatureSecret: "secret-" + vpsId})
+}
+
+// connectTestAgent registers a connected agent for vpsId that supports
+// supported, and returns the agent's end of the socket to read tasks from.
+func connectTestAgent(t *testing.T, vpsId string, supported ...string) (*AgentConn, *ws.Conn) {
+	t.Helper()
+	saveTestKeys(vpsId)
+
+	accepted := make(chan *ws.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&ws.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- c
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	a := &AgentConn{Conn: <-accepted, VPSID: vpsId, ConnectedAt: time.Now(), Hello: AgentHello{Tasks: supported}}
+	registerConn(a)
+	t.Cleanup(func() {
+		unregisterConn(a)
+		a.Conn.Close()
+	})
+	return a, client
+}
+
+// readTask reads the next task the agent was sent.
+func readTask(t *testing.T, client *ws.Conn) TaskRequest {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var tr TaskRequest
+	if err := client.ReadJSON(&tr); err != nil {
+		t.Fatalf("reading task: %v", err)
+	}
+	return tr
+}
+
+func taskStatus(t *testing.T, taskID string) string {
+	t.Helper()
+	rec, ok := GetTask(taskID)
+	if !ok {
+		t.Fatalf("task %s not recorded", taskID)
+	}
+	return rec.Status
+}
+
+func TestQueueDeliveryOrder(t *testing.T) {
+	const vpsId = "queue-order"
+	saveTestKeys(vpsId)
+	var ids []string
+	for _, task := range []string{"check_uptime", "check_memory", "install_wordpress"} {
+		id, err := QueueSignedTask(vpsId, task, nil, time.Hour)
+		if err != nil {
+			t.Fatalf("queue %s: %v", task, err)
+		}
+		if got := taskStatus(t, id); got != TaskStatusQueued {
+			t.Fatalf("%s status = %s, want queued", task, got)
+		}
+		ids = append(ids, id)
+	}
+	if q := QueuedTasks(vpsId); len(q) != 3 || q[0].TaskID != ids[0] || q[2].TaskID != ids[2] {
+		t.Fatalf("queue = %+v, want the three tasks oldest first", q)
+	}
+
+	// the reconnected agent no longer supports the installer; it fails
+	// without holding up the tasks around it
+	a, client := connectTestAgent(t, vpsId, "check_uptime", "check_memory")
+	deliverQueued(a)
+
+	for _, want := range ids[:2] {
+		tr := readTask(t, client)
+		if tr.TaskID != want {
+			t.Errorf("delivered %s, want %s", tr.TaskID, want)
+		}
+		if tr.Signature == "" || tr.Nonce == "" {
+			t.Errorf("task %s delivered unsigned", tr.TaskID)
+		}
+		if got := taskStatus(t, want); got != TaskStatusRunning {
+			t.Errorf("delivered task status = %s, want running", got)
+		}
+	}
+	if got := taskStatus(t, ids[2]); got != TaskStatusFailed {
+		t.Errorf("unsupported task status = %s, want failed", got)
+	}
+	if q := QueuedTasks(vpsId); len(q) != 0 {
+		t.Errorf("%d tasks left in the queue", len(q))
+	}
+	for _, id := range ids[:2] {
+		releaseSlot(vpsId, id)
+	}
+}
+
+func TestQueueExpiry(t *testing.T) {
+	tests := []struct {
+		name   string
+		expire func(t *testing.T, vpsId string)
+	}{
+		{
+			name:   "swept while offline",
+			expire: func(_ *testing.T, _ string) { sweepQueue(time.Now().Add(2 * time.Hour)) },
+		},
+		{
+			name: "expired on reconnect",
+			expire: func(t *testing.T, vpsId string) {
+				// as if queued an hour ago
+				queueMtx.Lock()
+				q := &taskQueue[vpsId][0]
+				q.QueuedAt = q.QueuedAt.Add(-time.Hour - time.Second)
+				q.ExpiresAt = q.ExpiresAt.Add(-time.Hour - time.Second)
+				queueMtx.Unlock()
+				a, client := connectTestAgent(t, vpsId, "check_uptime")
+				deliverQueued(a)
+				client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				if _, _, err := client.ReadMessage(); err == nil {
+					t.Error("expired task was delivered")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpsId := "queue-expiry-" + strings.ReplaceAll(tt.name, " ", "-")
+			saveTestKeys(vpsId)
+			id, err := QueueSignedTask(vpsId, "check_uptime", nil, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.expire(t, vpsId)
+
+			rec, _ := GetTask(id)
+			if rec.Status != TaskStatusExpired {
+				t.Errorf("status = %s, want expired", rec.Status)
+			}
+			if !strings.Contains(rec.Reason, "not delivered within 1h0m0s") {
+				t.Errorf("reason = %q", rec.Reason)
+			}
+			if q := QueuedTasks(vpsId); len(q) != 0 {
+				t.Errorf("%d tasks left in the queue", len(q))
+			}
+		})
+	}
+}
+
+func TestCancelQueuedTask(t *testing.T) {
+	const vpsId = "queue-cancel"
+	saveTestKeys(vpsId)
+	id, err := QueueSignedTask(vpsId, "check_uptime", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelTask(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := taskStatus(t, id); got != TaskStatusCancelled {
+		t.Errorf("status = %s, want cancelled", got)
+	}
+	if q := QueuedTasks(vpsId); len(q) != 0 {
+		t.Errorf("%d tasks left in the queue", len(q))
+	}
+
+	// nothing is delivered when the agent comes back
+	a, client := connectTestAgent(t, vpsId, "check_uptime")
+	deliverQueued(a)
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Error("cancelled task was delivered")
+	}
+}
+
+func TestQueueTTLBounds(t *testing.T) {
+	for _, ttl := range []time.Duration{0, -time.Minute, MaxQueueTTL + time.Second} {
+		if _, err := QueueSignedTask("queue-ttl", "check_uptime", nil, ttl); err == nil {
+			t.Errorf("ttl %s accepted", ttl)
+		}
+	}
+}
+
+func TestRecordTaskKeepsUnfinished(t *testing.T) {
+	const vpsId = "store-evict"
+	saveTestKeys(vpsId)
+	queued, err := QueueSignedTask(vpsId, "check_uptime", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cancelQueued(vpsId, queued) })
+
+	var first string
+	for i := range maxTasksPerVPS + 5 {
+		id := fmt.Sprintf("evict-%d", i)
+		if i == 0 {
+			first = id
+		}
+		recordTaskSent(vpsId, id, "check_uptime", nil)
+		transitionTask(id, TaskStatusRunning, TaskStatusCompleted, "")
+	}
+	if _, ok := GetTask(queued); !ok {
+		t.Error("queued task evicted")
+	}
+	if _, ok := GetTask(first); ok {
+		t.Error("oldest finished task kept")
+	}
+	if n := len(RecentTasks(vpsId, 2*maxTasksPerVPS)); n != maxTasksPerVPS {
+		t.Errorf("%d tasks kept, want %d", n, maxTasksPerVPS)
+	}
+}
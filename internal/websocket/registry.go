@@ -0,0 +1,88 @@
+// internal/websocket/registry.go
+package websocket
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// close code sent to a connection replaced by a newer one from the same
+// agent; agents must not reconnect on it
+const closeSuperseded = 4000
+
+var (
+	connectedMtx   sync.RWMutex
+	agentConns     = make(map[string]*AgentConn) // vpsId -> current connection
+	lastGeneration uint64
+)
+
+// vpsIDFromCN maps an agent certificate CN (Agent_<vpsId>) to the VPS id.
+func vpsIDFromCN(cn string) string {
+	return strings.TrimPrefix(cn, "Agent_")
+}
+
+// registerConn makes a the current connection for its VPS, giving it the
+// next generation, and claims the agent for this node. A connection it
+// replaces is closed with a close frame; its read loop then cleans up only
+// its own state.
+func registerConn(a *AgentConn) {
+	connectedMtx.Lock()
+	lastGeneration++
+	a.Generation = lastGeneration
+	old := agentConns[a.VPSID]
+	agentConns[a.VPSID] = a
+	connectedMtx.Unlock()
+
+	claimAgent(a)
+	if old != nil {
+		log.Printf("agent %s reconnected (generation %d replaces %d)", a.VPSID, a.Generation, old.Generation)
+		old.close(closeSuperseded, "superseded by a newer connection")
+	}
+}
+
+// unregisterConn removes a if it is still the current connection for its
+// VPS and reports whether it was.
+func unregisterConn(a *AgentConn) bool {
+	connectedMtx.Lock()
+	cur, ok := agentConns[a.VPSID]
+	current := ok && cur.Generation == a.Generation
+	if current {
+		delete(agentConns, a.VPSID)
+	}
+	connectedMtx.Unlock()
+
+	if current {
+		releaseAgent(a)
+	}
+	return current
+}
+
+// AgentByVPS returns the current connection of the agent on vpsId.
+func AgentByVPS(vpsId string) (*AgentConn, bool) {
+	connectedMtx.RLock()
+	defer connectedMtx.RUnlock()
+	a, ok := agentConns[vpsId]
+	return a, ok
+}
+
+func (a *AgentConn) send(payload []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return a.Conn.WriteMessage(ws.TextMessage, payload)
+}
+
+// close sends a close frame with code and reason, then closes the socket.
+func (a *AgentConn) close(code int, reason string) {
+	a.mu.Lock()
+	err := a.Conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+	a.mu.Unlock()
+	if err != nil && err != ws.ErrCloseSent {
+		log.Printf("close frame to agent %s (generation %d): %v", a.VPSID, a.Generation, err)
+	}
+	a.Conn.Close()
+}
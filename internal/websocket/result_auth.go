@@ -0,0 +1,187 @@
+// internal/websocket/result_auth.go
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/utils"
+)
+
+// results signed further from now than this are rejected
+const maxResultSkew = 5 * time.Minute
+
+// keep only the most recent audit events in memory
+const maxAuditEvents = 500
+
+// resultCanonicalString must exactly match the agent's canonical string for
+// HMAC. Output fields enter as SHA-256 hex digests, of the frame as sent:
+// for a streamed result only the unstreamed remainder is covered; chunks are
+// checked against the connection they arrive on.
+func resultCanonicalString(r TaskResult) string {
+	return strings.Join([]string{
+		"v1", "result",
+		r.TaskID,
+		r.Task,
+		strconv.Itoa(r.ExitCode),
+		sha256Hex(r.Stdout),
+		sha256Hex(r.Stderr),
+		r.StartedAt,
+		r.FinishedAt,
+		strconv.FormatInt(r.DurationSec, 10),
+		strconv.FormatBool(r.ChrootUsed),
+		strconv.FormatBool(r.CgroupUsed),
+		strconv.FormatBool(r.SignatureOK),
+		r.ScriptSHA256,
+		sha256Hex(string(r.Manifest)),
+		strconv.FormatBool(r.Cancelled),
+		strconv.FormatBool(r.Streamed),
+		strconv.FormatBool(r.Truncated),
+		sha256Hex(secretsCanonicalString(r.Secrets)),
+		r.Nonce,
+		r.Timestamp,
+	}, "|")
+}
+
+// secretsCanonicalString is the form the secrets are signed in: one
+// "name=<SHA-256 hex of value>" line per secret, sorted by name and joined
+// with "\n"; empty when there are none. Hashing the values keeps the lines
+// unambiguous whatever they contain; names may not contain "=" or "\n"
+// (see checkSecretNames).
+func secretsCanonicalString(secrets map[string]string) string {
+	names := make([]string, 0, len(secrets))
+	for k := range secrets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, k := range names {
+		lines[i] = k + "=" + sha256Hex(secrets[k])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkSecretNames rejects secret names that would make the canonical
+// string ambiguous.
+func checkSecretNames(secrets map[string]string) error {
+	for k := range secrets {
+		if k == "" || strings.ContainsAny(k, "=\n") {
+			return fmt.Errorf("invalid secret name %q", k)
+		}
+	}
+	return nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyTaskResult checks the result's signature with the sending agent's
+// secret and that the agent is the one the task was sent to.
+func verifyTaskResult(r TaskResult, keyInfo utils.AgentKeys, a *AgentConn) error {
+	if r.Signature == "" {
+		return errors.New("result is not signed")
+	}
+	if err := checkSecretNames(r.Secrets); err != nil {
+		return err
+	}
+	ts, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid result timestamp: %w", err)
+	}
+	if d := time.Since(ts); d > maxResultSkew || d < -maxResultSkew {
+		return errors.New("result timestamp outside allowed skew")
+	}
+	expected := utils.HMACSHA256Base64([]byte(keyInfo.SignatureSecret), resultCanonicalString(r))
+	if !hmac.Equal([]byte(expected), []byte(r.Signature)) {
+		return errors.New("invalid result signature")
+	}
+	// its chunks were refused, so the result would be missing output
+	if r.Streamed && !a.HasFeature(FeatureStreaming) {
+		return fmt.Errorf("streamed result from an agent that did not announce %s", FeatureStreaming)
+	}
+	return checkResultSender(r.TaskID, keyInfo.IdentityToken, a.VPSID)
+}
+
+// checkResultSender accepts a result only from the agent its task was sent
+// to, and only once.
+func checkResultSender(taskID, identity, vpsId string) error {
+	pendingMtx.Lock()
+	entry, pending := pendingMap[taskID]
+	pendingMtx.Unlock()
+	if pending && entry.agentIdentity != identity {
+		return fmt.Errorf("task %s was sent to agent %s", taskID, entry.agentIdentity)
+	}
+
+	rec, ok := GetTask(taskID)
+	if !ok {
+		if pending {
+			return nil
+		}
+		return fmt.Errorf("unknown task %s", taskID)
+	}
+	if rec.VPSID != vpsId {
+		return fmt.Errorf("task %s belongs to VPS %s", taskID, rec.VPSID)
+	}
+	// a result that arrives after the gateway timed the task out is still
+	// recorded; a second one is a replay
+	if rec.Result != nil {
+		return fmt.Errorf("task %s already has a result", taskID)
+	}
+	if rec.Status != TaskStatusRunning && rec.Status != TaskStatusTimedOut {
+		return fmt.Errorf("task %s is %s", taskID, rec.Status)
+	}
+	return nil
+}
+
+// AuditEvent records a message the gateway refused from an agent
+type AuditEvent struct {
+	At            time.Time `json:"at"`
+	Kind          string    `json:"kind"` // e.g. task_result_rejected
+	VPSID         string    `json:"vps_id"`
+	AgentIdentity string    `json:"agent_identity"`
+	TaskID        string    `json:"task_id,omitempty"`
+	Reason        string    `json:"reason"`
+}
+
+var (
+	auditMtx    sync.Mutex
+	auditEvents []AuditEvent // oldest first
+)
+
+func recordAudit(ev AuditEvent) {
+	ev.At = time.Now().UTC()
+	log.Printf("audit: %s from agent %s (VPS %s) task=%s: %s", ev.Kind, ev.AgentIdentity, ev.VPSID, ev.TaskID, ev.Reason)
+
+	auditMtx.Lock()
+	defer auditMtx.Unlock()
+	auditEvents = append(auditEvents, ev)
+	if len(auditEvents) > maxAuditEvents {
+		auditEvents = auditEvents[len(auditEvents)-maxAuditEvents:]
+	}
+}
+
+// AuditEvents returns up to limit events for vpsId recorded on this node,
+// newest first.
+func AuditEvents(vpsId string, limit int) []AuditEvent {
+	auditMtx.Lock()
+	defer auditMtx.Unlock()
+
+	out := make([]AuditEvent, 0, min(max(limit, 0), maxAuditEvents))
+	for i := len(auditEvents) - 1; i >= 0 && len(out) < limit; i-- {
+		if auditEvents[i].VPSID == vpsId {
+			out = append(out, auditEvents[i])
+		}
+	}
+	return out
+}
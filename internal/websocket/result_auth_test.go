@@ -0,0 +1,205 @@
+package websocket
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ultahost-ai-gateway/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+// signedResult returns a completed result for taskID signed with keys.
+func signedResult(keys utils.AgentKeys, taskID string, edit func(*TaskResult)) TaskResult {
+	r := TaskResult{
+		TaskID:    taskID,
+		Task:      "check_uptime",
+		Stdout:    " 10:00:00 up 1 day,  load average: 0.50, 0.25, 0.10\n",
+		Secrets:   map[string]string{"password": "p=w\nd", "user": "app"},
+		Nonce:     uuid.NewString(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if edit != nil {
+		edit(&r)
+	}
+	r.Signature = utils.HMACSHA256Base64([]byte(keys.SignatureSecret), resultCanonicalString(r))
+	return r
+}
+
+func TestVerifyTaskResult(t *testing.T) {
+	keys := utils.AgentKeys{IdentityToken: "agent-1", SignatureSecret: "secret-1"}
+	a := &AgentConn{VPSID: "result-auth"}
+
+	tests := []struct {
+		name    string
+		result  func(taskID string) TaskResult
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			result: func(id string) TaskResult { return signedResult(keys, id, nil) },
+		},
+		{
+			name: "unsigned",
+			result: func(id string) TaskResult {
+				r := signedResult(keys, id, nil)
+				r.Signature = ""
+				return r
+			},
+			wantErr: "not signed",
+		},
+		{
+			name: "wrong secret",
+			result: func(id string) TaskResult {
+				return signedResult(utils.AgentKeys{SignatureSecret: "other"}, id, nil)
+			},
+			wantErr: "invalid result signature",
+		},
+		{
+			name: "output changed after signing",
+			result: func(id string) TaskResult {
+				r := signedResult(keys, id, nil)
+				r.Stdout = "tampered"
+				return r
+			},
+			wantErr: "invalid result signature",
+		},
+		{
+			name: "secret changed after signing",
+			result: func(id string) TaskResult {
+				r := signedResult(keys, id, nil)
+				r.Secrets = map[string]string{"password": "p=w", "user": "app"}
+				return r
+			},
+			wantErr: "invalid result signature",
+		},
+		{
+			name: "secret lines reshaped",
+			result: func(id string) TaskResult {
+				r := signedResult(keys, id, nil)
+				r.Secrets = map[string]string{"password": "p", "w\nd": "", "user": "app"}
+				return r
+			},
+			wantErr: "invalid secret name",
+		},
+		{
+			name: "stale timestamp",
+			result: func(id string) TaskResult {
+				return signedResult(keys, id, func(r *TaskResult) {
+					r.Timestamp = time.Now().Add(-maxResultSkew - time.Minute).UTC().Format(time.RFC3339Nano)
+				})
+			},
+			wantErr: "outside allowed skew",
+		},
+		{
+			name: "streamed by an agent without streaming",
+			result: func(id string) TaskResult {
+				return signedResult(keys, id, func(r *TaskResult) { r.Streamed = true })
+			},
+			wantErr: "did not announce streaming",
+		},
+		{
+			name: "unknown task",
+			result: func(string) TaskResult {
+				return signedResult(keys, uuid.NewString(), nil)
+			},
+			wantErr: "unknown task",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := uuid.NewString()
+			recordTaskSent(a.VPSID, taskID, "check_uptime", nil)
+			err := verifyTaskResult(tt.result(taskID), keys, a)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyTaskResultReplay(t *testing.T) {
+	keys := utils.AgentKeys{IdentityToken: "agent-1", SignatureSecret: "secret-1"}
+	a := &AgentConn{VPSID: "result-replay"}
+	taskID := uuid.NewString()
+	recordTaskSent(a.VPSID, taskID, "check_uptime", nil)
+
+	r := signedResult(keys, taskID, nil)
+	if err := verifyTaskResult(r, keys, a); err != nil {
+		t.Fatalf("first result rejected: %v", err)
+	}
+	recordTaskResult(r)
+	if err := verifyTaskResult(r, keys, a); err == nil || !strings.Contains(err.Error(), "already has a result") {
+		t.Errorf("replayed result: error = %v", err)
+	}
+
+	// a result for another VPS's task, or from another agent than the one
+	// the task was sent to, is refused even when correctly signed
+	other := uuid.NewString()
+	recordTaskSent("someone-else", other, "check_uptime", nil)
+	if err := verifyTaskResult(signedResult(keys, other, nil), keys, a); err == nil || !strings.Contains(err.Error(), "belongs to VPS") {
+		t.Errorf("foreign task: error = %v", err)
+	}
+	sent := uuid.NewString()
+	recordTaskSent(a.VPSID, sent, "check_uptime", nil)
+	registerPending(sent, "agent-2", 1)
+	t.Cleanup(func() { unregisterPending(sent) })
+	if err := verifyTaskResult(signedResult(keys, sent, nil), keys, a); err == nil || !strings.Contains(err.Error(), "was sent to agent agent-2") {
+		t.Errorf("wrong agent: error = %v", err)
+	}
+}
+
+func TestSecretsCanonicalString(t *testing.T) {
+	tests := []struct {
+		secrets map[string]string
+		want    string
+	}{
+		{secrets: nil, want: ""},
+		{
+			// sorted by name; HTML-sensitive values are not escaped
+			secrets: map[string]string{"user": "app", "password": "<&>"},
+			want:    "password=" + sha256Hex("<&>") + "\nuser=" + sha256Hex("app"),
+		},
+	}
+	for _, tt := range tests {
+		if got := secretsCanonicalString(tt.secrets); got != tt.want {
+			t.Errorf("secretsCanonicalString(%v) = %q, want %q", tt.secrets, got, tt.want)
+		}
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	vpsId := "audit-" + uuid.NewString()
+	for _, kind := range []string{"first", "second", "third"} {
+		recordAudit(AuditEvent{Kind: kind, VPSID: vpsId})
+	}
+	recordAudit(AuditEvent{Kind: "other", VPSID: "audit-other"})
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{limit: 2, want: []string{"third", "second"}},
+		{limit: 10, want: []string{"third", "second", "first"}},
+		{limit: 0, want: nil},
+		{limit: -1, want: nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, ev := range AuditEvents(vpsId, tt.limit) {
+			got = append(got, ev.Kind)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("AuditEvents(limit %d) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
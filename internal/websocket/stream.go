@@ -0,0 +1,368 @@
+// internal/websocket/stream.go
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+const (
+	// reassembled output kept per stream of a task; the rest is dropped and
+	// the result marked truncated
+	maxStreamBytes = 8 << 20
+	// chunks held while waiting for a gap to fill
+	maxPendingChunks = 1024
+	// events buffered per subscriber; a subscriber that falls further behind
+	// misses events rather than stalling the agent's read loop
+	subscriberBuffer = 256
+)
+
+// event kinds
+const (
+	EventOutput   = "output"
+	EventProgress = "progress"
+	EventResult   = "result"
+	EventQueued   = "queued" // a waiting task's queue position changed
+)
+
+// StreamChunk is a task_output or task_progress message. Both share one
+// sequence per task, starting at 1.
+type StreamChunk struct {
+	Type      string `json:"type"` // task_output or task_progress
+	TaskID    string `json:"task_id"`
+	Seq       uint64 `json:"seq"`
+	Stream    string `json:"stream,omitempty"` // stdout or stderr, for task_output
+	Data      string `json:"data,omitempty"`
+	Step      string `json:"step,omitempty"` // for task_progress
+	StepIndex int    `json:"step_index,omitempty"`
+	StepTotal int    `json:"step_total,omitempty"`
+}
+
+// TaskEvent is what subscribers receive, in sequence order
+type TaskEvent struct {
+	TaskID    string      `json:"task_id"`
+	Kind      string      `json:"kind"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Stream    string      `json:"stream,omitempty"`
+	Data      string      `json:"data,omitempty"`
+	Step      string      `json:"step,omitempty"`
+	StepIndex int         `json:"step_index,omitempty"`
+	StepTotal int         `json:"step_total,omitempty"`
+	Position  int         `json:"position,omitempty"` // for EventQueued
+	Result    *TaskRecord `json:"result,omitempty"`   // for EventResult
+}
+
+// taskStream reassembles one task's chunks
+type taskStream struct {
+	vpsId     string
+	nextSeq   uint64
+	pending   map[uint64]StreamChunk
+	stdout    strings.Builder
+	stderr    strings.Builder
+	truncated bool
+	subs      map[chan TaskEvent]bool
+}
+
+var (
+	streamsMtx sync.Mutex
+	streams    = map[string]*taskStream{} // taskID -> stream, while the task runs
+)
+
+func init() {
+	OnTaskFinished(endStream)
+}
+
+func streamFor(taskID, vpsId string) *taskStream {
+	st, ok := streams[taskID]
+	if !ok {
+		st = &taskStream{vpsId: vpsId, nextSeq: 1, pending: map[uint64]StreamChunk{}, subs: map[chan TaskEvent]bool{}}
+		streams[taskID] = st
+	}
+	return st
+}
+
+// handleStreamChunk accepts a chunk from the agent on a. Duplicates are
+// dropped; early chunks wait until the gap before them is filled. Agents
+// that did not announce FeatureStreaming may not stream.
+func handleStreamChunk(a *AgentConn, msg []byte) error {
+	if !a.HasFeature(FeatureStreaming) {
+		return fmt.Errorf("agent did not announce %s", FeatureStreaming)
+	}
+	var c StreamChunk
+	if err := json.Unmarshal(msg, &c); err != nil {
+		return err
+	}
+	if c.Seq == 0 {
+		return fmt.Errorf("chunk without seq for task %s", c.TaskID)
+	}
+
+	// the status is checked under streamsMtx so a stream is never created
+	// after endStream has run for the task
+	streamsMtx.Lock()
+	rec, ok := GetTask(c.TaskID)
+	if !ok || rec.Status != TaskStatusRunning {
+		streamsMtx.Unlock()
+		return nil // late chunk for a finished or forgotten task
+	}
+	if rec.VPSID != a.VPSID {
+		streamsMtx.Unlock()
+		return fmt.Errorf("chunk for task %s of another VPS", c.TaskID)
+	}
+	st := streamFor(c.TaskID, rec.VPSID)
+	if _, dup := st.pending[c.Seq]; c.Seq < st.nextSeq || dup {
+		streamsMtx.Unlock()
+		return nil
+	}
+	if len(st.pending) >= maxPendingChunks {
+		// the gap isn't going to fill; skip it
+		st.nextSeq = lowestSeq(st.pending)
+	}
+	st.pending[c.Seq] = c
+
+	var ready []StreamChunk
+	for {
+		next, ok := st.pending[st.nextSeq]
+		if !ok {
+			break
+		}
+		delete(st.pending, st.nextSeq)
+		st.nextSeq++
+		st.append(next)
+		ready = append(ready, next)
+	}
+	st.publish(ready)
+	streamsMtx.Unlock()
+
+	for _, c := range ready {
+		recordChunk(c)
+	}
+	return nil
+}
+
+func lowestSeq(m map[uint64]StreamChunk) uint64 {
+	var low uint64
+	for s := range m {
+		if low == 0 || s < low {
+			low = s
+		}
+	}
+	return low
+}
+
+func (st *taskStream) append(c StreamChunk) {
+	var b *strings.Builder
+	switch {
+	case c.Type != "task_output":
+		return
+	case c.Stream == "stderr":
+		b = &st.stderr
+	default:
+		b = &st.stdout
+	}
+	if b.Len()+len(c.Data) > maxStreamBytes {
+		st.truncated = true
+		return
+	}
+	b.WriteString(c.Data)
+}
+
+// publish must be called with streamsMtx held.
+func (st *taskStream) publish(chunks []StreamChunk) {
+	for _, c := range chunks {
+		ev := TaskEvent{TaskID: c.TaskID, Seq: c.Seq, Kind: EventOutput, Stream: c.Stream, Data: c.Data}
+		if c.Type == "task_progress" {
+			ev = TaskEvent{TaskID: c.TaskID, Seq: c.Seq, Kind: EventProgress, Step: c.Step, StepIndex: c.StepIndex, StepTotal: c.StepTotal}
+		}
+		for ch := range st.subs {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// publishQueuePosition tells a waiting task's subscribers its new place in
+// the agent's queue.
+func publishQueuePosition(taskID string, position int) {
+	streamsMtx.Lock()
+	defer streamsMtx.Unlock()
+	st, ok := streams[taskID]
+	if !ok {
+		return
+	}
+	ev := TaskEvent{TaskID: taskID, Kind: EventQueued, Position: position}
+	for ch := range st.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// completeFromStream fills a streamed result's stdout/stderr from the
+// reassembled chunks, including any still waiting behind a gap.
+func completeFromStream(res TaskResult) TaskResult {
+	streamsMtx.Lock()
+	defer streamsMtx.Unlock()
+
+	st, ok := streams[res.TaskID]
+	if !ok || !res.Streamed {
+		return res
+	}
+	seqs := make([]uint64, 0, len(st.pending))
+	for s := range st.pending {
+		seqs = append(seqs, s)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, s := range seqs {
+		st.append(st.pending[s])
+	}
+	if len(seqs) > 0 {
+		log.Printf("task %s finished with %d chunks missing before seq %d", res.TaskID, int(seqs[0]-st.nextSeq), seqs[0])
+	}
+	st.pending = map[uint64]StreamChunk{}
+
+	res.Stdout = st.stdout.String() + res.Stdout
+	res.Stderr = st.stderr.String() + res.Stderr
+	res.Truncated = res.Truncated || st.truncated
+	return res
+}
+
+// endStream sends the final record to subscribers and forgets the stream.
+func endStream(rec TaskRecord) {
+	streamsMtx.Lock()
+	defer streamsMtx.Unlock()
+	st, ok := streams[rec.TaskID]
+	if !ok {
+		return
+	}
+	delete(streams, rec.TaskID)
+	ev := TaskEvent{TaskID: rec.TaskID, Kind: EventResult, Result: &rec}
+	for ch := range st.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+		delete(st.subs, ch)
+		close(ch)
+	}
+}
+
+// finishedEvents is the channel handed to subscribers of a finished task.
+func finishedEvents(rec TaskRecord) chan TaskEvent {
+	ch := make(chan TaskEvent, 1)
+	ch <- TaskEvent{TaskID: rec.TaskID, Kind: EventResult, Result: &rec}
+	close(ch)
+	return ch
+}
+
+// SubscribeTask streams a task's events in sequence order. The snapshot
+// holds the output received so far, or the queue position of a task still
+// waiting for a slot; the channel closes after the result event, which
+// comes right away for a task that has already finished. Call cancel to
+// stop receiving.
+func SubscribeTask(taskID string) (snapshot []TaskEvent, events <-chan TaskEvent, cancel func(), err error) {
+	streamsMtx.Lock()
+	defer streamsMtx.Unlock()
+
+	rec, ok := GetTask(taskID)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unknown task %s", taskID)
+	}
+	if rec.Status != TaskStatusRunning && rec.Status != TaskStatusWaiting {
+		return nil, finishedEvents(rec), func() {}, nil
+	}
+	st := streamFor(taskID, rec.VPSID)
+	if rec.QueuePosition > 0 {
+		snapshot = append(snapshot, TaskEvent{TaskID: taskID, Kind: EventQueued, Position: rec.QueuePosition})
+	}
+	if out := st.stdout.String(); out != "" {
+		snapshot = append(snapshot, TaskEvent{TaskID: taskID, Kind: EventOutput, Stream: "stdout", Data: out})
+	}
+	if out := st.stderr.String(); out != "" {
+		snapshot = append(snapshot, TaskEvent{TaskID: taskID, Kind: EventOutput, Stream: "stderr", Data: out})
+	}
+	if rec.Step != "" {
+		snapshot = append(snapshot, TaskEvent{TaskID: taskID, Kind: EventProgress, Step: rec.Step, StepIndex: rec.StepIndex, StepTotal: rec.StepTotal})
+	}
+
+	ch := make(chan TaskEvent, subscriberBuffer)
+	st.subs[ch] = true
+	cancel = func() {
+		streamsMtx.Lock()
+		defer streamsMtx.Unlock()
+		if st.subs[ch] {
+			delete(st.subs, ch)
+			close(ch)
+		}
+	}
+	return snapshot, ch, cancel, nil
+}
+
+// ServeTaskEvents upgrades a browser request to a websocket and writes the
+// task's events to it as JSON until the task finishes or the browser goes
+// away. The request carries a token from IssueEventsToken in its token
+// query parameter.
+func ServeTaskEvents(w http.ResponseWriter, r *http.Request, taskID string) {
+	if !takeEventsToken(r.URL.Query().Get("token"), taskID) {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	snapshot, events, cancel, err := SubscribeTask(taskID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer cancel()
+
+	conn, err := browserUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("task %s events upgrade: %v", taskID, err)
+		return
+	}
+	defer conn.Close()
+
+	// the browser sends nothing; reading only notices when it leaves
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(ev TaskEvent) bool {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		return conn.WriteJSON(ev) == nil
+	}
+	for _, ev := range snapshot {
+		if !write(ev) {
+			return
+		}
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, "task finished"), time.Now().Add(writeTimeout))
+				return
+			}
+			if !write(ev) {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -2,14 +2,17 @@
 package websocket
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	"ultahost-ai-gateway/internal/tasks"
 	"ultahost-ai-gateway/internal/utils"
 
 	"github.com/google/uuid"
@@ -41,6 +44,29 @@ type TaskResult struct {
 	CgroupUsed   bool   `json:"cgroup_used"`
 	SignatureOK  bool   `json:"signature_ok"`
 	ScriptSHA256 string `json:"script_sha256"`
+
+	// Manifest is set by tasks that produce artifacts (backups): the files
+	// written and their checksums, as JSON.
+	Manifest json.RawMessage `json:"manifest,omitempty"`
+
+	// Cancelled is set when the agent stopped the task on a cancel request.
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// Streamed is set when the agent sent the output as task_output chunks;
+	// Stdout and Stderr then hold only what was not streamed, and the gateway
+	// prepends the reassembled chunks. Truncated is set when the output
+	// exceeded what the gateway keeps.
+	Streamed  bool `json:"streamed,omitempty"`
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Secrets carries generated credentials. They go only to the waiting
+	// caller and are never stored with the task record.
+	Secrets map[string]string `json:"secrets,omitempty"`
+
+	// the agent signs every result; see resultCanonicalString
+	Timestamp string `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 // canonicalString must exactly match the agent's canonical string for HMAC
@@ -48,25 +74,34 @@ func canonicalString(task string, args []string, nonce, ts string) string {
 	return fmt.Sprintf("v1|%s|%s|%s|%s", task, strings.Join(args, " "), nonce, ts)
 }
 
-// SendSignedTask sends a signed task to the agent and returns the generated taskID.
-// This does not wait for a result.
-func SendSignedTask(vpsId string, task string, args []string) (string, error) {
+// buildSignedTask validates args against the task catalog and returns the signed request.
+// Nothing that fails validation is ever signed.
+func buildSignedTask(vpsId string, task string, args []string) (TaskRequest, utils.AgentKeys, error) {
+	return signTask(vpsId, uuid.NewString(), task, args)
+}
+
+// signTask is buildSignedTask for a known taskID, such as a queued task
+// being delivered; every call gets a fresh timestamp and nonce.
+func signTask(vpsId, taskID, task string, args []string) (TaskRequest, utils.AgentKeys, error) {
+	if err := tasks.ValidateArgs(task, args); err != nil {
+		return TaskRequest{}, utils.AgentKeys{}, err
+	}
+
 	CN := "Agent_" + vpsId
 	keyInfo, exist := utils.GetAgentKeys(CN)
 	if !exist {
-		return "", fmt.Errorf("no key info for %s", CN)
+		return TaskRequest{}, utils.AgentKeys{}, fmt.Errorf("no key info for %s", CN)
 	}
 
-	ts := time.Now().UTC().Format(time.RFC3339)
+	ts := time.Now().UTC().Format(time.RFC3339Nano)
 	nonce := uuid.NewString()
-	taskID := uuid.NewString()
 
 	msg := canonicalString(task, args, nonce, ts)
 	mac := hmac.New(sha256.New, []byte(keyInfo.SignatureSecret))
 	mac.Write([]byte(msg))
 	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
 
-	tr := TaskRequest{
+	return TaskRequest{
 		Type:      "task",
 		TaskID:    taskID,
 		Task:      task,
@@ -74,6 +109,29 @@ func SendSignedTask(vpsId string, task string, args []string) (string, error) {
 		Timestamp: ts,
 		Nonce:     nonce,
 		Signature: sig,
+	}, keyInfo, nil
+}
+
+// Output is what task parsers read: the manifest when the agent sent one,
+// stdout otherwise.
+func (r TaskResult) Output() string {
+	if len(r.Manifest) > 0 {
+		return string(r.Manifest)
+	}
+	return r.Stdout
+}
+
+// SendSignedTask sends a signed task to the agent and returns the generated taskID.
+// This does not wait for a result. A task the agent has no room for yet
+// waits for a slot in the background, with status waiting; one for an agent
+// on another gateway node is forwarded in the background.
+func SendSignedTask(vpsId string, task string, args []string) (string, error) {
+	if Draining() {
+		return "", errDraining
+	}
+	tr, _, err := buildSignedTask(vpsId, task, args)
+	if err != nil {
+		return "", err
 	}
 
 	payload, err := json.Marshal(tr)
@@ -81,54 +139,128 @@ func SendSignedTask(vpsId string, task string, args []string) (string, error) {
 		return "", err
 	}
 
+	a, ok := AgentByVPS(vpsId)
+	if !ok {
+		if owner, remote := remoteOwner(vpsId); remote {
+			forwardInBackground(owner, vpsId, tr.TaskID, task, args)
+			return tr.TaskID, nil
+		}
+	}
+	if ok {
+		if err := checkCanRun(a, task); err != nil {
+			return "", err
+		}
+		if !tryAcquireSlot(vpsId, tr.TaskID, task) {
+			recordTaskWaiting(vpsId, tr.TaskID, task, args)
+			go sendWhenFree(vpsId, tr.TaskID, task, args)
+			return tr.TaskID, nil
+		}
+	}
+	recordTaskSent(vpsId, tr.TaskID, task, args)
 	if err := SendMessage(vpsId, payload); err != nil {
+		setTaskStatus(tr.TaskID, TaskStatusFailed)
 		return "", err
 	}
-	return taskID, nil
+	return tr.TaskID, nil
+}
+
+// sendWhenFree sends a waiting fire-and-forget task once the agent has a
+// slot for it, giving up after the task's default timeout.
+func sendWhenFree(vpsId, taskID, task string, args []string) {
+	if err := acquireSlot(context.Background(), vpsId, taskID, task, args, tasks.Timeout(task, 0)); err != nil {
+		log.Printf("task %s (%s) on %s: %v", taskID, task, vpsId, err)
+		return
+	}
+	// signed now so the agent sees a fresh timestamp
+	tr, _, err := signTask(vpsId, taskID, task, args)
+	if err != nil {
+		abandonSlot(vpsId, taskID, err)
+		return
+	}
+	payload, err := json.Marshal(tr)
+	if err != nil {
+		abandonSlot(vpsId, taskID, err)
+		return
+	}
+	recordTaskSent(vpsId, taskID, task, args)
+	if err := SendMessage(vpsId, payload); err != nil {
+		setTaskStatus(taskID, TaskStatusFailed)
+	}
 }
 
 // SendSignedTaskAndWait sends a signed task and waits up to `timeout` for a task_result from the agent.
+// The timeout is clamped to the task's catalog limits; zero uses the task default.
 // Returns the TaskResult or an error on send / timeout.
 func SendSignedTaskAndWait(vpsId string, task string, args []string, timeout time.Duration) (TaskResult, error) {
-	CN := "Agent_" + vpsId
-	keyInfo, exist := utils.GetAgentKeys(CN)
-	if !exist {
-		return TaskResult{}, fmt.Errorf("no key info for %s", CN)
-	}
-
-	// ts := time.Now().UTC().Format(time.RFC3339)
-	ts := time.Now().UTC().Format(time.RFC3339Nano)
+	return SendSignedTaskAndWaitContext(context.Background(), vpsId, task, args, timeout)
+}
 
-	nonce := uuid.NewString()
+// SendSignedTaskAndWaitContext is SendSignedTaskAndWait that also stops
+// waiting when ctx is done, asking the agent to cancel the task. An agent
+// connected to another gateway node is reached through the broker.
+func SendSignedTaskAndWaitContext(ctx context.Context, vpsId string, task string, args []string, timeout time.Duration) (TaskResult, error) {
 	taskID := uuid.NewString()
+	if _, local := AgentByVPS(vpsId); !local {
+		if owner, ok := remoteOwner(vpsId); ok {
+			return forwardTask(ctx, owner, vpsId, taskID, task, args, timeout)
+		}
+	}
+	return sendAndWait(ctx, vpsId, taskID, task, args, timeout)
+}
 
-	msg := canonicalString(task, args, nonce, ts)
-	mac := hmac.New(sha256.New, []byte(keyInfo.SignatureSecret))
-	mac.Write([]byte(msg))
-	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+// sendAndWait runs taskID on the agent connected to this node.
+func sendAndWait(ctx context.Context, vpsId, taskID, task string, args []string, timeout time.Duration) (TaskResult, error) {
+	if Draining() {
+		return TaskResult{}, errDraining
+	}
+	tr, keyInfo, err := signTask(vpsId, taskID, task, args)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	timeout = tasks.Timeout(task, timeout)
 
-	tr := TaskRequest{
-		Type:      "task",
-		TaskID:    taskID,
-		Task:      task,
-		Args:      args,
-		Timestamp: ts,
-		Nonce:     nonce,
-		Signature: sig,
+	// wait for the agent to have room for the task, for up to the task's
+	// timeout, then sign it afresh and send it on whichever connection the
+	// agent has by then
+	conn, connected := AgentByVPS(vpsId)
+	if connected {
+		if err := checkCanRun(conn, task); err != nil {
+			return TaskResult{}, err
+		}
+		if err := acquireSlot(ctx, vpsId, taskID, task, args, timeout); err != nil {
+			return TaskResult{}, err
+		}
+		if tr, keyInfo, err = signTask(vpsId, taskID, task, args); err != nil {
+			abandonSlot(vpsId, taskID, err)
+			return TaskResult{}, err
+		}
+		conn, connected = AgentByVPS(vpsId)
 	}
 
 	payload, err := json.Marshal(tr)
 	if err != nil {
+		abandonSlot(vpsId, taskID, err)
 		return TaskResult{}, err
 	}
 
-	// register pending before send so we don't race with an immediate result
-	ch := registerPending(taskID, keyInfo.IdentityToken)
+	// register pending before send so we don't race with an immediate result;
+	// it belongs to the connection it is sent on
+	var generation uint64
+	if connected {
+		generation = conn.Generation
+	}
+	ch := registerPending(taskID, keyInfo.IdentityToken, generation)
+	recordTaskSent(vpsId, taskID, task, args)
 
 	// try sending
-	if err := SendMessage(vpsId, payload); err != nil {
+	err = fmt.Errorf("no connected agent")
+	if connected {
+		err = conn.send(payload)
+	}
+	if err != nil {
 		// cleanup pending and return
 		unregisterPending(taskID)
+		setTaskStatus(taskID, TaskStatusFailed)
 		return TaskResult{}, fmt.Errorf("send message failed: %w", err)
 	}
 
@@ -138,6 +270,11 @@ func SendSignedTaskAndWait(vpsId string, task string, args []string, timeout tim
 		return res, nil
 	case <-time.After(timeout):
 		unregisterPending(taskID)
+		setTaskStatus(taskID, TaskStatusTimedOut)
 		return TaskResult{}, fmt.Errorf("timeout waiting for task result (task_id=%s)", taskID)
+	case <-ctx.Done():
+		unregisterPending(taskID)
+		cancelAbandoned(taskID, ctx.Err())
+		return TaskResult{}, fmt.Errorf("task %s abandoned: %w", taskID, ctx.Err())
 	}
 }
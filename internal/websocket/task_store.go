@@ -0,0 +1,271 @@
+// internal/websocket/task_store.go
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"ultahost-ai-gateway/internal/tasks"
+)
+
+const (
+	TaskStatusRunning   = "running"
+	TaskStatusCompleted = "completed"
+	TaskStatusFailed    = "failed"
+	TaskStatusTimedOut  = "timed_out"
+	TaskStatusCancelled = "cancelled" // reported by the agent after a cancel, or cancelled before it was sent
+	TaskStatusQueued    = "queued"    // waiting in the offline queue; see queue.go
+	TaskStatusExpired   = "expired"   // left the offline queue undelivered
+	TaskStatusWaiting   = "waiting"   // waiting for a free slot on a busy agent; see limits.go
+)
+
+// keep only the most recent finished tasks per VPS in memory
+const maxTasksPerVPS = 50
+
+// streamed output kept on a running task's record
+const recordOutputTail = 4096
+
+// TaskRecord is the gateway's view of a dispatched task
+type TaskRecord struct {
+	TaskID     string    `json:"task_id"`
+	VPSID      string    `json:"vps_id"`
+	Task       string    `json:"task"`
+	Args       []string  `json:"args,omitempty"`
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"` // why a task failed or expired without a result
+	QueuedAt   time.Time `json:"queued_at,omitempty"`
+	SentAt     time.Time `json:"sent_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	// QueuePosition is a waiting task's place in its agent's queue, from 1
+	QueuePosition int `json:"queue_position,omitempty"`
+	// CancelRequestedAt is when a cancel was sent; the status changes only
+	// once the agent reports back.
+	CancelRequestedAt time.Time `json:"cancel_requested_at,omitempty"`
+	// the last progress step and output streamed by the agent while the
+	// task runs
+	Step       string      `json:"step,omitempty"`
+	StepIndex  int         `json:"step_index,omitempty"`
+	StepTotal  int         `json:"step_total,omitempty"`
+	OutputTail string      `json:"output_tail,omitempty"`
+	Result     *TaskResult `json:"result,omitempty"`
+	Data       interface{} `json:"data,omitempty"` // parsed stdout, when the task has a parser
+}
+
+var (
+	taskStoreMtx sync.RWMutex
+	taskRecords  = map[string]*TaskRecord{} // taskID -> record
+	vpsTaskIndex = map[string][]string{}    // vpsId -> taskIDs, oldest first
+
+	listenersMtx  sync.RWMutex
+	taskListeners []func(TaskRecord)
+)
+
+// OnTaskFinished registers fn to be called with the record of every task
+// that reaches a final status. fn runs on the goroutine that finished the
+// task (usually the agent's read loop) and must not block for long.
+func OnTaskFinished(fn func(TaskRecord)) {
+	listenersMtx.Lock()
+	defer listenersMtx.Unlock()
+	taskListeners = append(taskListeners, fn)
+}
+
+func notifyTaskFinished(rec TaskRecord) {
+	listenersMtx.RLock()
+	fns := taskListeners
+	listenersMtx.RUnlock()
+	for _, fn := range fns {
+		fn(rec)
+	}
+}
+
+func recordTaskSent(vpsId, taskID, task string, args []string) {
+	recordTask(vpsId, taskID, task, args, TaskStatusRunning)
+}
+
+func recordTaskQueued(vpsId, taskID, task string, args []string) {
+	recordTask(vpsId, taskID, task, args, TaskStatusQueued)
+}
+
+func recordTaskWaiting(vpsId, taskID, task string, args []string) {
+	recordTask(vpsId, taskID, task, args, TaskStatusWaiting)
+}
+
+// recordTask creates the record for taskID, or resets the status of an
+// existing one (a task queued after its first send failed, or sent after
+// waiting for a slot).
+func recordTask(vpsId, taskID, task string, args []string, status string) {
+	taskStoreMtx.Lock()
+	defer taskStoreMtx.Unlock()
+
+	now := time.Now().UTC()
+	if rec, ok := taskRecords[taskID]; ok {
+		rec.Status, rec.FinishedAt, rec.QueuePosition = status, time.Time{}, 0
+		switch status {
+		case TaskStatusQueued:
+			rec.QueuedAt = now
+		case TaskStatusRunning:
+			rec.SentAt = now
+		}
+		return
+	}
+	rec := &TaskRecord{
+		TaskID: taskID,
+		VPSID:  vpsId,
+		Task:   task,
+		Args:   args,
+		Status: status,
+	}
+	switch status {
+	case TaskStatusQueued, TaskStatusWaiting:
+		rec.QueuedAt = now
+	default:
+		rec.SentAt = now
+	}
+	taskRecords[taskID] = rec
+
+	// evict the oldest finished tasks; unfinished ones stay until they end
+	ids := append(vpsTaskIndex[vpsId], taskID)
+	excess := len(ids) - maxTasksPerVPS
+	kept := ids[:0]
+	for _, id := range ids {
+		if excess > 0 && finalStatus(taskRecords[id].Status) {
+			delete(taskRecords, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	vpsTaskIndex[vpsId] = kept
+}
+
+// finalStatus reports whether a task in status has ended.
+func finalStatus(status string) bool {
+	switch status {
+	case TaskStatusRunning, TaskStatusQueued, TaskStatusWaiting:
+		return false
+	}
+	return true
+}
+
+// recordTaskResult stores the final result; unknown task ids are ignored.
+func recordTaskResult(res TaskResult) {
+	taskStoreMtx.Lock()
+	rec, ok := taskRecords[res.TaskID]
+	if !ok {
+		taskStoreMtx.Unlock()
+		return
+	}
+	r := res
+	r.Secrets = nil
+	rec.Result = &r
+	if data, err := tasks.ParseOutput(rec.Task, rec.Args, res.Output()); err == nil {
+		rec.Data = data
+	}
+	rec.FinishedAt = time.Now().UTC()
+	switch {
+	case res.Cancelled:
+		rec.Status = TaskStatusCancelled
+	case res.ExitCode == 0:
+		rec.Status = TaskStatusCompleted
+	default:
+		rec.Status = TaskStatusFailed
+	}
+	done := *rec
+	taskStoreMtx.Unlock()
+
+	notifyTaskFinished(done)
+}
+
+// recordChunk keeps a running task's progress and the tail of its output.
+func recordChunk(c StreamChunk) {
+	taskStoreMtx.Lock()
+	defer taskStoreMtx.Unlock()
+	rec, ok := taskRecords[c.TaskID]
+	if !ok || rec.Status != TaskStatusRunning {
+		return
+	}
+	if c.Type == "task_progress" {
+		rec.Step, rec.StepIndex, rec.StepTotal = c.Step, c.StepIndex, c.StepTotal
+		return
+	}
+	tail := rec.OutputTail + c.Data
+	if len(tail) > recordOutputTail {
+		tail = tail[len(tail)-recordOutputTail:]
+	}
+	rec.OutputTail = tail
+}
+
+func setTaskStatus(taskID, status string) {
+	transitionTask(taskID, TaskStatusRunning, status, "")
+}
+
+// transitionTask moves taskID from status from to status to and reports
+// whether it did. Moving to running marks the task sent; any status other
+// than running, queued or waiting is final.
+func transitionTask(taskID, from, to, reason string) bool {
+	taskStoreMtx.Lock()
+	rec, ok := taskRecords[taskID]
+	if !ok || rec.Status != from {
+		taskStoreMtx.Unlock()
+		return false
+	}
+	rec.Status, rec.Reason = to, reason
+	switch to {
+	case TaskStatusRunning:
+		rec.SentAt = time.Now().UTC()
+		fallthrough
+	case TaskStatusQueued, TaskStatusWaiting:
+		taskStoreMtx.Unlock()
+		return true
+	}
+	rec.FinishedAt = time.Now().UTC()
+	done := *rec
+	taskStoreMtx.Unlock()
+
+	notifyTaskFinished(done)
+	return true
+}
+
+// setQueuePosition updates a waiting task's place in its agent's queue.
+func setQueuePosition(taskID string, position int) {
+	taskStoreMtx.Lock()
+	defer taskStoreMtx.Unlock()
+	if rec, ok := taskRecords[taskID]; ok && rec.Status == TaskStatusWaiting {
+		rec.QueuePosition = position
+	}
+}
+
+func markCancelRequested(taskID string) {
+	taskStoreMtx.Lock()
+	defer taskStoreMtx.Unlock()
+	if rec, ok := taskRecords[taskID]; ok {
+		rec.CancelRequestedAt = time.Now().UTC()
+	}
+}
+
+// GetTask returns a copy of the record for taskID.
+func GetTask(taskID string) (TaskRecord, bool) {
+	taskStoreMtx.RLock()
+	defer taskStoreMtx.RUnlock()
+
+	rec, ok := taskRecords[taskID]
+	if !ok {
+		return TaskRecord{}, false
+	}
+	return *rec, true
+}
+
+// RecentTasks returns up to limit tasks for vpsId, newest first.
+func RecentTasks(vpsId string, limit int) []TaskRecord {
+	taskStoreMtx.RLock()
+	defer taskStoreMtx.RUnlock()
+
+	ids := vpsTaskIndex[vpsId]
+	out := make([]TaskRecord, 0, limit)
+	for i := len(ids) - 1; i >= 0 && len(out) < limit; i-- {
+		if rec, ok := taskRecords[ids[i]]; ok {
+			out = append(out, *rec)
+		}
+	}
+	return out
+}
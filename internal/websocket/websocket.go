@@ -2,10 +2,6 @@ package websocket
 
 import (
 	"fmt"
-	"time"
-	"ultahost-ai-gateway/internal/utils"
-
-	ws "github.com/gorilla/websocket"
 )
 
 // var ConnectedVPS = make(map[string]*websocket.Conn)
@@ -29,23 +25,22 @@ import (
 
 // }
 
+// SendMessage writes payload to the agent for vpsId. Only a connection to
+// this node is used; tasks for agents elsewhere go through the broker.
 func SendMessage(vpsId string, payload []byte) error {
-	CN := "Agent_" + vpsId
-	keyInfo, exist := utils.GetAgentKeys(CN)
-	if !exist {
-		return fmt.Errorf("no keys")
-	}
-
-	connectedMtx.RLock()
-	aConn, ok := ConnectedVPS[keyInfo.IdentityToken]
-	connectedMtx.RUnlock()
+	aConn, ok := AgentByVPS(vpsId)
 	if !ok {
 		return fmt.Errorf("no connected agent")
 	}
-
-	aConn.mu.Lock()
-	defer aConn.mu.Unlock()
-	aConn.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-	return aConn.Conn.WriteMessage(ws.TextMessage, payload)
+	return aConn.send(payload)
 }
 
+// IsAgentConnected reports whether the agent for vpsId has a live
+// connection, to this node or another.
+func IsAgentConnected(vpsId string) bool {
+	if _, ok := AgentByVPS(vpsId); ok {
+		return true
+	}
+	_, ok := remoteOwner(vpsId)
+	return ok
+}